@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"shared/envelope"
+	"shared/rabbit"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Auth event types, as listener-service handles them.
+const (
+	authLogin       = "login"
+	authLoginFailed = "login_failed"
+)
+
+// eventSource is the envelope source listener-service trusts auth events from.
+const eventSource = "auth-service"
+
+// publishTimeout bounds how long the publisher waits for RabbitMQ to confirm one event.
+const publishTimeout = 5 * time.Second
+
+// eventQueueSize is how many events wait for the publisher before new ones are dropped.
+const eventQueueSize = 1000
+
+// authEvent is the data of an "auth" event.
+type authEvent = envelope.AuthData
+
+// authEvents publishes "auth" events to logs_topic with the routing key
+// "auth.<type>", where listener-service logs them and notifies the user. Publishing is
+// best effort: events are queued and published by a goroutine, so a login never waits
+// for RabbitMQ nor fails because its event could not be published.
+type authEvents struct {
+	manager *rabbit.Manager
+	queue   chan authEvent
+	done    chan struct{}
+
+	// ch is only used by the publishing goroutine
+	ch *amqp.Channel
+}
+
+func newAuthEvents(manager *rabbit.Manager) (*authEvents, error) {
+	err := manager.Declare(func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclare("logs_topic", "topic", true, false, false, false, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &authEvents{
+		manager: manager,
+		queue:   make(chan authEvent, eventQueueSize),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// emit queues an auth event, and drops it when the queue is full. A nil authEvents,
+// without RabbitMQ, drops it too.
+func (e *authEvents) emit(eventType, email string) {
+	if e == nil {
+		return
+	}
+
+	select {
+	case e.queue <- authEvent{Type: eventType, Email: email, At: time.Now().UTC()}:
+	default:
+		log.Printf("dropped %s event: %d events are waiting to be published", eventType, eventQueueSize)
+	}
+}
+
+// Close publishes the queued events and stops the publisher. It waits until ctx ends
+// at most.
+func (e *authEvents) Close(ctx context.Context) error {
+	close(e.queue)
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run publishes the queued events one at a time, until Close.
+func (e *authEvents) run() {
+	defer close(e.done)
+	defer func() {
+		if e.ch != nil {
+			e.ch.Close()
+		}
+	}()
+
+	for ev := range e.queue {
+		if err := e.publish(ev); err != nil {
+			log.Printf("failed to publish %s event: %v", ev.Type, err)
+		}
+	}
+}
+
+func (e *authEvents) publish(ev authEvent) error {
+	env, err := envelope.New(envelope.TypeAuth, eventSource, ev)
+	if err != nil {
+		return err
+	}
+	msg, err := env.Publishing()
+	if err != nil {
+		return err
+	}
+
+	if e.ch == nil || e.ch.IsClosed() {
+		ch, err := e.manager.Channel()
+		if err != nil {
+			return err
+		}
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return err
+		}
+		e.ch = ch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	confirm, err := e.ch.PublishWithDeferredConfirmWithContext(ctx, "logs_topic", "auth."+ev.Type, false, false, msg)
+	if err != nil {
+		return err
+	}
+	ok, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("rabbitmq rejected the event")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"shared/rabbit"
+	"shared/rabbit/amqptest"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// startEvents connects auth events to a fake server on which the queue "auth" is bound
+// to every auth event.
+func startEvents(t *testing.T) (*amqptest.Server, *authEvents) {
+	t.Helper()
+
+	srv, err := amqptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	manager := rabbit.NewManager(srv.URL())
+	t.Cleanup(func() { manager.Close() })
+
+	events, err := newAuthEvents(manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Declare(func(ch *amqp.Channel) error {
+		if _, err := ch.QueueDeclare("auth", false, false, false, false, nil); err != nil {
+			return err
+		}
+		return ch.QueueBind("auth", "auth.#", "logs_topic", false, nil)
+	})
+	manager.Start()
+
+	select {
+	case <-manager.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the manager did not connect")
+	}
+	return srv, events
+}
+
+func TestAuthEventsPublish(t *testing.T) {
+	srv, events := startEvents(t)
+
+	events.emit(authLogin, "jane@example.com")
+	events.emit(authLoginFailed, "jane@example.com")
+	events.emit(authLogin, "john@example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := events.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Close returns once the queued events were published
+	if n := srv.Messages("auth"); n != 3 {
+		t.Errorf("queue holds %d events, want 3", n)
+	}
+}
+
+func TestAuthEventsDoNotBlock(t *testing.T) {
+	srv, events := startEvents(t)
+	srv.Stop()
+
+	start := time.Now()
+	for range 100 {
+		events.emit(authLogin, "jane@example.com")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("emitting while RabbitMQ is down took %v", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	events.Close(ctx)
+}
+
+func TestAuthEventsNil(t *testing.T) {
+	var events *authEvents
+	// without RabbitMQ the events are dropped
+	events.emit(authLogin, "jane@example.com")
+}
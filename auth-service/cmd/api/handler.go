@@ -7,7 +7,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
 )
 
 func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
@@ -23,33 +25,55 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// validate the user against the database
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
-	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+	user, err := app.login(requestPayload.Email, requestPayload.Password)
+	if errors.Is(err, errInvalidCredentials) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Logged in user %s", user.Email),
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// errInvalidCredentials is returned by login for an unknown email or a wrong password.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// login validates the credentials against the database, then records the login, logs
+// it and sends the welcome email. A wrong password for a known user publishes a
+// "login_failed" event, which warns the user. Authenticate and the "auth.authenticate"
+// RPC both go through here.
+func (app *Config) login(email, password string) (*data.User, error) {
+	user, err := app.Models.User.GetByEmail(email)
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
 
-	valid, err := user.PasswordMatches(requestPayload.Password)
+	valid, err := user.PasswordMatches(password)
 	if err != nil || !valid {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
-		return
+		app.Events.emit(authLoginFailed, user.Email)
+		return nil, errInvalidCredentials
 	}
+
+	if err := app.Models.User.SetLastLogin(user.Email, time.Now()); err != nil {
+		log.Printf("failed to record the login of %s: %v", user.Email, err)
+	}
+	app.Events.emit(authLogin, user.Email)
 	//  Log
 	err = logAuthRequest("authentication", fmt.Sprintf("%s user logged in.", user.Email))
 	if err != nil {
-		app.errorJSON(w, errors.New("Internal server error."), http.StatusInternalServerError)
-		return
+		return nil, errors.New("Internal server error.")
 	}
 	// mail
 	go sendWelcomeEmail(user.Email, user.FirstName)
-	payload := jsonResponse{
-		Error:   false,
-		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    user,
-	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return user, nil
 }
 
 func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
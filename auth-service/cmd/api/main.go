@@ -3,15 +3,20 @@ package main
 import (
 	"auth-service/data"
 	"auth-service/infra/db"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"shared/rabbit"
 )
 
 const webPort = 5000
 
 type Config struct {
 	Models data.Models
+	// Events publishes auth events; nil without RabbitMQ.
+	Events *authEvents
 }
 
 func main() {
@@ -25,6 +30,34 @@ func main() {
 	}
 	log.Println("Authentication service is ready.")
 
+	// RABBITMQ_URL enables the RPC server, so the broker can authenticate over RabbitMQ,
+	// and the auth events
+	if url := os.Getenv("RABBITMQ_URL"); url != "" {
+		manager := rabbit.NewManager(url)
+		defer manager.Close()
+
+		events, err := newAuthEvents(manager)
+		if err != nil {
+			log.Fatalf("Failed to set up auth events: %v", err)
+		}
+		app.Events = events
+		defer func() {
+			// publish the queued events before the connection goes
+			ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+			defer cancel()
+			events.Close(ctx)
+		}()
+
+		rpcServer, err := rabbit.NewRPCServer(manager, rpcQueue, app.handleRPC)
+		if err != nil {
+			log.Fatalf("Failed to create RPC server: %v", err)
+		}
+		defer rpcServer.Stop()
+
+		go manager.Start()
+		go rpcServer.Serve()
+	}
+
 	srv := NewServer(app.routes())
 
 	srv.Start()
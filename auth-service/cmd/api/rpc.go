@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// rpcQueue is the queue the broker sends auth requests to over RabbitMQ.
+const rpcQueue = "rpc.auth"
+
+// handleRPC serves the requests on rpcQueue. A reply carries the same jsonResponse the
+// HTTP handlers write.
+func (app *Config) handleRPC(ctx context.Context, method string, body []byte) (any, error) {
+	switch method {
+	case "auth.authenticate":
+		var requestPayload struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := json.Unmarshal(body, &requestPayload); err != nil {
+			return nil, err
+		}
+
+		user, err := app.login(requestPayload.Email, requestPayload.Password)
+		if err != nil {
+			return nil, err
+		}
+
+		return jsonResponse{
+			Error:   false,
+			Message: fmt.Sprintf("Logged in user %s", user.Email),
+			Data:    user,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown method %q", method)
+}
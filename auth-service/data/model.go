@@ -158,6 +158,30 @@ func (u *User) Update() error {
 	return nil
 }
 
+// SetLastLogin records the time of the user's last login. It returns sql.ErrNoRows
+// when no user has the email.
+func (u *User) SetLastLogin(email string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set last_login_at = $1 where email = $2`
+
+	res, err := db.ExecContext(ctx, stmt, at, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 func (u *User) Delete() error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -0,0 +1,2 @@
+-- +migrate Down
+ALTER TABLE users DROP COLUMN last_login_at;
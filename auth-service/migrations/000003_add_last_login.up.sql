@@ -0,0 +1,2 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN last_login_at TIMESTAMP;
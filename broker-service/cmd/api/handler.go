@@ -3,6 +3,7 @@ package main
 import (
 	"broker-service/cmd/clients"
 	"broker-service/event"
+	"broker-service/outbox"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,6 +11,8 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"shared/envelope"
+	"shared/rabbit"
 )
 
 type JsonResponse struct {
@@ -40,10 +43,8 @@ type RequestPayload struct {
 	Action string               `json:"action"`
 	Auth   *clients.AuthPayload `json:"auth_payload,omitempty"`
 	Log    *clients.LogPayload  `json:"log_payload,omitempty"`
-}
-type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	// Transport picks the transport of a "log" action, overriding LOG_TRANSPORT.
+	Transport string `json:"transport,omitempty"`
 }
 
 func (a *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
@@ -60,7 +61,21 @@ func (a *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 	case "auth":
 		a.authenticate(w, *reqPayload.Auth)
 	case "log":
-		a.logEventViaRabbit(w, *reqPayload.Log)
+		transport := reqPayload.Transport
+		if transport == "" {
+			transport = a.logTransport()
+		}
+		if transport == "rabbit" {
+			a.logEventViaRabbit(w, r, *reqPayload.Log)
+			return
+		}
+
+		logClient, ok := a.clients.Logs[transport]
+		if !ok {
+			errorJSON(w, fmt.Errorf("unknown log transport %q", transport))
+			return
+		}
+		a.logItem(w, logClient, *reqPayload.Log)
 	default:
 		w.WriteHeader(400)
 		w.Write([]byte("Unknown action"))
@@ -69,13 +84,40 @@ func (a *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// logTransport returns the default transport for the "log" action.
+func (a *Config) logTransport() string {
+	if a.LogTransport == "" {
+		return "rabbit"
+	}
+	return a.LogTransport
+}
+
+// authTransport returns the configured transport for the "auth" action.
+func (a *Config) authTransport() string {
+	if a.AuthTransport == "" {
+		return "http"
+	}
+	return a.AuthTransport
+}
+
+// upstreamStatus maps an error calling a backend to the status returned to the client.
+func upstreamStatus(err error) int {
+	switch {
+	case errors.Is(err, rabbit.ErrRPCTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, rabbit.ErrNotConnected):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
 func (a *Config) authenticate(w http.ResponseWriter, payload clients.AuthPayload) {
 	ctx := context.Background()
 	// Call the auth microservices
 	authResp, err := a.clients.Auth.Login(ctx, &payload)
 	fmt.Println("ERROR:", err)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+		writeJSON(w, upstreamStatus(err), map[string]interface{}{
 			"error":   true,
 			"message": "Auth service error: " + err.Error(),
 		})
@@ -88,12 +130,12 @@ func (a *Config) authenticate(w http.ResponseWriter, payload clients.AuthPayload
 	json.NewEncoder(w).Encode(authResp)
 }
 
-func (a *Config) logItem(w http.ResponseWriter, payload clients.LogPayload) {
+func (a *Config) logItem(w http.ResponseWriter, logClient clients.LogInserter, payload clients.LogPayload) {
 	ctx := context.Background()
 	// Call the logger microservices
-	logResp, err := a.clients.Log.Insert(ctx, &payload)
+	logResp, err := logClient.Insert(ctx, &payload)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+		writeJSON(w, upstreamStatus(err), map[string]interface{}{
 			"error":   true,
 			"message": "logger service error: " + err.Error(),
 		})
@@ -151,9 +193,34 @@ func errorJSON(w http.ResponseWriter, err error, status ...int) error {
 	writeJSON(w, statusCode, payload)
 	return nil
 }
-func (app *Config) logEventViaRabbit(w http.ResponseWriter, l clients.LogPayload) {
-	err := app.pushToQueue(l.Name, l.Data)
-	if err != nil {
+func (app *Config) logEventViaRabbit(w http.ResponseWriter, r *http.Request, l clients.LogPayload) {
+	if app.Outbox != nil {
+		app.logEventViaOutbox(w, r, l)
+		return
+	}
+
+	err := app.pushToQueue(r.Context(), l, r.Header.Get("X-Correlation-ID"))
+	switch {
+	case errors.Is(err, event.ErrInvalidRoutingKey):
+		errorJSON(w, err)
+		return
+	case errors.Is(err, event.ErrUnconfirmed):
+		// published but not confirmed yet, it may well be delivered
+		writeJSON(w, http.StatusAccepted, jsonResponse{
+			Error:   false,
+			Message: "sent to RabbitMQ, confirmation pending",
+		})
+		return
+	case errors.Is(err, event.ErrNacked):
+		errorJSON(w, err, http.StatusBadGateway)
+		return
+	case errors.Is(err, rabbit.ErrNotConnected):
+		errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, event.ErrUnroutable):
+		errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	case err != nil:
 		errorJSON(w, err)
 		return
 	}
@@ -165,22 +232,96 @@ func (app *Config) logEventViaRabbit(w http.ResponseWriter, l clients.LogPayload
 	writeJSON(w, http.StatusAccepted, payload)
 }
 
-// pushToQueue pushes a message into RabbitMQ
-func (app *Config) pushToQueue(name, msg string) error {
-	emitter, err := event.NewEventEmitter(app.Rabbit)
+// logEventViaOutbox stores the event in the outbox and answers once it is on disk. The
+// relay publishes it, so a RabbitMQ outage only delays the event. Events are ordered per
+// routing key.
+func (app *Config) logEventViaOutbox(w http.ResponseWriter, r *http.Request, l clients.LogPayload) {
+	env, route, err := app.newLogEvent(l, r.Header.Get("X-Correlation-ID"))
 	if err != nil {
-		return err
+		errorJSON(w, err)
+		return
 	}
 
-	payload := LogPayload{
-		Name: name,
-		Data: msg,
+	if _, err := app.Outbox.Add(route.Key(), route.Key(), env); err != nil {
+		if errors.Is(err, outbox.ErrFull) {
+			errorJSON(w, err, http.StatusServiceUnavailable)
+			return
+		}
+		log.Println("Error adding event to outbox:", err)
+		errorJSON(w, errors.New("could not store the event"), http.StatusInternalServerError)
+		return
 	}
 
-	j, _ := json.MarshalIndent(&payload, "", "\t")
-	err = emitter.Push(string(j), "log.INFO")
+	writeJSON(w, http.StatusAccepted, jsonResponse{
+		Error:   false,
+		Message: "queued for RabbitMQ",
+	})
+}
+
+// pushToQueue publishes a log event into RabbitMQ, routed by its severity and category.
+func (app *Config) pushToQueue(ctx context.Context, l clients.LogPayload, correlationID string) error {
+	env, route, err := app.newLogEvent(l, correlationID)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return app.Emitter.Push(ctx, env, route.Key())
+}
+
+// newLogEvent builds the envelope of a log event and its route. The correlation ID ties
+// the event to the request that caused it; without one the event's own ID is used.
+func (app *Config) newLogEvent(l clients.LogPayload, correlationID string) (envelope.Envelope, event.LogRoute, error) {
+	route, err := event.NewLogRoute(l.Severity, l.Category, app.LogCategories)
+	if err != nil {
+		return envelope.Envelope{}, route, err
+	}
+
+	env, err := envelope.New(envelope.TypeLog, "broker-service", envelope.LogData{
+		Name:     l.Name,
+		Data:     l.Data,
+		Severity: route.Severity,
+	})
+	if err != nil {
+		return env, route, err
+	}
+
+	env.CorrelationID = correlationID
+	if env.CorrelationID == "" {
+		env.CorrelationID = env.ID
+	}
+
+	return env, route, nil
+}
+
+// OutboxStats reports how many events wait in the outbox and which routing keys are
+// being retried.
+func (app *Config) OutboxStats(w http.ResponseWriter, r *http.Request) {
+	if app.Relay == nil {
+		errorJSON(w, errors.New("the outbox is disabled"), http.StatusNotFound)
+		return
+	}
+
+	stats := app.Relay.Stats()
+	writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d events in the outbox", stats.Depth),
+		Data:    stats,
+	})
+}
+
+// RabbitHealth reports the RabbitMQ connection state, answering 503 while the broker
+// is reconnecting.
+func (app *Config) RabbitHealth(w http.ResponseWriter, r *http.Request) {
+	status := app.Rabbit.Status()
+
+	statusCode := http.StatusOK
+	if status.State != rabbit.StateConnected {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, statusCode, jsonResponse{
+		Error:   statusCode != http.StatusOK,
+		Message: "rabbitmq " + status.State,
+		Data:    status,
+	})
 }
@@ -20,6 +20,9 @@ func (a *Config) routes() http.Handler {
 	}))
 	r.Use(middleware.Heartbeat("/health"))
 
+	r.Get("/health/rabbitmq", a.RabbitHealth)
+	r.Get("/outbox", a.OutboxStats)
+
 	r.Post("/", a.Broker)
 	r.Post("/handle", a.HandleSubmission)
 	return r
@@ -0,0 +1,69 @@
+package clients
+
+import (
+	"context"
+	"crypto/rand"
+	"shared/rabbit"
+)
+
+// Request queues and methods of the RPC servers in auth-service and logger-service.
+const (
+	AuthRPCQueue   = "rpc.auth"
+	LoggerRPCQueue = "rpc.logger"
+
+	authenticateMethod = "auth.authenticate"
+	writeLogMethod     = "log.write"
+)
+
+// AMQPAuthClient calls auth-service's RPC server over RabbitMQ.
+type AMQPAuthClient struct {
+	rpc *rabbit.RPCClient
+}
+
+func NewAMQPAuthClient(rpc *rabbit.RPCClient) *AMQPAuthClient {
+	return &AMQPAuthClient{rpc: rpc}
+}
+
+// Login fails with a *rabbit.RemoteError when auth-service rejects the credentials.
+func (a *AMQPAuthClient) Login(ctx context.Context, payload *AuthPayload) (*AuthResponse, error) {
+	var result AuthResponse
+	if err := a.rpc.Call(ctx, AuthRPCQueue, authenticateMethod, payload, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AMQPLogClient calls logger-service's RPC server over RabbitMQ. Unlike the "rabbit"
+// transport it waits for the entry to be stored.
+type AMQPLogClient struct {
+	rpc    *rabbit.RPCClient
+	apiKey string
+}
+
+// NewAMQPLogClient returns a client writing logs as the tenant of apiKey; an empty key
+// writes them as logger-service's anonymous tenant.
+func NewAMQPLogClient(rpc *rabbit.RPCClient, apiKey string) *AMQPLogClient {
+	return &AMQPLogClient{rpc: rpc, apiKey: apiKey}
+}
+
+// amqpLogRequest is the request of the "log.write" method.
+type amqpLogRequest struct {
+	LogPayload
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// Insert sends the payload with an idempotency key, generated when the payload has
+// none. A request is delivered again when logger-service fails before acknowledging
+// it, and the key keeps that from storing the entry twice.
+func (l *AMQPLogClient) Insert(ctx context.Context, payload *LogPayload) (*LogResponse, error) {
+	req := amqpLogRequest{LogPayload: *payload, APIKey: l.apiKey}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = rand.Text()
+	}
+
+	var result LogResponse
+	if err := l.rpc.Call(ctx, LoggerRPCQueue, writeLogMethod, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -1,13 +1,28 @@
 package clients
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
 
+// LogInserter sends a log entry to logger-service. LogClient does it over HTTP,
+// GRPCLogClient over gRPC, RPCLogClient over net/rpc and AMQPLogClient over RabbitMQ.
+type LogInserter interface {
+	Insert(ctx context.Context, payload *LogPayload) (*LogResponse, error)
+}
+
+// Authenticator checks credentials with auth-service. AuthClient does it over HTTP and
+// AMQPAuthClient over RabbitMQ.
+type Authenticator interface {
+	Login(ctx context.Context, payload *AuthPayload) (*AuthResponse, error)
+}
+
 type Clients struct {
-	Auth *AuthClient
-	Log  *LogClient
+	Auth Authenticator
+	// Logs holds a LogInserter per transport ("http", "grpc", "rpc", "amqp"), so that
+	// the transports can be used side by side.
+	Logs map[string]LogInserter
 }
 
 func NewClients(authUrl, logUrl string) *Clients {
@@ -23,6 +38,8 @@ func NewClients(authUrl, logUrl string) *Clients {
 
 	return &Clients{
 		Auth: NewAuthClient(authUrl, httpClient),
-		Log:  NewLogClient(logUrl, httpClient),
+		Logs: map[string]LogInserter{
+			"http": NewLogClient(logUrl, httpClient),
+		},
 	}
 }
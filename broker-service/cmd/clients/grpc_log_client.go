@@ -0,0 +1,50 @@
+package clients
+
+import (
+	"broker-service/logs"
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// GRPCLogClient talks to logger-service's gRPC LogService instead of POST /log.
+type GRPCLogClient struct {
+	conn   *grpc.ClientConn
+	client logs.LogServiceClient
+}
+
+// NewGRPCLogClient prepares a client for the LogService at addr (host:port). The
+// connection is established lazily on the first call.
+func NewGRPCLogClient(addr string) (*GRPCLogClient, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCLogClient{
+		conn:   conn,
+		client: logs.NewLogServiceClient(conn),
+	}, nil
+}
+
+func (l *GRPCLogClient) Insert(ctx context.Context, payload *LogPayload) (*LogResponse, error) {
+	resp, err := l.client.Write(ctx, &logs.WriteRequest{
+		Name:           payload.Name,
+		Data:           payload.Data,
+		Severity:       payload.Severity,
+		IdempotencyKey: payload.IdempotencyKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogResponse{
+		Error:   false,
+		Message: resp.GetMessage(),
+	}, nil
+}
+
+func (l *GRPCLogClient) Close() error {
+	return l.conn.Close()
+}
@@ -14,8 +14,14 @@ type LogClient struct {
 }
 
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Severity string `json:"severity,omitempty"`
+	// Category is only used for the routing key of events sent through RabbitMQ.
+	Category string `json:"category,omitempty"`
+	// IdempotencyKey lets a client retry a log safely. The "http", "grpc" and "amqp"
+	// transports forward it; net/rpc has no field for it.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 type LogResponse struct {
 	Error   bool   `json:"error"`
@@ -40,7 +46,6 @@ func (l *LogClient) Insert(ctx context.Context, payload *LogPayload) (*LogRespon
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(jsonData, url)
 
 	// Create a request with context
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
@@ -58,7 +63,8 @@ func (l *LogClient) Insert(ctx context.Context, payload *LogPayload) (*LogRespon
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusAccepted {
+	// a repeated idempotency key is answered with 200 and "already logged"
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("Logger service return status code %d", resp.StatusCode)
 	}
 
@@ -0,0 +1,114 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+const rpcDialTimeout = 5 * time.Second
+
+// RPCLogClient calls logger-service's net/rpc server (RPCServer.LogInfo). The connection
+// is dialed on the first call and kept; it is only dialed again after it failed.
+type RPCLogClient struct {
+	addr string
+
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+// RPCPayload mirrors the argument type of RPCServer.LogInfo in logger-service.
+type RPCPayload struct {
+	Name string
+	Data string
+}
+
+func NewRPCLogClient(addr string) *RPCLogClient {
+	return &RPCLogClient{addr: addr}
+}
+
+// conn returns the open connection, dialing one if there is none.
+func (l *RPCLogClient) conn(ctx context.Context) (*rpc.Client, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.client != nil {
+		return l.client, nil
+	}
+
+	dialer := net.Dialer{Timeout: rpcDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", l.addr)
+	if err != nil {
+		return nil, err
+	}
+	l.client = rpc.NewClient(conn)
+	return l.client, nil
+}
+
+// drop closes a connection that failed, unless it was replaced already.
+func (l *RPCLogClient) drop(client *rpc.Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.client == client {
+		l.client.Close()
+		l.client = nil
+	}
+}
+
+// Insert sends the payload over the kept connection. When that connection turns out to
+// be closed, as after a restart of logger-service, the call is sent once more over a new
+// one; the request never left the broker in that case.
+func (l *RPCLogClient) Insert(ctx context.Context, payload *LogPayload) (*LogResponse, error) {
+	result, err := l.call(ctx, payload)
+	if errors.Is(err, rpc.ErrShutdown) {
+		result, err = l.call(ctx, payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogResponse{
+		Error:   false,
+		Message: result,
+	}, nil
+}
+
+func (l *RPCLogClient) call(ctx context.Context, payload *LogPayload) (string, error) {
+	client, err := l.conn(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var result string
+	call := client.Go("RPCServer.LogInfo", RPCPayload{Name: payload.Name, Data: payload.Data}, &result, nil)
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-call.Done:
+	}
+
+	var serverErr rpc.ServerError
+	if call.Error != nil && !errors.As(call.Error, &serverErr) {
+		// the connection is broken; the next call dials a new one
+		l.drop(client)
+	}
+	return result, call.Error
+}
+
+// Close closes the connection.
+func (l *RPCLogClient) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.client == nil {
+		return nil
+	}
+	err := l.client.Close()
+	l.client = nil
+	return err
+}
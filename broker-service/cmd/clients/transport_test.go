@@ -0,0 +1,328 @@
+package clients
+
+import (
+	"broker-service/logs"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"shared/rabbit"
+	"shared/rabbit/amqptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// The benchmarks compare the synchronous log transports against in-process stand-ins for
+// logger-service's HTTP, gRPC, net/rpc and RabbitMQ RPC servers, which accept the entry
+// without storing it; RabbitMQ is the in-memory server of amqptest. What is measured is the cost of the transport itself:
+//
+//	go test ./cmd/clients -bench LogTransport -benchmem
+
+type grpcLogServer struct {
+	logs.UnimplementedLogServiceServer
+}
+
+func (grpcLogServer) Write(ctx context.Context, req *logs.WriteRequest) (*logs.WriteResponse, error) {
+	return &logs.WriteResponse{Message: "logged"}, nil
+}
+
+type rpcLogServer struct{}
+
+func (rpcLogServer) LogInfo(payload RPCPayload, reply *string) error {
+	*reply = "Processed payload via RPC: " + payload.Name
+	return nil
+}
+
+func listen(tb testing.TB) net.Listener {
+	tb.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return lis
+}
+
+func newHTTPTransport(tb testing.TB) LogInserter {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"error":false,"message":"logged"}`))
+	}))
+	tb.Cleanup(srv.Close)
+
+	return NewClients("", srv.URL).Logs["http"]
+}
+
+func newGRPCTransport(tb testing.TB) LogInserter {
+	lis := listen(tb)
+	srv := grpc.NewServer()
+	logs.RegisterLogServiceServer(srv, grpcLogServer{})
+	go srv.Serve(lis)
+	tb.Cleanup(srv.Stop)
+
+	client, err := NewGRPCLogClient(lis.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	return client
+}
+
+func newRPCTransport(tb testing.TB) LogInserter {
+	lis := listen(tb)
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RPCServer", rpcLogServer{}); err != nil {
+		tb.Fatal(err)
+	}
+	go func() {
+		// like srv.Accept, without logging the error of the closed listener
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go srv.ServeConn(conn)
+		}
+	}()
+	tb.Cleanup(func() { lis.Close() })
+
+	client := NewRPCLogClient(lis.Addr().String())
+	tb.Cleanup(func() { client.Close() })
+	return client
+}
+
+// startAMQPRPC serves handler on LoggerRPCQueue of a fake RabbitMQ and returns a client
+// calling it.
+func startAMQPRPC(tb testing.TB, handler rabbit.RPCHandler) *rabbit.RPCClient {
+	tb.Helper()
+
+	srv, err := amqptest.NewServer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(srv.Close)
+
+	manager := rabbit.NewManager(srv.URL())
+	tb.Cleanup(func() { manager.Close() })
+	server, err := rabbit.NewRPCServer(manager, LoggerRPCQueue, handler)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	client, err := rabbit.NewRPCClient(manager, 5*time.Second, LoggerRPCQueue)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+
+	manager.Start()
+	go server.Serve()
+	tb.Cleanup(server.Stop)
+	return client
+}
+
+func newAMQPTransport(tb testing.TB) LogInserter {
+	client := startAMQPRPC(tb, func(ctx context.Context, method string, body []byte) (any, error) {
+		return LogResponse{Message: "logged"}, nil
+	})
+	return NewAMQPLogClient(client, "")
+}
+
+var logTransports = []struct {
+	name string
+	new  func(testing.TB) LogInserter
+}{
+	{"http", newHTTPTransport},
+	{"grpc", newGRPCTransport},
+	{"rpc", newRPCTransport},
+	{"amqp", newAMQPTransport},
+}
+
+var benchPayload = &LogPayload{Name: "bench", Data: "a log entry of a typical size, sent by the broker", Severity: "info"}
+
+func BenchmarkLogTransport(b *testing.B) {
+	for _, transport := range logTransports {
+		b.Run(transport.name, func(b *testing.B) {
+			client := transport.new(b)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := client.Insert(ctx, benchPayload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLogTransportParallel sends from as many goroutines as there are CPUs, as the
+// broker does while serving concurrent requests.
+func BenchmarkLogTransportParallel(b *testing.B) {
+	for _, transport := range logTransports {
+		b.Run(transport.name, func(b *testing.B) {
+			client := transport.new(b)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := client.Insert(ctx, benchPayload); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestLogTransports(t *testing.T) {
+	for _, transport := range logTransports {
+		t.Run(transport.name, func(t *testing.T) {
+			resp, err := transport.new(t).Insert(context.Background(), benchPayload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error || resp.Message == "" {
+				t.Errorf("response = %+v", resp)
+			}
+		})
+	}
+}
+
+func TestRPCLogClientRedials(t *testing.T) {
+	lis := listen(t)
+	t.Cleanup(func() { lis.Close() })
+	srv := rpc.NewServer()
+	srv.RegisterName("RPCServer", rpcLogServer{})
+
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go srv.ServeConn(conn)
+		}
+	}()
+
+	client := NewRPCLogClient(lis.Addr().String())
+	defer client.Close()
+
+	for i := range 2 {
+		if _, err := client.Insert(context.Background(), benchPayload); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+	}
+	if n := len(conns); n != 1 {
+		t.Fatalf("two calls dialed %d connections, want 1", n)
+	}
+
+	// logger-service restarts: its end of the connection goes away
+	(<-conns).Close()
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := client.Insert(context.Background(), benchPayload); err != nil {
+		t.Fatalf("call after the connection closed: %v", err)
+	}
+	if n := len(conns); n != 1 {
+		t.Errorf("the client did not dial a new connection")
+	}
+}
+
+func TestAMQPLogClientForwardsKeys(t *testing.T) {
+	requests := make(chan map[string]string, 3)
+	client := startAMQPRPC(t, func(ctx context.Context, method string, body []byte) (any, error) {
+		var req map[string]string
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		requests <- req
+		return LogResponse{Message: "logged"}, nil
+	})
+	logs := NewAMQPLogClient(client, "tenant-key")
+
+	payloads := []*LogPayload{
+		{Name: "a", Data: "x"},
+		{Name: "a", Data: "x"},
+		{Name: "a", Data: "x", IdempotencyKey: "k1"},
+	}
+	for _, payload := range payloads {
+		if _, err := logs.Insert(context.Background(), payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, second, third := <-requests, <-requests, <-requests
+	for _, req := range []map[string]string{first, second, third} {
+		if req["api_key"] != "tenant-key" {
+			t.Errorf("api_key = %q, want tenant-key", req["api_key"])
+		}
+	}
+	// a key is generated for every entry that has none
+	if first["idempotency_key"] == "" || first["idempotency_key"] == second["idempotency_key"] {
+		t.Errorf("generated keys %q and %q, want two distinct keys", first["idempotency_key"], second["idempotency_key"])
+	}
+	if third["idempotency_key"] != "k1" {
+		t.Errorf("idempotency_key = %q, want the payload's k1", third["idempotency_key"])
+	}
+}
+
+// keyedLogServer records the idempotency keys it receives and answers a repeated one the
+// way logger-service does.
+type keyedLogServer struct {
+	logs.UnimplementedLogServiceServer
+	keys chan string
+}
+
+func (s keyedLogServer) Write(ctx context.Context, req *logs.WriteRequest) (*logs.WriteResponse, error) {
+	s.keys <- req.GetIdempotencyKey()
+	return &logs.WriteResponse{Message: "logged"}, nil
+}
+
+func TestLogClientsForwardIdempotencyKey(t *testing.T) {
+	keys := make(chan string, 2)
+
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload LogPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		keys <- payload.IdempotencyKey
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"error":false,"message":"already logged"}`))
+	}))
+	t.Cleanup(httpSrv.Close)
+
+	lis := listen(t)
+	grpcSrv := grpc.NewServer()
+	logs.RegisterLogServiceServer(grpcSrv, keyedLogServer{keys: keys})
+	go grpcSrv.Serve(lis)
+	t.Cleanup(grpcSrv.Stop)
+	grpcClient, err := NewGRPCLogClient(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { grpcClient.Close() })
+
+	clients := map[string]LogInserter{
+		"http": NewClients("", httpSrv.URL).Logs["http"],
+		"grpc": grpcClient,
+	}
+	for name, client := range clients {
+		// a retry is answered with "already logged", which is not an error
+		if _, err := client.Insert(context.Background(), &LogPayload{Name: "a", Data: "x", IdempotencyKey: "k-" + name}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if key := <-keys; key != "k-"+name {
+			t.Errorf("%s: logger-service got the key %q, want %q", name, key, "k-"+name)
+		}
+	}
+}
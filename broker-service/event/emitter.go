@@ -1,55 +1,118 @@
 package event
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
-	amqp "github.com/rabbitmq/amqp091-go"
+	"shared/envelope"
+	"shared/rabbit"
 )
 
+var (
+	// ErrNacked is returned when RabbitMQ refused the message.
+	ErrNacked = errors.New("rabbitmq rejected the message")
+	// ErrUnroutable is returned when no queue is bound for the routing key, so the
+	// message was returned instead of delivered.
+	ErrUnroutable = errors.New("no queue is bound for the routing key")
+	// ErrUnconfirmed is returned when RabbitMQ did not confirm the message within the
+	// publish timeout. The message may still be delivered.
+	ErrUnconfirmed = errors.New("rabbitmq did not confirm the message in time")
+)
+
+// EmitterOptions configures an Emitter.
+type EmitterOptions struct {
+	// Channels is the number of confirm-mode channels kept open for reuse.
+	Channels int
+	// PublishTimeout bounds a publish including its confirmation.
+	PublishTimeout time.Duration
+}
+
+// DefaultEmitterOptions keeps 8 channels and waits 5 seconds for confirmations.
+func DefaultEmitterOptions() EmitterOptions {
+	return EmitterOptions{
+		Channels:       8,
+		PublishTimeout: 5 * time.Second,
+	}
+}
+
+// Emitter publishes events to logs_topic. It is long-lived and safe for concurrent use:
+// every publish borrows a confirm-mode channel from a pool, publishes with the
+// mandatory flag and waits until RabbitMQ confirms or returns the message.
 type Emitter struct {
-	connection *amqp.Connection
+	pool    *channelPool
+	timeout time.Duration
 }
 
-func NewEventEmitter(conn *amqp.Connection) (*Emitter, error) {
+// NewEventEmitter returns an emitter publishing through the manager. The exchange is
+// registered as topology, so it is declared again after every reconnect.
+func NewEventEmitter(manager *rabbit.Manager, opts EmitterOptions) (*Emitter, error) {
+	if opts.Channels < 1 || opts.PublishTimeout <= 0 {
+		return nil, errors.New("emitter needs at least one channel and a publish timeout")
+	}
+
 	emitter := &Emitter{
-		connection: conn,
+		pool:    newChannelPool(manager, opts.Channels),
+		timeout: opts.PublishTimeout,
 	}
-	err := emitter.setup()
+	err := manager.Declare(declareExchange)
 	if err != nil {
 		return nil, err
 	}
 	return emitter, nil
 }
 
-func (e *Emitter) setup() error {
-	ch, err := e.connection.Channel()
-
+// Push publishes the envelope to logs_topic with the routing key and waits for the
+// confirmation. The envelope is validated first, so consumers never receive an event
+// they cannot decode.
+func (e *Emitter) Push(ctx context.Context, env envelope.Envelope, routingKey string) error {
+	msg, err := env.Publishing()
 	if err != nil {
 		return err
 	}
-	defer ch.Close()
-
-	return declareExchange(ch)
-}
 
-func (e *Emitter) Push(event, severity string) error {
-	ch, err := e.connection.Channel()
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
 
+	c, err := e.pool.get()
 	if err != nil {
 		return err
 	}
-	defer ch.Close()
-	fmt.Println("Pushing to channel")
-	err = ch.Publish(
+
+	confirmation, err := c.ch.PublishWithDeferredConfirmWithContext(
+		ctx,
 		"logs_topic",
-		severity,
-		false,
+		routingKey,
+		true, // mandatory: return the message when no queue is bound
 		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(event),
-		},
+		msg,
 	)
-	return err
+	if err != nil {
+		e.pool.discard(c)
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		// the confirmation may still arrive and would be mistaken for a later one
+		e.pool.discard(c)
+		return fmt.Errorf("%w: %v", ErrUnconfirmed, err)
+	}
+
+	ret, returned := c.returned(msg.MessageId)
+	e.pool.put(c)
+
+	switch {
+	case !acked:
+		return ErrNacked
+	case returned:
+		return fmt.Errorf("%w %q: %s", ErrUnroutable, routingKey, ret.ReplyText)
+	}
+	return nil
+}
 
+// Close closes the pooled channels.
+func (e *Emitter) Close() {
+	e.pool.close()
 }
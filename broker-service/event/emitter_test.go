@@ -0,0 +1,200 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"shared/envelope"
+	"shared/rabbit"
+	"shared/rabbit/amqptest"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// startEmitter connects an emitter to a fake server on which the queue "logs" is bound
+// to every log event.
+func startEmitter(tb testing.TB) (*amqptest.Server, *rabbit.Manager, *Emitter) {
+	tb.Helper()
+
+	srv, err := amqptest.NewServer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(srv.Close)
+
+	manager := rabbit.NewManager(srv.URL())
+	tb.Cleanup(func() { manager.Close() })
+
+	emitter, err := NewEventEmitter(manager, DefaultEmitterOptions())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(emitter.Close)
+
+	manager.Declare(func(ch *amqp.Channel) error {
+		if _, err := ch.QueueDeclare("logs", false, false, false, false, nil); err != nil {
+			return err
+		}
+		return ch.QueueBind("logs", "log.#", "logs_topic", false, nil)
+	})
+	manager.Start()
+
+	select {
+	case <-manager.Ready():
+	case <-time.After(5 * time.Second):
+		tb.Fatal("the manager did not connect")
+	}
+	return srv, manager, emitter
+}
+
+func logEnvelope(tb testing.TB) envelope.Envelope {
+	tb.Helper()
+
+	env, err := envelope.New(envelope.TypeLog, "broker-service", envelope.LogData{Name: "bench", Data: "a log entry of a typical size, sent by the broker"})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return env
+}
+
+func TestEmitterPush(t *testing.T) {
+	srv, _, emitter := startEmitter(t)
+	env := logEnvelope(t)
+
+	for range 3 {
+		if err := emitter.Push(context.Background(), env, "log.INFO.general"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := srv.Messages("logs"); n != 3 {
+		t.Errorf("queue holds %d messages, want 3", n)
+	}
+}
+
+func TestEmitterUnroutable(t *testing.T) {
+	srv, _, emitter := startEmitter(t)
+	env := logEnvelope(t)
+
+	if err := emitter.Push(context.Background(), env, "audit.user"); !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("err = %v, want %v", err, ErrUnroutable)
+	}
+	// the channel goes back to the pool, and the return does not leak into the next publish
+	if err := emitter.Push(context.Background(), env, "log.INFO.general"); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Messages("logs"); n != 1 {
+		t.Errorf("queue holds %d messages, want 1", n)
+	}
+}
+
+func TestEmitterReconnects(t *testing.T) {
+	srv, manager, emitter := startEmitter(t)
+	env := logEnvelope(t)
+
+	if err := emitter.Push(context.Background(), env, "log.INFO.general"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.CloseConnections()
+	deadline := time.Now().Add(10 * time.Second)
+	for manager.Status().Reconnects == 0 || manager.Status().State != rabbit.StateConnected {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the pooled channels of the lost connection are discarded
+	if err := emitter.Push(context.Background(), env, "log.INFO.general"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pushPerRequest publishes the way the emitter did before it pooled channels: a channel
+// is opened for every event and closed after it. It waits for the confirmation, so it
+// gives the same guarantee as Push and the benchmarks compare only the channel reuse.
+func pushPerRequest(ctx context.Context, manager *rabbit.Manager, env envelope.Envelope, routingKey string) error {
+	msg, err := env.Publishing()
+	if err != nil {
+		return err
+	}
+
+	ch, err := manager.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "logs_topic", routingKey, true, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrNacked
+	}
+	return nil
+}
+
+var emitters = []struct {
+	name string
+	push func(*rabbit.Manager, *Emitter) func(context.Context, envelope.Envelope, string) error
+}{
+	{"pooled", func(_ *rabbit.Manager, e *Emitter) func(context.Context, envelope.Envelope, string) error {
+		return e.Push
+	}},
+	{"per-request", func(m *rabbit.Manager, _ *Emitter) func(context.Context, envelope.Envelope, string) error {
+		return func(ctx context.Context, env envelope.Envelope, key string) error {
+			return pushPerRequest(ctx, m, env, key)
+		}
+	}},
+}
+
+func BenchmarkEmitter(b *testing.B) {
+	for _, emitter := range emitters {
+		b.Run(emitter.name, func(b *testing.B) {
+			_, manager, e := startEmitter(b)
+			push := emitter.push(manager, e)
+			env := logEnvelope(b)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := push(ctx, env, "log.INFO.general"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkEmitterParallel publishes from as many goroutines as there are CPUs, as the
+// broker does while serving concurrent requests.
+func BenchmarkEmitterParallel(b *testing.B) {
+	for _, emitter := range emitters {
+		b.Run(emitter.name, func(b *testing.B) {
+			_, manager, e := startEmitter(b)
+			push := emitter.push(manager, e)
+			env := logEnvelope(b)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := push(ctx, env, "log.INFO.general"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
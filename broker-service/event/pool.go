@@ -0,0 +1,109 @@
+package event
+
+import (
+	"shared/rabbit"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// confirmChannel is a channel in confirm mode with its returned messages.
+type confirmChannel struct {
+	ch      *amqp.Channel
+	returns chan amqp.Return
+}
+
+// returned reports whether the message with the id came back as unroutable. The server
+// sends the return before the confirmation, so once a publish is confirmed its return,
+// if any, is already waiting.
+func (c *confirmChannel) returned(id string) (amqp.Return, bool) {
+	for {
+		select {
+		case ret, ok := <-c.returns:
+			if !ok {
+				return amqp.Return{}, false
+			}
+			if ret.MessageId == id {
+				return ret, true
+			}
+			// left over from a publish that timed out, skip it
+		default:
+			return amqp.Return{}, false
+		}
+	}
+}
+
+// channelPool keeps up to size confirm-mode channels open for reuse. A channel is used
+// by one publisher at a time, so its confirmations and returns belong to that publish.
+type channelPool struct {
+	rabbit *rabbit.Manager
+	idle   chan *confirmChannel
+}
+
+func newChannelPool(manager *rabbit.Manager, size int) *channelPool {
+	return &channelPool{
+		rabbit: manager,
+		idle:   make(chan *confirmChannel, size),
+	}
+}
+
+// get returns an idle channel, or opens a new one. Channels of a lost connection are
+// discarded.
+func (p *channelPool) get() (*confirmChannel, error) {
+	for {
+		select {
+		case c := <-p.idle:
+			if !c.ch.IsClosed() {
+				return c, nil
+			}
+		default:
+			return p.open()
+		}
+	}
+}
+
+func (p *channelPool) open() (*confirmChannel, error) {
+	ch, err := p.rabbit.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	return &confirmChannel{
+		ch:      ch,
+		returns: ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// put hands a channel back to the pool, closing it when the pool is full.
+func (p *channelPool) put(c *confirmChannel) {
+	if c.ch.IsClosed() {
+		return
+	}
+
+	select {
+	case p.idle <- c:
+	default:
+		c.ch.Close()
+	}
+}
+
+// discard closes a channel whose state is unknown, e.g. after a timed out publish.
+func (p *channelPool) discard(c *confirmChannel) {
+	c.ch.Close()
+}
+
+// close closes the idle channels.
+func (p *channelPool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.ch.Close()
+		default:
+			return
+		}
+	}
+}
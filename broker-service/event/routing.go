@@ -0,0 +1,82 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidRoutingKey is returned for a severity or category that cannot be routed.
+var ErrInvalidRoutingKey = errors.New("invalid routing key")
+
+// Severities are the severities a log event may be published with.
+var Severities = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// DefaultSeverity and DefaultCategory are used when a log event leaves them out.
+const (
+	DefaultSeverity = "INFO"
+	DefaultCategory = "general"
+)
+
+// DefaultCategories are the categories a log event may be published with unless the
+// broker is configured with others: the services of this repository, and the default.
+var DefaultCategories = []string{DefaultCategory, "auth", "broker", "frontend", "listener", "logger", "mail"}
+
+var categoryPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// ParseCategories parses a comma separated list of allowed categories such as
+// "auth,billing". Each must be 1-32 lower-case letters, digits, '-' or '_'; the
+// default category is always allowed.
+func ParseCategories(s string) ([]string, error) {
+	categories := []string{DefaultCategory}
+	for _, field := range strings.Split(s, ",") {
+		category := strings.TrimSpace(field)
+		if !categoryPattern.MatchString(category) {
+			return nil, fmt.Errorf("invalid category %q: must be 1-32 lower-case letters, digits, '-' or '_'", category)
+		}
+		if !slices.Contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	return categories, nil
+}
+
+// LogRoute is the validated severity and category of a log event.
+type LogRoute struct {
+	Severity string
+	Category string
+}
+
+// NewLogRoute validates a severity and category. The severity is upper-cased and must be
+// one of Severities; the category is lower-cased and must be one of categories. Any
+// other word would open a new, possibly unbound, route.
+func NewLogRoute(severity, category string, categories []string) (LogRoute, error) {
+	severity = strings.ToUpper(strings.TrimSpace(severity))
+	if severity == "" {
+		severity = DefaultSeverity
+	}
+	if severity == "WARNING" {
+		severity = "WARN"
+	}
+	if !slices.Contains(Severities, severity) {
+		return LogRoute{}, fmt.Errorf("%w: unknown severity %q, want one of %s", ErrInvalidRoutingKey, severity, strings.Join(Severities, ", "))
+	}
+
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" {
+		category = DefaultCategory
+	}
+	if !slices.Contains(categories, category) {
+		return LogRoute{}, fmt.Errorf("%w: unknown category %q, want one of %s", ErrInvalidRoutingKey, category, strings.Join(categories, ", "))
+	}
+
+	return LogRoute{Severity: severity, Category: category}, nil
+}
+
+// Key returns the routing key "log.<severity>.<category>", e.g. "log.ERROR.auth", so
+// consumers can bind "log.ERROR.*" or "log.*.auth".
+func (r LogRoute) Key() string {
+	return "log." + r.Severity + "." + r.Category
+}
@@ -0,0 +1,65 @@
+package event
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNewLogRoute(t *testing.T) {
+	tests := []struct {
+		severity, category string
+		want               string
+		err                bool
+	}{
+		{"", "", "log.INFO.general", false},
+		{"error", "Auth", "log.ERROR.auth", false},
+		{" warning ", "mail", "log.WARN.mail", false},
+		{"FATAL", "broker", "log.FATAL.broker", false},
+		{"TRACE", "auth", "", true},
+		// a well-formed word that is not an allowed category
+		{"INFO", "billing", "", true},
+		{"INFO", "auth.#", "", true},
+		{"INFO", "*", "", true},
+	}
+
+	for _, tt := range tests {
+		route, err := NewLogRoute(tt.severity, tt.category, DefaultCategories)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidRoutingKey) {
+				t.Errorf("NewLogRoute(%q, %q): err = %v, want %v", tt.severity, tt.category, err, ErrInvalidRoutingKey)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewLogRoute(%q, %q): %v", tt.severity, tt.category, err)
+			continue
+		}
+		if got := route.Key(); got != tt.want {
+			t.Errorf("NewLogRoute(%q, %q) = %s, want %s", tt.severity, tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestParseCategories(t *testing.T) {
+	categories, err := ParseCategories(" auth, billing ,auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default category is always allowed
+	if want := []string{DefaultCategory, "auth", "billing"}; !slices.Equal(categories, want) {
+		t.Errorf("categories = %v, want %v", categories, want)
+	}
+	if _, err := NewLogRoute("INFO", "billing", categories); err != nil {
+		t.Errorf("configured category: %v", err)
+	}
+	if _, err := NewLogRoute("INFO", "mail", categories); !errors.Is(err, ErrInvalidRoutingKey) {
+		t.Errorf("category left out of the configuration: err = %v", err)
+	}
+
+	for _, s := range []string{"auth,", "Auth", "a.b", "log#"} {
+		if _, err := ParseCategories(s); err == nil {
+			t.Errorf("ParseCategories(%q) succeeded", s)
+		}
+	}
+}
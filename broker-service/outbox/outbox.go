@@ -0,0 +1,466 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"shared/envelope"
+)
+
+const (
+	logFile = "outbox.log"
+	// deadFile holds the entries that were parked because they can never be published.
+	deadFile = "dead.log"
+	// compactAfter is the number of done records after which the log is rewritten with
+	// only the pending entries.
+	compactAfter  = 1000
+	maxRecordSize = 4 << 20
+)
+
+var (
+	// ErrClosed is returned once the outbox is closed.
+	ErrClosed = errors.New("outbox is closed")
+	// ErrFull is returned by Add while the outbox holds as many entries as its limit.
+	ErrFull = errors.New("outbox is full")
+)
+
+// Entry is an event waiting to be published. Entries with the same Key are published in
+// the order they were added.
+type Entry struct {
+	Seq        int64             `json:"seq"`
+	Key        string            `json:"key"`
+	RoutingKey string            `json:"routing_key"`
+	Envelope   envelope.Envelope `json:"envelope"`
+	Created    time.Time         `json:"created"`
+}
+
+// Parked is a line of the dead-letter file: an entry that was taken out of the outbox
+// because publishing it failed for good.
+type Parked struct {
+	Entry  Entry     `json:"entry"`
+	Reason string    `json:"reason"`
+	Parked time.Time `json:"parked"`
+}
+
+// record is one line of the log: an added entry, or the sequence number of an entry
+// that was published.
+type record struct {
+	Add  *Entry `json:"add,omitempty"`
+	Done int64  `json:"done,omitempty"`
+}
+
+// file is what the outbox needs of its log: an *os.File, which tests wrap to make writes
+// fail.
+type file interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// Outbox is an embedded durable queue of events. Every change is appended as a JSON line
+// to outbox.log and synced before it is acknowledged, so an added entry survives a crash
+// of the broker. The pending entries are kept in memory, queued per key.
+type Outbox struct {
+	path  string
+	limit int
+
+	mu      sync.Mutex
+	f       file
+	queues  map[string][]*Entry
+	depth   int
+	nextSeq int64
+	done    int
+	closed  bool
+
+	notify chan struct{}
+}
+
+// Open opens (or creates) the outbox in dir and loads the entries that were not
+// published yet. Add refuses new entries while limit entries are pending; a limit of 0
+// means no limit.
+func Open(dir string, limit int) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		path:    filepath.Join(dir, logFile),
+		limit:   limit,
+		queues:  map[string][]*Entry{},
+		nextSeq: 1,
+		notify:  make(chan struct{}, 1),
+	}
+
+	f, err := os.OpenFile(o.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.load(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// the log may have just been created
+	if err := syncDir(dir); err != nil {
+		f.Close()
+		return nil, err
+	}
+	o.f = f
+
+	return o, nil
+}
+
+// load replays the log. A torn last line, left by a crash in the middle of a write, was
+// never acknowledged and is cut off.
+func (o *Outbox) load(f *os.File) error {
+	pending := map[int64]*Entry{}
+	var valid int64
+
+	r := bufio.NewReaderSize(f, 64<<10)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(line) > maxRecordSize {
+			return fmt.Errorf("%s: record of %d bytes", o.path, len(line))
+		}
+
+		var rec record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			return fmt.Errorf("%s: %w", o.path, err)
+		}
+		valid += int64(len(line))
+
+		switch {
+		case rec.Add != nil:
+			pending[rec.Add.Seq] = rec.Add
+			o.nextSeq = max(o.nextSeq, rec.Add.Seq+1)
+		case rec.Done != 0:
+			delete(pending, rec.Done)
+			o.done++
+		}
+	}
+
+	if err := f.Truncate(valid); err != nil {
+		return err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		return err
+	}
+
+	entries := make([]*Entry, 0, len(pending))
+	for _, entry := range pending {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	for _, entry := range entries {
+		o.queues[entry.Key] = append(o.queues[entry.Key], entry)
+	}
+	o.depth = len(entries)
+
+	return nil
+}
+
+// append writes a record and syncs it to disk. A record that is not fully on disk is
+// cut off again, so it is neither loaded by the next Open nor continued by the next
+// record. o.mu must be held.
+func (o *Outbox) append(rec record) error {
+	if o.closed {
+		return ErrClosed
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	offset, err := o.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.f.Write(append(b, '\n'))
+	if err == nil {
+		err = o.f.Sync()
+	}
+	if err != nil {
+		o.rollback(offset)
+		return err
+	}
+	return nil
+}
+
+// rollback truncates the log to offset after a failed append. When that fails too the
+// end of the log is unknown, so the outbox is closed rather than appended to; the next
+// Open cuts the torn record off. o.mu must be held.
+func (o *Outbox) rollback(offset int64) {
+	err := o.f.Truncate(offset)
+	if err == nil {
+		_, err = o.f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		log.Printf("Outbox: cutting a failed write off %s failed, closing it: %v", o.path, err)
+		o.closed = true
+		o.f.Close()
+	}
+}
+
+// Add stores an event to be published to routingKey. It returns once the entry is on
+// disk, so the caller may acknowledge the event. An envelope that could never be
+// published is refused, as is any event while the outbox is full.
+func (o *Outbox) Add(key, routingKey string, env envelope.Envelope) (Entry, error) {
+	if err := env.Validate(); err != nil {
+		return Entry{}, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.limit > 0 && o.depth >= o.limit {
+		return Entry{}, ErrFull
+	}
+
+	entry := &Entry{
+		Seq:        o.nextSeq,
+		Key:        key,
+		RoutingKey: routingKey,
+		Envelope:   env,
+		Created:    time.Now(),
+	}
+	if err := o.append(record{Add: entry}); err != nil {
+		return Entry{}, err
+	}
+	o.nextSeq++
+	o.queues[key] = append(o.queues[key], entry)
+	o.depth++
+
+	// wake the relay
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	return *entry, nil
+}
+
+// Done removes a published entry. Only the head of a key's queue can be done, which
+// keeps the entries of a key in order.
+func (o *Outbox) Done(entry Entry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.checkHead(entry); err != nil {
+		return err
+	}
+	return o.remove(entry)
+}
+
+// Park moves the head entry of a key to the dead-letter file, for an event that can
+// never be published, so it no longer holds up the entries after it. The entry is
+// written there before it is removed from the log; a crash in between parks it twice.
+func (o *Outbox) Park(entry Entry, reason string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return ErrClosed
+	}
+	if err := o.checkHead(entry); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(Parked{Entry: entry, Reason: reason, Parked: time.Now()})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(o.path)
+	f, err := os.OpenFile(filepath.Join(dir, deadFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+
+	return o.remove(entry)
+}
+
+// checkHead fails unless entry is the head of its key's queue. o.mu must be held.
+func (o *Outbox) checkHead(entry Entry) error {
+	queue := o.queues[entry.Key]
+	if len(queue) == 0 || queue[0].Seq != entry.Seq {
+		return fmt.Errorf("outbox entry %d is not the head of %q", entry.Seq, entry.Key)
+	}
+	return nil
+}
+
+// remove records that the head entry of a key is gone. Once the record is on disk the
+// entry is removed, even when the compaction that may follow fails; that is logged and
+// tried again after the next entry. o.mu must be held.
+func (o *Outbox) remove(entry Entry) error {
+	if err := o.append(record{Done: entry.Seq}); err != nil {
+		return err
+	}
+
+	queue := o.queues[entry.Key]
+	if len(queue) == 1 {
+		delete(o.queues, entry.Key)
+	} else {
+		o.queues[entry.Key] = queue[1:]
+	}
+	o.depth--
+	o.done++
+
+	if o.done >= compactAfter {
+		if err := o.compact(); err != nil {
+			log.Printf("Outbox: compacting %s failed: %v", o.path, err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log with only the pending entries. The new log is synced and
+// renamed over the old one, and the directory is synced so the rename survives a
+// crash, which leaves either of the logs intact. o.mu must be held.
+func (o *Outbox) compact() error {
+	tmp := o.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, entry := range o.entries() {
+		b, err := json.Marshal(record{Add: entry})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(b)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		f.Close()
+		return err
+	}
+
+	// the new log is in place, whether or not the directory sync below succeeds
+	o.f.Close()
+	o.f = f
+	o.done = 0
+	return syncDir(filepath.Dir(o.path))
+}
+
+// syncDir syncs a directory, making the files created or renamed in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// entries returns the pending entries in the order they were added. o.mu must be held.
+func (o *Outbox) entries() []*Entry {
+	entries := make([]*Entry, 0, o.depth)
+	for _, queue := range o.queues {
+		entries = append(entries, queue...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries
+}
+
+// Heads returns the first pending entry of every key, oldest first.
+func (o *Outbox) Heads() []Entry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	heads := make([]Entry, 0, len(o.queues))
+	for _, queue := range o.queues {
+		heads = append(heads, *queue[0])
+	}
+	sort.Slice(heads, func(i, j int) bool { return heads[i].Seq < heads[j].Seq })
+	return heads
+}
+
+// Head returns the first pending entry of key.
+func (o *Outbox) Head(key string) (Entry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	queue := o.queues[key]
+	if len(queue) == 0 {
+		return Entry{}, false
+	}
+	return *queue[0], true
+}
+
+// Notify returns a channel that receives after entries were added.
+func (o *Outbox) Notify() <-chan struct{} {
+	return o.notify
+}
+
+// Limit returns the maximum number of pending entries, 0 for none.
+func (o *Outbox) Limit() int {
+	return o.limit
+}
+
+// Depth reports the number of pending entries, the number of keys they are queued
+// under and when the oldest of them was added.
+func (o *Outbox) Depth() (depth, keys int, oldest time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, queue := range o.queues {
+		if oldest.IsZero() || queue[0].Created.Before(oldest) {
+			oldest = queue[0].Created
+		}
+	}
+	return o.depth, len(o.queues), oldest
+}
+
+// Close closes the log. Pending entries stay on disk for the next Open.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	return o.f.Close()
+}
@@ -0,0 +1,304 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"shared/envelope"
+)
+
+func logEvent(t *testing.T, data string) envelope.Envelope {
+	t.Helper()
+
+	env, err := envelope.New(envelope.TypeLog, "broker-service", envelope.LogData{Name: "test", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func open(t *testing.T, dir string, limit int) *Outbox {
+	t.Helper()
+
+	o, err := Open(dir, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+	return o
+}
+
+func add(t *testing.T, o *Outbox, key, data string) Entry {
+	t.Helper()
+
+	entry, err := o.Add(key, "log.INFO."+key, logEvent(t, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+// dataOf returns the log data of the entries.
+func dataOf(t *testing.T, entries []Entry) []string {
+	t.Helper()
+
+	var got []string
+	for _, entry := range entries {
+		var data envelope.LogData
+		if err := json.Unmarshal(entry.Envelope.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, data.Data)
+	}
+	return got
+}
+
+func parked(t *testing.T, dir string) []Parked {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join(dir, deadFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []Parked
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var p Parked
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, p)
+	}
+	return entries
+}
+
+func TestOutboxReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	o := open(t, dir, 0)
+	first := add(t, o, "a", "1")
+	add(t, o, "b", "2")
+	add(t, o, "a", "3")
+	if err := o.Done(first); err != nil {
+		t.Fatal(err)
+	}
+	o.Close()
+
+	o = open(t, dir, 0)
+	if got := dataOf(t, o.Heads()); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("heads = %v, want 2 and 3", got)
+	}
+	if depth, keys, _ := o.Depth(); depth != 2 || keys != 2 {
+		t.Errorf("depth = %d in %d keys, want 2 in 2", depth, keys)
+	}
+	// sequence numbers go on where they stopped
+	if entry := add(t, o, "a", "4"); entry.Seq != 4 {
+		t.Errorf("seq = %d, want 4", entry.Seq)
+	}
+}
+
+func TestOutboxDoneOnlyHead(t *testing.T) {
+	o := open(t, t.TempDir(), 0)
+	add(t, o, "a", "1")
+	second := add(t, o, "a", "2")
+
+	if err := o.Done(second); err == nil {
+		t.Error("Done of an entry behind the head succeeded")
+	}
+	if err := o.Park(second, "poison"); err == nil {
+		t.Error("Park of an entry behind the head succeeded")
+	}
+}
+
+func TestOutboxLimit(t *testing.T) {
+	o := open(t, t.TempDir(), 2)
+	first := add(t, o, "a", "1")
+	add(t, o, "b", "2")
+
+	if _, err := o.Add("a", "log.INFO.a", logEvent(t, "3")); !errors.Is(err, ErrFull) {
+		t.Fatalf("err = %v, want %v", err, ErrFull)
+	}
+	if err := o.Done(first); err != nil {
+		t.Fatal(err)
+	}
+	add(t, o, "a", "3")
+}
+
+func TestOutboxRefusesInvalid(t *testing.T) {
+	o := open(t, t.TempDir(), 0)
+
+	env := logEvent(t, "1")
+	env.Source = ""
+	if _, err := o.Add("a", "log.INFO.a", env); !errors.Is(err, envelope.ErrInvalid) {
+		t.Errorf("err = %v, want %v", err, envelope.ErrInvalid)
+	}
+	if depth, _, _ := o.Depth(); depth != 0 {
+		t.Errorf("depth = %d, want 0", depth)
+	}
+}
+
+func TestOutboxPark(t *testing.T) {
+	dir := t.TempDir()
+
+	o := open(t, dir, 0)
+	first := add(t, o, "a", "1")
+	add(t, o, "a", "2")
+	if err := o.Park(first, "no queue is bound"); err != nil {
+		t.Fatal(err)
+	}
+	if got := dataOf(t, o.Heads()); len(got) != 1 || got[0] != "2" {
+		t.Errorf("heads = %v, want 2", got)
+	}
+	o.Close()
+
+	dead := parked(t, dir)
+	if len(dead) != 1 || dead[0].Entry.Seq != first.Seq || dead[0].Reason != "no queue is bound" {
+		t.Fatalf("parked = %+v", dead)
+	}
+	if dead[0].Entry.Envelope.ID != first.Envelope.ID {
+		t.Errorf("parked envelope %s, want %s", dead[0].Entry.Envelope.ID, first.Envelope.ID)
+	}
+
+	// parked entries do not come back
+	o = open(t, dir, 0)
+	if depth, _, _ := o.Depth(); depth != 1 {
+		t.Errorf("depth after reopening = %d, want 1", depth)
+	}
+}
+
+func TestOutboxCompacts(t *testing.T) {
+	dir := t.TempDir()
+
+	o := open(t, dir, 0)
+	for range compactAfter {
+		if err := o.Done(add(t, o, "a", "x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(t, o, "a", "kept")
+
+	// only the pending entry is left in the log
+	b, err := os.ReadFile(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(b, []byte("\n")); lines != 1 {
+		t.Errorf("the log has %d lines after compacting, want 1", lines)
+	}
+	o.Close()
+
+	o = open(t, dir, 0)
+	if got := dataOf(t, o.Heads()); len(got) != 1 || got[0] != "kept" {
+		t.Errorf("heads = %v, want kept", got)
+	}
+}
+
+// failingFile is a log on a disk that fails: a write stops after failWriteAfter bytes,
+// a sync or a truncate fails.
+type failingFile struct {
+	file
+	failWriteAfter int
+	failSync       bool
+	failTruncate   bool
+}
+
+var errDisk = errors.New("disk failed")
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	if f.failWriteAfter > 0 {
+		n, _ := f.file.Write(b[:min(f.failWriteAfter, len(b))])
+		return n, errDisk
+	}
+	return f.file.Write(b)
+}
+
+func (f *failingFile) Sync() error {
+	if f.failSync {
+		return errDisk
+	}
+	return f.file.Sync()
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errDisk
+	}
+	return f.file.Truncate(size)
+}
+
+func TestOutboxFailedAppend(t *testing.T) {
+	tests := []struct {
+		name string
+		disk failingFile
+	}{
+		{"partial write", failingFile{failWriteAfter: 10}},
+		{"failed sync", failingFile{failSync: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			o := open(t, dir, 0)
+			first := add(t, o, "a", "1")
+
+			disk := tt.disk
+			disk.file = o.f
+			o.f = &disk
+			if _, err := o.Add("a", "log.INFO.a", logEvent(t, "2")); !errors.Is(err, errDisk) {
+				t.Fatalf("err = %v, want %v", err, errDisk)
+			}
+			if err := o.Done(first); !errors.Is(err, errDisk) {
+				t.Fatalf("done: err = %v, want %v", err, errDisk)
+			}
+			o.f = disk.file
+
+			// the failed records are gone, and the next ones are not appended to them
+			if entry := add(t, o, "a", "3"); entry.Seq != 2 {
+				t.Errorf("seq = %d, want 2", entry.Seq)
+			}
+			o.Close()
+
+			o = open(t, dir, 0)
+			if depth, _, _ := o.Depth(); depth != 2 {
+				t.Errorf("depth = %d, want 2", depth)
+			}
+			if head, _ := o.Head("a"); head.Seq != first.Seq {
+				t.Errorf("head = %d, want %d, whose done record failed", head.Seq, first.Seq)
+			}
+		})
+	}
+}
+
+func TestOutboxFailedRollback(t *testing.T) {
+	dir := t.TempDir()
+	o := open(t, dir, 0)
+	add(t, o, "a", "1")
+
+	o.f = &failingFile{file: o.f, failWriteAfter: 10, failTruncate: true}
+	if _, err := o.Add("a", "log.INFO.a", logEvent(t, "2")); !errors.Is(err, errDisk) {
+		t.Fatalf("err = %v, want %v", err, errDisk)
+	}
+	// the end of the log is unknown, so nothing more is appended to it
+	if _, err := o.Add("a", "log.INFO.a", logEvent(t, "3")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err = %v, want %v", err, ErrClosed)
+	}
+
+	// the torn record is cut off when the outbox is opened again
+	o = open(t, dir, 0)
+	if got := dataOf(t, o.Heads()); len(got) != 1 || got[0] != "1" {
+		t.Errorf("heads = %v, want 1", got)
+	}
+	if entry := add(t, o, "a", "4"); entry.Seq != 2 {
+		t.Errorf("seq = %d, want 2", entry.Seq)
+	}
+}
@@ -0,0 +1,244 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"shared/envelope"
+)
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Minute
+	// idleInterval is how often the relay looks at the outbox without being notified.
+	idleInterval = 5 * time.Second
+)
+
+// Publisher publishes an event. event.Emitter implements it.
+type Publisher interface {
+	Push(ctx context.Context, env envelope.Envelope, routingKey string) error
+}
+
+// RetryState tracks a key whose head entry failed to publish.
+type RetryState struct {
+	Attempts  int       `json:"attempts"`
+	NextRetry time.Time `json:"next_retry"`
+	LastError string    `json:"last_error"`
+}
+
+// Stats describes the outbox for the depth endpoint.
+type Stats struct {
+	Depth     int                    `json:"depth"`
+	Limit     int                    `json:"limit,omitempty"`
+	Keys      int                    `json:"keys"`
+	Oldest    *time.Time             `json:"oldest,omitempty"`
+	Published int64                  `json:"published"`
+	Parked    int64                  `json:"parked"`
+	Retrying  map[string]*RetryState `json:"retrying,omitempty"`
+}
+
+// Relay drains the outbox to RabbitMQ. The entries of a key are published one after the
+// other and only removed once confirmed, so a key whose head keeps failing is retried
+// with exponential backoff while the other keys go on. An entry whose confirmation was
+// lost may be published twice; consumers drop the copy by its envelope ID. An entry
+// that fails with an error retrying cannot fix is parked in the dead-letter file.
+type Relay struct {
+	outbox    *Outbox
+	publisher Publisher
+	poison    func(error) bool
+
+	mu        sync.Mutex
+	retries   map[string]*RetryState
+	published int64
+	parked    int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRelay returns a relay publishing with publisher. poison reports the publish errors
+// that retrying cannot fix, e.g. an unroutable event; invalid envelopes are always
+// parked. poison may be nil.
+func NewRelay(outbox *Outbox, publisher Publisher, poison func(error) bool) *Relay {
+	return &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		poison:    poison,
+		retries:   map[string]*RetryState{},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Run relays until Stop is called.
+func (r *Relay) Run() {
+	defer close(r.done)
+
+	for {
+		wait := r.drain()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.stop:
+			timer.Stop()
+			return
+		case <-r.outbox.Notify():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Stop stops the relay after the entry being published, and waits for it.
+func (r *Relay) Stop() {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	<-r.done
+}
+
+// drain publishes every key that is not backing off and returns how long to wait
+// before the next pass.
+func (r *Relay) drain() time.Duration {
+	wait := idleInterval
+
+	for _, head := range r.outbox.Heads() {
+		if r.stopped() {
+			return wait
+		}
+		if next, ok := r.nextRetry(head.Key); ok {
+			if until := time.Until(next); until > 0 {
+				wait = min(wait, until)
+				continue
+			}
+		}
+
+		if !r.drainKey(head.Key) {
+			next, _ := r.nextRetry(head.Key)
+			wait = min(wait, max(time.Until(next), 0))
+		}
+	}
+
+	return wait
+}
+
+// drainKey publishes the entries of key in order until the queue is empty or a publish
+// fails. It reports whether the queue was emptied.
+func (r *Relay) drainKey(key string) bool {
+	for !r.stopped() {
+		entry, ok := r.outbox.Head(key)
+		if !ok {
+			return true
+		}
+
+		if err := r.publisher.Push(context.Background(), entry.Envelope, entry.RoutingKey); err != nil {
+			if !r.poisoned(err) {
+				r.failed(key, err)
+				return false
+			}
+			if err := r.outbox.Park(entry, err.Error()); err != nil {
+				r.failed(key, err)
+				return false
+			}
+			r.parkedEntry(entry, err)
+			continue
+		}
+
+		if err := r.outbox.Done(entry); err != nil {
+			// published, but it stays the head; publishing it again is harmless
+			r.failed(key, err)
+			return false
+		}
+		r.succeeded(key)
+	}
+
+	return false
+}
+
+func (r *Relay) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Relay) poisoned(err error) bool {
+	return errors.Is(err, envelope.ErrInvalid) || (r.poison != nil && r.poison(err))
+}
+
+func (r *Relay) nextRetry(key string) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.retries[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return state.NextRetry, true
+}
+
+func (r *Relay) failed(key string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.retries[key]
+	if !ok {
+		state = &RetryState{}
+		r.retries[key] = state
+	}
+	state.Attempts++
+	state.LastError = err.Error()
+
+	delay := min(minRetryDelay<<min(state.Attempts-1, 6), maxRetryDelay)
+	state.NextRetry = time.Now().Add(delay)
+
+	log.Printf("Outbox relay: publishing %q failed (attempt %d), retrying in %v: %v", key, state.Attempts, delay, err)
+}
+
+func (r *Relay) succeeded(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.retries, key)
+	r.published++
+}
+
+func (r *Relay) parkedEntry(entry Entry, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.retries, entry.Key)
+	r.parked++
+
+	log.Printf("Outbox relay: parked entry %d of %q in %s: %v", entry.Seq, entry.Key, deadFile, err)
+}
+
+// Stats reports the outbox depth and the keys being retried.
+func (r *Relay) Stats() Stats {
+	depth, keys, oldest := r.outbox.Depth()
+	stats := Stats{Depth: depth, Limit: r.outbox.Limit(), Keys: keys}
+	if !oldest.IsZero() {
+		stats.Oldest = &oldest
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats.Published = r.published
+	stats.Parked = r.parked
+	if len(r.retries) > 0 {
+		stats.Retrying = make(map[string]*RetryState, len(r.retries))
+		for key, state := range r.retries {
+			copied := *state
+			stats.Retrying[key] = &copied
+		}
+	}
+	return stats
+}
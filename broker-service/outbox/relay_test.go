@@ -0,0 +1,130 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"shared/envelope"
+)
+
+var (
+	errPoison    = errors.New("no queue is bound")
+	errTransient = errors.New("rabbitmq is not connected")
+)
+
+// publisher records what it published. fail decides the error of a publish, if any.
+type publisher struct {
+	mu        sync.Mutex
+	published []string
+	fail      func(data string, attempt int) error
+	attempts  map[string]int
+}
+
+func (p *publisher) Push(ctx context.Context, env envelope.Envelope, routingKey string) error {
+	var data envelope.LogData
+	if err := json.Unmarshal(env.Data, &data); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.attempts[data.Data]++
+	if err := p.fail(data.Data, p.attempts[data.Data]); err != nil {
+		return err
+	}
+	p.published = append(p.published, data.Data)
+	return nil
+}
+
+func run(t *testing.T, o *Outbox, p *publisher) *Relay {
+	t.Helper()
+
+	r := NewRelay(o, p, func(err error) bool { return errors.Is(err, errPoison) })
+	go r.Run()
+	t.Cleanup(r.Stop)
+	return r
+}
+
+func waitEmpty(t *testing.T, o *Outbox) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if depth, _, _ := o.Depth(); depth == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the outbox to drain")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRelayParksPoison(t *testing.T) {
+	dir := t.TempDir()
+	o := open(t, dir, 0)
+	add(t, o, "a", "bad")
+	add(t, o, "a", "1")
+	add(t, o, "b", "2")
+
+	p := &publisher{attempts: map[string]int{}, fail: func(data string, attempt int) error {
+		if data == "bad" {
+			return errPoison
+		}
+		return nil
+	}}
+	r := run(t, o, p)
+	waitEmpty(t, o)
+	r.Stop()
+
+	// the poison entry does not hold up the entry behind it
+	if got := p.published; len(got) != 2 {
+		t.Errorf("published %v, want 1 and 2", got)
+	}
+	if p.attempts["bad"] != 1 {
+		t.Errorf("the poison entry was tried %d times, want 1", p.attempts["bad"])
+	}
+	if stats := r.Stats(); stats.Published != 2 || stats.Parked != 1 || len(stats.Retrying) != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if dead := parked(t, dir); len(dead) != 1 || dead[0].Reason != errPoison.Error() {
+		t.Errorf("parked = %+v", dead)
+	}
+}
+
+func TestRelayRetries(t *testing.T) {
+	dir := t.TempDir()
+	o := open(t, dir, 0)
+	add(t, o, "a", "1")
+	add(t, o, "a", "2")
+
+	p := &publisher{attempts: map[string]int{}, fail: func(data string, attempt int) error {
+		if data == "1" && attempt == 1 {
+			return errTransient
+		}
+		return nil
+	}}
+	r := run(t, o, p)
+
+	waitEmpty(t, o)
+	r.Stop()
+
+	// the entries of a key stay in order across the retry
+	if got := p.published; len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("published %v, want 1 then 2", got)
+	}
+	if p.attempts["1"] != 2 {
+		t.Errorf("the failing entry was tried %d times, want 2", p.attempts["1"])
+	}
+	if stats := r.Stats(); stats.Parked != 0 || len(stats.Retrying) != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if dead := parked(t, dir); len(dead) != 0 {
+		t.Errorf("parked = %+v", dead)
+	}
+}
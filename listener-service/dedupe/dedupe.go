@@ -0,0 +1,176 @@
+// Package dedupe remembers which events were processed, so redelivered and republished
+// events are handled once.
+package dedupe
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Status is the result of a claim.
+type Status int
+
+const (
+	// Claimed means the caller got the claim and handles the event.
+	Claimed Status = iota
+	// Pending means another delivery of the event holds a claim on it.
+	Pending
+	// Processed means the event was processed.
+	Processed
+)
+
+// Store records processed event IDs. An event is claimed before it is handled, so that
+// concurrent deliveries of it are handled once, and then marked done or released.
+type Store interface {
+	// Claim records the ID as being processed, unless it is processed or claimed
+	// already. A claim that is not marked done lapses after lease, so an event is not
+	// lost with the consumer that claimed it.
+	Claim(ctx context.Context, id string, lease time.Duration) (Status, error)
+	// Done records the ID as processed.
+	Done(ctx context.Context, id string) error
+	// Release drops the claim on an ID that was not processed, so it can be claimed
+	// again.
+	Release(ctx context.Context, id string) error
+}
+
+// LRU remembers the most recently claimed IDs in memory. It is safe for concurrent use.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	ids   map[string]*list.Element
+	now   func() time.Time
+}
+
+// claim is the state of an ID in the LRU.
+type claim struct {
+	id    string
+	done  bool
+	until time.Time
+}
+
+// NewLRU returns a store remembering up to size IDs.
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:  size,
+		order: list.New(),
+		ids:   map[string]*list.Element{},
+		now:   time.Now,
+	}
+}
+
+func (l *LRU) Claim(ctx context.Context, id string, lease time.Duration) (Status, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if el, ok := l.ids[id]; ok {
+		l.order.MoveToFront(el)
+		c := el.Value.(*claim)
+		switch {
+		case c.done:
+			return Processed, nil
+		case now.Before(c.until):
+			return Pending, nil
+		}
+		c.until = now.Add(lease)
+		return Claimed, nil
+	}
+
+	l.add(&claim{id: id, until: now.Add(lease)})
+	return Claimed, nil
+}
+
+func (l *LRU) Done(ctx context.Context, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.ids[id]; ok {
+		l.order.MoveToFront(el)
+		el.Value.(*claim).done = true
+		return nil
+	}
+
+	l.add(&claim{id: id, done: true})
+	return nil
+}
+
+func (l *LRU) Release(ctx context.Context, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.ids[id]; ok && !el.Value.(*claim).done {
+		l.order.Remove(el)
+		delete(l.ids, id)
+	}
+	return nil
+}
+
+// processed reports whether the ID is remembered as processed.
+func (l *LRU) processed(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.ids[id]
+	if !ok || !el.Value.(*claim).done {
+		return false
+	}
+	l.order.MoveToFront(el)
+	return true
+}
+
+// add remembers a new ID, forgetting the least recently used ones beyond the size.
+func (l *LRU) add(c *claim) {
+	l.ids[c.id] = l.order.PushFront(c)
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.ids, oldest.Value.(*claim).id)
+	}
+}
+
+// Cached puts an LRU in front of a persistent store, so recently processed IDs are
+// answered from memory and the persistent store survives restarts and is shared between
+// replicas. Claims are always made in the persistent store, since only it sees the
+// deliveries of every replica.
+type Cached struct {
+	lru     *LRU
+	backing Store
+}
+
+func NewCached(lru *LRU, backing Store) *Cached {
+	return &Cached{lru: lru, backing: backing}
+}
+
+func (c *Cached) Claim(ctx context.Context, id string, lease time.Duration) (Status, error) {
+	if c.lru.processed(id) {
+		return Processed, nil
+	}
+
+	status, err := c.backing.Claim(ctx, id, lease)
+	if err != nil {
+		return Claimed, err
+	}
+	if status == Processed {
+		c.lru.Done(ctx, id)
+	}
+	return status, nil
+}
+
+// Done always records the ID in memory; a failure of the persistent store is returned
+// but the ID is still deduplicated by this process.
+func (c *Cached) Done(ctx context.Context, id string) error {
+	c.lru.Done(ctx, id)
+	if err := c.backing.Done(ctx, id); err != nil {
+		log.Println("failed to persist processed event id:", err)
+		return err
+	}
+	return nil
+}
+
+func (c *Cached) Release(ctx context.Context, id string) error {
+	return c.backing.Release(ctx, id)
+}
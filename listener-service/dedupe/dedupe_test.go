@@ -0,0 +1,205 @@
+package dedupe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The conformance suite runs against every Store. The LRU and the cached store always
+// run; MongoDB and PostgreSQL run when LISTENER_TEST_MONGODB_URI or
+// LISTENER_TEST_POSTGRES_DSN point at a server.
+
+func TestLRU(t *testing.T) {
+	testStore(t, NewLRU(100))
+}
+
+func TestCached(t *testing.T) {
+	testStore(t, NewCached(NewLRU(100), NewLRU(100)))
+}
+
+func TestMongo(t *testing.T) {
+	uri := os.Getenv("LISTENER_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("LISTENER_TEST_MONGODB_URI is not set")
+	}
+
+	store, err := NewMongo(context.Background(), uri, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("LISTENER_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LISTENER_TEST_POSTGRES_DSN is not set")
+	}
+
+	store, err := NewPostgres(context.Background(), dsn, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.db.Close() })
+	testStore(t, store)
+}
+
+// testStore checks the behaviour every store must have. The IDs are unique to the run,
+// so stores sharing a database between runs see no other claims.
+func testStore(t *testing.T, store Store) {
+	run := time.Now().UnixNano()
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store Store, id string)
+	}{
+		{"ClaimOnce", testClaimOnce},
+		{"ClaimConcurrent", testClaimConcurrent},
+		{"Release", testRelease},
+		{"LeaseLapses", testLeaseLapses},
+		{"DoneWithoutClaim", testDoneWithoutClaim},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, store, fmt.Sprintf("test/%d/%s", run, tt.name))
+		})
+	}
+}
+
+func wantClaim(t *testing.T, store Store, id string, lease time.Duration, want Status) {
+	t.Helper()
+
+	got, err := store.Claim(context.Background(), id, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Claim(%s) = %d, want %d", id, got, want)
+	}
+}
+
+func done(t *testing.T, store Store, id string) {
+	t.Helper()
+
+	if err := store.Done(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testClaimOnce(t *testing.T, store Store, id string) {
+	wantClaim(t, store, id, time.Minute, Claimed)
+	wantClaim(t, store, id, time.Minute, Pending)
+
+	done(t, store, id)
+	wantClaim(t, store, id, time.Minute, Processed)
+	// a processed event stays processed, whatever its lease was
+	if err := store.Release(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	wantClaim(t, store, id, time.Minute, Processed)
+}
+
+func testClaimConcurrent(t *testing.T, store Store, id string) {
+	const deliveries = 20
+
+	var wg sync.WaitGroup
+	statuses := make(chan Status, deliveries)
+	for range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, err := store.Claim(context.Background(), id, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses <- status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	claimed := 0
+	for status := range statuses {
+		if status == Claimed {
+			claimed++
+		}
+	}
+	if claimed != 1 {
+		t.Errorf("%d of %d concurrent deliveries got the claim, want 1", claimed, deliveries)
+	}
+}
+
+func testRelease(t *testing.T, store Store, id string) {
+	wantClaim(t, store, id, time.Minute, Claimed)
+	if err := store.Release(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	// the retry of a failed event is handled
+	wantClaim(t, store, id, time.Minute, Claimed)
+}
+
+func testLeaseLapses(t *testing.T, store Store, id string) {
+	wantClaim(t, store, id, 50*time.Millisecond, Claimed)
+	time.Sleep(100 * time.Millisecond)
+	// the consumer holding the claim went away
+	wantClaim(t, store, id, time.Minute, Claimed)
+	wantClaim(t, store, id, time.Minute, Pending)
+}
+
+func testDoneWithoutClaim(t *testing.T, store Store, id string) {
+	// an event handled while the store was down is still recorded
+	done(t, store, id)
+	wantClaim(t, store, id, time.Minute, Processed)
+}
+
+func TestLRUEvicts(t *testing.T) {
+	lru := NewLRU(2)
+	for _, id := range []string{"a", "b", "c"} {
+		done(t, lru, id)
+	}
+	// "a" was the least recently used
+	wantClaim(t, lru, "a", time.Minute, Claimed)
+	wantClaim(t, lru, "c", time.Minute, Processed)
+}
+
+// countingStore counts the claims that reach the backing store.
+type countingStore struct {
+	Store
+	mu     sync.Mutex
+	claims int
+}
+
+func (s *countingStore) Claim(ctx context.Context, id string, lease time.Duration) (Status, error) {
+	s.mu.Lock()
+	s.claims++
+	s.mu.Unlock()
+	return s.Store.Claim(ctx, id, lease)
+}
+
+func TestCachedAnswersFromMemory(t *testing.T) {
+	backing := &countingStore{Store: NewLRU(100)}
+	replica := NewCached(NewLRU(100), backing)
+	other := NewCached(NewLRU(100), backing)
+
+	wantClaim(t, replica, "a", time.Minute, Claimed)
+	// pending claims are shared between replicas through the backing store
+	wantClaim(t, other, "a", time.Minute, Pending)
+	done(t, replica, "a")
+
+	backing.claims = 0
+	wantClaim(t, replica, "a", time.Minute, Processed)
+	if backing.claims != 0 {
+		t.Errorf("the backing store got %d claims for an event processed here, want 0", backing.claims)
+	}
+
+	// another replica learns it from the backing store once, then remembers it
+	wantClaim(t, other, "a", time.Minute, Processed)
+	wantClaim(t, other, "a", time.Minute, Processed)
+	if backing.claims != 1 {
+		t.Errorf("the backing store got %d claims, want 1", backing.claims)
+	}
+}
@@ -0,0 +1,105 @@
+package dedupe
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Mongo keeps claimed and processed IDs in the "processed_events" collection of the
+// listener database, expiring them after ttl.
+type Mongo struct {
+	events *mongo.Collection
+}
+
+// NewMongo connects to uri and creates the TTL index if needed.
+func NewMongo(ctx context.Context, uri string, ttl time.Duration) (*Mongo, error) {
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	events := client.Database("listener").Collection("processed_events")
+	_, err = events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "processed_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ttl / time.Second)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mongo{events: events}, nil
+}
+
+// Claim inserts the ID, which the unique _id makes atomic; an ID that is already there
+// is taken over only if its claim lapsed.
+func (m *Mongo) Claim(ctx context.Context, id string, lease time.Duration) (Status, error) {
+	now := time.Now()
+	_, err := m.events.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "processed_at", Value: now},
+		{Key: "claimed_until", Value: now.Add(lease)},
+		{Key: "done", Value: false},
+	})
+	if err == nil {
+		return Claimed, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return Claimed, err
+	}
+
+	res, err := m.events.UpdateOne(ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "done", Value: false},
+			{Key: "claimed_until", Value: bson.D{{Key: "$lt", Value: now}}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "processed_at", Value: now},
+			{Key: "claimed_until", Value: now.Add(lease)},
+		}}},
+	)
+	if err != nil {
+		return Claimed, err
+	}
+	if res.MatchedCount == 1 {
+		return Claimed, nil
+	}
+	return m.status(ctx, id)
+}
+
+// status tells a processed ID from one claimed by someone else.
+func (m *Mongo) status(ctx context.Context, id string) (Status, error) {
+	var doc struct {
+		Done bool `bson:"done"`
+	}
+	err := m.events.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&doc)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return Claimed, err
+	}
+	if doc.Done {
+		return Processed, nil
+	}
+	// claimed, or released since; either way the delivery is tried again later
+	return Pending, nil
+}
+
+func (m *Mongo) Done(ctx context.Context, id string) error {
+	_, err := m.events.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "processed_at", Value: time.Now()},
+			{Key: "done", Value: true},
+		}}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+func (m *Mongo) Release(ctx context.Context, id string) error {
+	_, err := m.events.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "done", Value: false}})
+	return err
+}
@@ -0,0 +1,100 @@
+package dedupe
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const postgresSchema = `
+create table if not exists processed_events (
+	id text primary key,
+	processed_at timestamptz not null,
+	claimed_until timestamptz not null,
+	done boolean not null default false
+);
+`
+
+// Postgres keeps claimed and processed IDs in the processed_events table, pruning those
+// older than ttl now and then.
+type Postgres struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu     sync.Mutex
+	pruned time.Time
+}
+
+// NewPostgres connects to dsn and creates the table if needed.
+func NewPostgres(ctx context.Context, dsn string, ttl time.Duration) (*Postgres, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, postgresSchema); err != nil {
+		return nil, fmt.Errorf("creating postgres schema: %w", err)
+	}
+
+	return &Postgres{db: db, ttl: ttl}, nil
+}
+
+// Claim inserts the ID, which the primary key makes atomic; an ID that is already there
+// is taken over only if it expired or its claim lapsed.
+func (p *Postgres) Claim(ctx context.Context, id string, lease time.Duration) (Status, error) {
+	p.prune(ctx)
+
+	now := time.Now()
+	res, err := p.db.ExecContext(ctx, `insert into processed_events (id, processed_at, claimed_until) values ($1, $2, $3)
+		on conflict (id) do update set processed_at = excluded.processed_at, claimed_until = excluded.claimed_until, done = false
+		where processed_events.processed_at < $4 or (not processed_events.done and processed_events.claimed_until < $2)`,
+		id, now, now.Add(lease), now.Add(-p.ttl))
+	if err != nil {
+		return Claimed, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return Claimed, err
+	}
+
+	var done bool
+	err = p.db.QueryRowContext(ctx, `select done from processed_events where id = $1`, id).Scan(&done)
+	if err != nil && err != sql.ErrNoRows {
+		return Claimed, err
+	}
+	if done {
+		return Processed, nil
+	}
+	// claimed, or released since; either way the delivery is tried again later
+	return Pending, nil
+}
+
+func (p *Postgres) Done(ctx context.Context, id string) error {
+	now := time.Now()
+	_, err := p.db.ExecContext(ctx, `insert into processed_events (id, processed_at, claimed_until, done) values ($1, $2, $2, true)
+		on conflict (id) do update set processed_at = excluded.processed_at, done = true`, id, now)
+	return err
+}
+
+func (p *Postgres) Release(ctx context.Context, id string) error {
+	_, err := p.db.ExecContext(ctx, `delete from processed_events where id = $1 and not done`, id)
+	return err
+}
+
+// prune deletes expired IDs, at most once an hour.
+func (p *Postgres) prune(ctx context.Context) {
+	p.mu.Lock()
+	if time.Since(p.pruned) < time.Hour {
+		p.mu.Unlock()
+		return
+	}
+	p.pruned = time.Now()
+	p.mu.Unlock()
+
+	if _, err := p.db.ExecContext(ctx, `delete from processed_events where processed_at < $1`, time.Now().Add(-p.ttl)); err != nil {
+		log.Println("failed to prune processed events:", err)
+	}
+}
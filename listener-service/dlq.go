@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"listener/event"
+	"os"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// runDLQ implements the `dlq` subcommand for working with dead-lettered messages:
+//
+//	listener dlq list -limit 20
+//	listener dlq replay -limit 100 [-force]
+//	listener dlq purge
+func runDLQ(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: listener dlq list|replay|purge [flags]")
+		return 2
+	}
+
+	command := args[0]
+	fs := flag.NewFlagSet("dlq "+command, flag.ContinueOnError)
+	url := fs.String("url", rabbitmqUrl, "RabbitMQ URL")
+	limit := fs.Int("limit", 100, "maximum number of messages to list or replay")
+	force := fs.Bool("force", false, "replay messages that exhausted their retries too")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	conn, err := amqp.Dial(*url)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "connecting to rabbitmq:", err)
+		return 1
+	}
+	defer conn.Close()
+
+	letters, err := event.NewDeadLetters(conn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch command {
+	case "list":
+		messages, err := letters.Inspect(*limit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(messages); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "replay":
+		replayed, skipped, err := letters.Replay(*limit, *force)
+		fmt.Printf("replayed %d messages, skipped %d\n", replayed, skipped)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "purge":
+		purged, err := letters.Purge()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("purged %d messages\n", purged)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown dlq command %q\n", command)
+		return 2
+	}
+
+	return 0
+}
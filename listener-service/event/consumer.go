@@ -1,184 +1,540 @@
 package event
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"os"
+	"sync"
 	"time"
 
+	"listener/dedupe"
+	"shared/rabbit"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-const (
-	loggerServiceURL = "http://logger-service:8080/log" // ← change this to your real logger URL
-	httpTimeout      = 5 * time.Second
-)
+const httpTimeout = 5 * time.Second
+
+// handleTimeout bounds a handler, which may call several services for one event.
+const handleTimeout = 3 * httpTimeout
+
+// claimLease is how long a claimed event stays claimed when its consumer goes away before
+// it is handled; it outlasts the handler and the retry that follows a failure.
+const claimLease = 2 * handleTimeout
+
+// Options configures a Consumer.
+type Options struct {
+	// Group names the consumer group. Listeners of the same group share the durable
+	// queue "logs.<group>" and each message is handled by one of them; every group gets
+	// its own copy of the messages.
+	Group string
+	// Topics are the routing keys the group's queue is bound to; they may use the topic
+	// exchange wildcards "*" and "#".
+	Topics []string
+
+	Retry RetryPolicy
+	// Workers is the number of deliveries handled concurrently. It is also the channel
+	// prefetch, so RabbitMQ never hands the consumer more messages than it can work on.
+	Workers int
+	// TopicConcurrency caps the concurrent deliveries per routing key; keys may use
+	// path.Match wildcards, and all topics whose most specific match is one pattern
+	// share its limit. A
+	// delivery whose topic is at its limit waits without taking a worker; up to the limit
+	// of them are held, and any further ones are deferred through the first retry tier
+	// without counting an attempt. The prefetch grows by the held deliveries, so the
+	// workers keep taking other topics.
+	TopicConcurrency map[string]int
+	// Dedupe records the events the group claimed and processed, so redeliveries are
+	// skipped. Nil disables de-duplication.
+	Dedupe dedupe.Store
+}
+
+// DefaultOptions consumes every log event ("log.#", the broker publishes
+// "log.<severity>.<category>") and every auth event ("auth.#", auth-service publishes
+// "auth.<type>") as the "listener" group, with the default retry policy and 10 workers.
+func DefaultOptions() Options {
+	return Options{
+		Group:   "listener",
+		Topics:  []string{"log.#", "auth.#"},
+		Retry:   DefaultRetryPolicy(),
+		Workers: 10,
+	}
+}
 
 type Consumer struct {
-	conn *amqp.Connection
+	rabbit *rabbit.Manager
+
+	handler EventHandler
+	dedupe  dedupe.Store
+	queue   string
+	topics  []string
+	retry   RetryPolicy
+	workers int
+
+	topicLimits map[string]int
 
-	httpClient *http.Client
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu   sync.Mutex
+	ch   *amqp.Channel
+	tag  string
+	done chan struct{}
 }
 
-func NewConsumer(conn *amqp.Connection) (*Consumer, error) {
-	httpClient := &http.Client{
-		Timeout: httpTimeout,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 20,
-			IdleConnTimeout:     90 * time.Second,
-		},
+// NewConsumer returns a consumer passing events to handler, usually a Registry. Its
+// topology is registered with the manager, so it is declared again after every reconnect.
+func NewConsumer(manager *rabbit.Manager, handler EventHandler, opts Options) (*Consumer, error) {
+	if len(opts.Retry.Delays) == 0 || opts.Retry.MaxAttempts < 1 {
+		return nil, errors.New("retry policy needs at least one delay and attempt")
+	}
+	if opts.Workers < 1 {
+		return nil, errors.New("consumer needs at least one worker")
+	}
+	if opts.Group == "" || len(opts.Topics) == 0 {
+		return nil, errors.New("consumer needs a group and at least one topic")
 	}
 
 	consumer := &Consumer{
-		conn: conn,
+		rabbit: manager,
+		stop:   make(chan struct{}),
+
+		handler: handler,
+		dedupe:  opts.Dedupe,
+		queue:   groupQueue(opts.Group),
+		topics:  opts.Topics,
+		retry:   opts.Retry,
+		workers: opts.Workers,
 
-		httpClient: httpClient,
+		topicLimits: opts.TopicConcurrency,
 	}
 
-	if err := consumer.setup(); err != nil {
+	if err := manager.Declare(consumer.declare); err != nil {
 		return nil, err
 	}
 
 	return consumer, nil
 }
 
-func (c *Consumer) setup() error {
-	ch, err := c.conn.Channel()
-	if err != nil {
+func (c *Consumer) declare(ch *amqp.Channel) error {
+	if err := declareExchange(ch); err != nil {
 		return err
 	}
-	defer ch.Close()
-
-	return declareExchange(ch)
+	if err := declareDeadLetter(ch); err != nil {
+		return err
+	}
+	if err := declareGroupQueue(ch, c.queue, c.topics); err != nil {
+		return err
+	}
+	return declareRetryTiers(ch, c.queue, c.retry.Delays)
 }
 
-// Payload is what we expect from the queue messages
+// Payload is the name and data handlers dispatch on.
 type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Severity string `json:"severity,omitempty"`
+	// IdempotencyKey is the envelope ID; logger-service stores one entry per key.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
-type LogResponse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
+// Listen consumes the group's queue until ctx is cancelled or Stop is called, handling
+// deliveries on the worker pool. When the channel or connection is lost it waits for the
+// manager to reconnect and consumes again. It returns once the in-flight deliveries are
+// done; use Stop to bound how long that may take.
+func (c *Consumer) Listen(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	c.mu.Lock()
+	c.done = done
+	c.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := c.cancel(); err != nil {
+				log.Println("failed to cancel consumer:", err)
+			}
+		case <-done:
+		}
+	}()
+
+	for {
+		err := c.consume()
+
+		select {
+		case <-c.stop:
+			log.Println("Consumer drained")
+			return nil
+		default:
+		}
+		log.Println("Consumer lost its channel, waiting for rabbitmq:", err)
+
+		select {
+		case <-c.rabbit.Ready():
+		case <-c.stop:
+			return nil
+		}
+		// the manager may not have noticed a dropped connection yet
+		time.Sleep(time.Second)
+	}
 }
 
-func (c *Consumer) Listen(topics []string) error {
-	ch, err := c.conn.Channel()
+// consume runs one consumer session, until the channel is closed or cancelled.
+func (c *Consumer) consume() error {
+	ch, err := c.rabbit.Channel()
 	if err != nil {
 		return err
 	}
 	defer ch.Close()
 
-	q, err := declareRandomQueue(ch)
+	if err := ch.Qos(c.prefetch(), 0, false); err != nil {
+		return fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	// retries are published on their own channel so they never wait behind deliveries;
+	// a delivery is only acknowledged once RabbitMQ confirmed its retry copy
+	retryCh, err := c.rabbit.Channel()
 	if err != nil {
 		return err
 	}
-
-	for _, topic := range topics {
-		if err = ch.QueueBind(
-			q.Name,       // queue
-			topic,        // routing key = topic
-			"logs_topic", // exchange
-			false,
-			nil,
-		); err != nil {
-			return fmt.Errorf("failed to bind queue to topic %s: %w", topic, err)
-		}
+	defer retryCh.Close()
+	if err := retryCh.Confirm(false); err != nil {
+		return fmt.Errorf("failed to put the retry channel in confirm mode: %w", err)
 	}
 
+	tag := fmt.Sprintf("listener-%d-%d", os.Getpid(), time.Now().UnixNano())
 	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer tag (empty = auto)
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
+		c.queue, // queue
+		tag,     // consumer tag, used by Stop
+		false,   // auto-ack
+		false,   // exclusive
+		false,   // no-local
+		false,   // no-wait
+		nil,     // args
 	)
 	if err != nil {
 		return fmt.Errorf("failed to register consumer: %w", err)
 	}
 
-	forever := make(chan struct{})
+	c.mu.Lock()
+	c.ch, c.tag = ch, tag
+	c.mu.Unlock()
 
-	go func() {
-		for msg := range msgs {
-			var payload Payload
-			if err := json.Unmarshal(msg.Body, &payload); err != nil {
-				log.Printf("failed to unmarshal message: %v → body: %q", err, msg.Body)
+	// Stop may have run before the session was registered
+	select {
+	case <-c.stop:
+		ch.Cancel(tag, false)
+	default:
+	}
+
+	log.Printf("[*] Waiting for message [exchange: logs_topic, queue: %s, topics: %v, workers: %d]", c.queue, c.topics, c.workers)
+
+	c.dispatch(ch, retryCh, msgs)
+	return nil
+}
+
+// prefetch is the workers plus room for the deliveries held for each topic limit.
+func (c *Consumer) prefetch() int {
+	n := c.workers
+	for _, limit := range c.topicLimits {
+		n += limit
+	}
+	return n
+}
+
+// job is a delivery handed to a worker, with the topic limit pattern it holds a slot of.
+type job struct {
+	msg     amqp.Delivery
+	pattern string
+}
+
+// dispatch hands the deliveries of one session to the workers until msgs is closed and
+// the workers are done. A delivery only takes a worker once it holds a slot of its topic
+// limit, so a busy limited topic never leaves the workers waiting on it.
+func (c *Consumer) dispatch(ch, retryCh *amqp.Channel, msgs <-chan amqp.Delivery) {
+	jobs := make(chan job)
+	freed := make(chan string)
+
+	var wg sync.WaitGroup
+	for range c.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				c.process(retryCh, j.msg)
+				if j.pattern != "" {
+					freed <- j.pattern
+				}
+			}
+		}()
+	}
+
+	// ready holds the deliveries waiting for a worker; it never outgrows the prefetch
+	var ready []job
+	running := map[string]int{}
+	held := map[string][]amqp.Delivery{}
+	waiting := 0
+
+	// msgs is closed once Stop cancels the consumer or the channel is lost
+	for msgs != nil || len(ready) > 0 || waiting > 0 {
+		var out chan<- job
+		var next job
+		if len(ready) > 0 {
+			out, next = jobs, ready[0]
+		}
+
+		select {
+		case out <- next:
+			ready = ready[1:]
+
+		case msg, ok := <-msgs:
+			if !ok {
+				msgs = nil
+				if ch.IsClosed() {
+					// RabbitMQ requeues what was not handled yet, it cannot be acked anymore
+					ready, held, waiting = nil, nil, 0
+				}
 				continue
 			}
 
-			// handle in goroutine so one slow log doesn't block others
-			go c.handlePayload(payload)
+			pattern, limited := topicPattern(c.topicLimits, routingKey(msg))
+			switch {
+			case !limited:
+				ready = append(ready, job{msg: msg})
+			case running[pattern] < c.topicLimits[pattern]:
+				running[pattern]++
+				ready = append(ready, job{msg: msg, pattern: pattern})
+			case len(held[pattern]) < c.topicLimits[pattern]:
+				held[pattern] = append(held[pattern], msg)
+				waiting++
+			default:
+				c.deferDelivery(retryCh, msg)
+			}
+
+		case pattern := <-freed:
+			// the slot passes to the next held delivery of the topic, if there is one
+			if next := held[pattern]; len(next) > 0 {
+				held[pattern] = next[1:]
+				waiting--
+				ready = append(ready, job{msg: next[0], pattern: pattern})
+			} else {
+				running[pattern]--
+			}
+		}
+	}
+	close(jobs)
+
+	// the last reports of the workers still running
+	go func() {
+		for range freed {
 		}
 	}()
+	wg.Wait()
+	close(freed)
+}
 
-	log.Printf("[*] Waiting for message [exchange: logs_topic, queue: %s, topics: %v]", q.Name, topics)
-	<-forever
+// deferDelivery moves a delivery of a topic that is at its limit, and has as many
+// deliveries waiting, to the first retry tier. It comes back after the tier's delay with
+// its attempts unchanged.
+func (c *Consumer) deferDelivery(retryCh *amqp.Channel, msg amqp.Delivery) {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[routingKeyHeader] = routingKey(msg)
 
-	return nil
+	err := publishRetry(retryCh, c.retry.tier(c.queue, 1), republish(msg, headers))
+	if err != nil {
+		log.Println("failed to defer message:", err)
+		msg.Nack(false, true)
+		return
+	}
+	if err := msg.Ack(false); err != nil {
+		log.Println("failed to ack message:", err)
+	}
 }
 
-func (c *Consumer) handlePayload(payload Payload) {
-	switch payload.Name {
+// Stop cancels consumption and waits for Listen to finish the in-flight deliveries.
+// When ctx ends first the channel is closed, so RabbitMQ requeues whatever is still
+// unacknowledged.
+func (c *Consumer) Stop(ctx context.Context) error {
+	if err := c.cancel(); err != nil {
+		return err
+	}
 
-	case "log", "event":
-		err := c.logEvent(payload)
-		if err != nil {
-			log.Println("logger error:", err)
-		}
+	c.mu.Lock()
+	ch, done := c.ch, c.done
+	c.mu.Unlock()
 
-	case "auth":
-		// Authentication
+	if done == nil {
+		return nil
+	}
 
-	default:
-		err := c.logEvent(payload)
-		if err != nil {
-			log.Println("logger error:", err)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if ch != nil {
+			ch.Close()
 		}
+		return ctx.Err()
 	}
 }
 
-func (c *Consumer) logEvent(payload Payload) error {
-	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
-	defer cancel()
+// cancel stops the delivery of new messages; deliveries already received are still
+// handled.
+func (c *Consumer) cancel() error {
+	c.stopOnce.Do(func() { close(c.stop) })
 
-	body, err := json.Marshal(payload)
-	if err != nil {
+	c.mu.Lock()
+	ch, tag := c.ch, c.tag
+	c.mu.Unlock()
+
+	if ch == nil {
+		return nil
+	}
+	if err := ch.Cancel(tag, false); err != nil && !errors.Is(err, amqp.ErrClosed) {
 		return err
 	}
+	return nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, loggerServiceURL, bytes.NewReader(body))
+// process decodes a delivery and handles it.
+func (c *Consumer) process(retryCh *amqp.Channel, msg amqp.Delivery) {
+	ev, err := decode(msg)
 	if err != nil {
-		return err
+		log.Printf("failed to decode message: %v → body: %q", err, msg.Body)
+		// a malformed message will never succeed, dead-letter it right away
+		msg.Nack(false, false)
+		return
+	}
+
+	switch c.claim(ev) {
+	case dedupe.Processed:
+		log.Printf("skipping duplicate event %s", ev.Envelope.ID)
+		if err := msg.Ack(false); err != nil {
+			log.Println("failed to ack message:", err)
+		}
+	case dedupe.Pending:
+		// another delivery is being handled; try again once it is done or failed
+		c.fail(retryCh, msg, fmt.Errorf("event %s is being handled by another delivery", ev.Envelope.ID))
+	default:
+		c.handleDelivery(retryCh, msg, ev)
+	}
+}
+
+// handleDelivery acknowledges a message once it has been handled and marks the event
+// processed. A failed event is released, so that its retry is handled again.
+func (c *Consumer) handleDelivery(retryCh *amqp.Channel, msg amqp.Delivery, ev Event) {
+	err := c.handle(ev)
+	if err == nil {
+		c.markProcessed(ev)
+		if err := msg.Ack(false); err != nil {
+			log.Println("failed to ack message:", err)
+		}
+		return
+	}
+
+	c.release(ev)
+	c.fail(retryCh, msg, err)
+}
+
+// fail settles a delivery that was not handled. The message is moved to its delayed
+// retry tier until it used up the attempts of its topic, or went through the dead-letter
+// queue maxDeaths times; then it is rejected to the dead-letter exchange. An untrusted
+// event is rejected right away.
+func (c *Consumer) fail(retryCh *amqp.Channel, msg amqp.Delivery, err error) {
+	if errors.Is(err, ErrUntrusted) {
+		// retrying will not change where the event came from
+		log.Printf("handler error: %v, dead-lettering", err)
+		if err := msg.Nack(false, false); err != nil {
+			log.Println("failed to nack message:", err)
+		}
+		return
+	}
+
+	attempt := attempts(msg.Headers) + 1
+	limit := c.retry.maxAttempts(routingKey(msg))
+	deaths := deathCount(msg.Headers)
+
+	if attempt >= limit || deaths >= maxDeaths {
+		log.Printf("handler error: %v (attempt %d/%d, deaths: %d), dead-lettering", err, attempt, limit, deaths)
+		if err := msg.Nack(false, false); err != nil {
+			log.Println("failed to nack message:", err)
+		}
+		return
+	}
+
+	log.Printf("handler error: %v (attempt %d/%d), retrying via %s", err, attempt, limit, c.retry.tier(c.queue, attempt))
+	if err := c.scheduleRetry(retryCh, msg, attempt, err); err != nil {
+		// keep the message rather than lose it
+		log.Println("failed to schedule retry:", err)
+		msg.Nack(false, true)
+		return
+	}
+	if err := msg.Ack(false); err != nil {
+		log.Println("failed to ack message:", err)
+	}
+}
+
+func (c *Consumer) handle(ev Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handleTimeout)
+	defer cancel()
+
+	return c.handler.Handle(ctx, ev)
+}
+
+// dedupeID scopes the event ID to the consumer group, since every group processes the
+// event once.
+func (c *Consumer) dedupeID(ev Event) string {
+	return c.queue + "/" + ev.Envelope.ID
+}
+
+// claim claims the event for the group before it is handled. When the store cannot
+// tell, the event is handled; logger-service drops duplicate writes itself.
+func (c *Consumer) claim(ev Event) dedupe.Status {
+	if c.dedupe == nil {
+		return dedupe.Claimed
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
 
-	resp, err := c.httpClient.Do(req)
+	status, err := c.dedupe.Claim(ctx, c.dedupeID(ev), claimLease)
 	if err != nil {
-		return err
+		log.Println("failed to claim event:", err)
+		return dedupe.Claimed
 	}
-	defer resp.Body.Close()
+	return status
+}
 
-	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("logger service returned status %d", resp.StatusCode)
+// release drops the claim on an event that failed, so its retry is handled.
+func (c *Consumer) release(ev Event) {
+	if c.dedupe == nil {
+		return
 	}
 
-	var logResp LogResponse
-	if err := json.NewDecoder(resp.Body).Decode(&logResp); err != nil {
-		return fmt.Errorf("failed to decode logger response: %w", err)
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+
+	if err := c.dedupe.Release(ctx, c.dedupeID(ev)); err != nil {
+		log.Println("failed to release event:", err)
 	}
+}
 
-	if logResp.Error {
-		return fmt.Errorf("logger service error: %s", logResp.Message)
+func (c *Consumer) markProcessed(ev Event) {
+	if c.dedupe == nil {
+		return
 	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+
+	if err := c.dedupe.Done(ctx, c.dedupeID(ev)); err != nil {
+		log.Println("failed to mark event processed:", err)
+	}
 }
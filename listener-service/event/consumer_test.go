@@ -0,0 +1,210 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"shared/envelope"
+	"shared/rabbit"
+	"shared/rabbit/amqptest"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// acks records how the deliveries were settled.
+type acks struct {
+	mu     sync.Mutex
+	acked  int
+	nacked int
+}
+
+func (a *acks) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked++
+	return nil
+}
+
+func (a *acks) Nack(tag uint64, multiple, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked++
+	return nil
+}
+
+func (a *acks) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// delivery returns a log event as RabbitMQ would deliver it.
+func delivery(t *testing.T, ack amqp.Acknowledger, routingKey string, data envelope.LogData) amqp.Delivery {
+	t.Helper()
+
+	return eventDelivery(t, ack, routingKey, envelope.TypeLog, "broker-service", data)
+}
+
+// eventDelivery returns an event of any type as RabbitMQ would deliver it.
+func eventDelivery(t *testing.T, ack amqp.Acknowledger, routingKey, eventType, source string, data any) amqp.Delivery {
+	t.Helper()
+
+	env, err := envelope.New(eventType, source, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := env.Publishing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{
+		Acknowledger: ack,
+		Headers:      msg.Headers,
+		ContentType:  msg.ContentType,
+		MessageId:    msg.MessageId,
+		Type:         msg.Type,
+		AppId:        msg.AppId,
+		Timestamp:    msg.Timestamp,
+		RoutingKey:   routingKey,
+		Body:         msg.Body,
+	}
+}
+
+func TestDispatchTopicLimit(t *testing.T) {
+	release := make(chan struct{})
+	var hot, hotMax, cold atomic.Int32
+	coldDone := make(chan struct{}, 10)
+
+	handler := HandlerFunc(func(ctx context.Context, ev Event) error {
+		if ev.RoutingKey != "log.ERROR.hot" {
+			cold.Add(1)
+			coldDone <- struct{}{}
+			return nil
+		}
+		n := hot.Add(1)
+		for {
+			if m := hotMax.Load(); n <= m || hotMax.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+		hot.Add(-1)
+		return nil
+	})
+
+	c := &Consumer{
+		handler:     handler,
+		queue:       "logs.test",
+		retry:       DefaultRetryPolicy(),
+		workers:     2,
+		topicLimits: map[string]int{"log.ERROR.*": 1},
+	}
+	if got := c.prefetch(); got != 3 {
+		t.Errorf("prefetch = %d, want 3", got)
+	}
+
+	ack := &acks{}
+	msgs := make(chan amqp.Delivery, 10)
+	// as many hot deliveries as workers, which used to pin both of them
+	for range 2 {
+		msgs <- delivery(t, ack, "log.ERROR.hot", envelope.LogData{Name: "hot", Data: "x"})
+	}
+	for range 3 {
+		msgs <- delivery(t, ack, "log.INFO.cold", envelope.LogData{Name: "cold", Data: "x"})
+	}
+	close(msgs)
+
+	done := make(chan struct{})
+	go func() {
+		c.dispatch(&amqp.Channel{}, nil, msgs)
+		close(done)
+	}()
+
+	// the cold topic is handled while the hot one is busy
+	for i := range 3 {
+		select {
+		case <-coldDone:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d cold deliveries were handled while the hot topic was busy", i)
+		}
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatch did not return after the deliveries were handled")
+	}
+
+	if m := hotMax.Load(); m != 1 {
+		t.Errorf("up to %d hot deliveries ran at once, want 1", m)
+	}
+	if ack.acked != 5 || ack.nacked != 0 {
+		t.Errorf("acked %d and nacked %d deliveries, want 5 acked", ack.acked, ack.nacked)
+	}
+}
+
+// retryChannel returns a channel in confirm mode to a fake server on which the retry
+// tiers of queue are declared.
+func retryChannel(t *testing.T, queue string, policy RetryPolicy) (*amqptest.Server, *amqp.Channel) {
+	t.Helper()
+
+	srv, err := amqptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	manager := rabbit.NewManager(srv.URL())
+	t.Cleanup(func() { manager.Close() })
+	manager.Declare(func(ch *amqp.Channel) error {
+		return declareRetryTiers(ch, queue, policy.Delays)
+	})
+	manager.Start()
+	select {
+	case <-manager.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the manager did not connect")
+	}
+
+	ch, err := manager.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ch.Close() })
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	return srv, ch
+}
+
+func TestFailSchedulesRetry(t *testing.T) {
+	c := &Consumer{queue: "logs.test", retry: DefaultRetryPolicy()}
+	srv, ch := retryChannel(t, c.queue, c.retry)
+
+	ack := &acks{}
+	c.fail(ch, delivery(t, ack, "log.INFO.auth", envelope.LogData{Name: "a", Data: "x"}), errors.New("failed on purpose"))
+
+	if n := srv.Messages("logs.test.retry.1s"); n != 1 {
+		t.Errorf("the first retry tier holds %d messages, want 1", n)
+	}
+	if ack.acked != 1 || ack.nacked != 0 {
+		t.Errorf("acked %d and nacked %d deliveries, want 1 acked", ack.acked, ack.nacked)
+	}
+}
+
+func TestFailKeepsUnconfirmedRetry(t *testing.T) {
+	c := &Consumer{queue: "logs.test", retry: DefaultRetryPolicy()}
+	srv, ch := retryChannel(t, c.queue, c.retry)
+	srv.Stop()
+
+	ack := &acks{}
+	c.fail(ch, delivery(t, ack, "log.INFO.auth", envelope.LogData{Name: "a", Data: "x"}), errors.New("failed on purpose"))
+
+	// the copy was never confirmed, so the delivery goes back to the queue
+	if ack.acked != 0 || ack.nacked != 1 {
+		t.Errorf("acked %d and nacked %d deliveries, want 1 nacked", ack.acked, ack.nacked)
+	}
+}
@@ -0,0 +1,269 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	deadLetterExchange = "logs_dlx"
+	deadLetterQueue    = "logs_dead_letter"
+)
+
+// replayTimeout bounds how long Replay waits for RabbitMQ to confirm one message.
+const replayTimeout = 10 * time.Second
+
+// maxDeaths is how many times a message may be dead-lettered before it is no longer
+// retried: a replayed message that fails after that goes straight back to the
+// dead-letter queue, and replay skips it unless forced.
+const maxDeaths = 3
+
+// declareDeadLetter declares the dead-letter exchange and the durable queue collecting
+// every message the consumer rejects. The exchange is a topic exchange bound with "#",
+// so dead-lettered messages keep their original routing key.
+func declareDeadLetter(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(
+		deadLetterExchange,
+		"topic",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ch.QueueDeclare(
+		deadLetterQueue,
+		true,  // durable
+		false, // auto delete?
+		false, // exclusive?
+		false, // no wait?
+		nil,   // arguments
+	); err != nil {
+		return err
+	}
+
+	return ch.QueueBind(deadLetterQueue, "#", deadLetterExchange, false, nil)
+}
+
+// deathCount returns how many times the message has been rejected to the dead-letter
+// exchange, according to the x-death header RabbitMQ maintains. Expiries in the retry
+// tiers are recorded there too and are not counted.
+func deathCount(headers amqp.Table) int64 {
+	deaths, _ := headers["x-death"].([]any)
+
+	var count int64
+	for _, d := range deaths {
+		death, ok := d.(amqp.Table)
+		if !ok || death["reason"] != "rejected" {
+			continue
+		}
+		if n, ok := death["count"].(int64); ok {
+			count += n
+		}
+	}
+	return count
+}
+
+// lastDeath returns the reason and queue of the most recent rejection in the x-death
+// header, which RabbitMQ keeps sorted newest first.
+func lastDeath(headers amqp.Table) (reason, queue string, at time.Time) {
+	deaths, _ := headers["x-death"].([]any)
+	for _, d := range deaths {
+		death, _ := d.(amqp.Table)
+		if death["reason"] != "rejected" {
+			continue
+		}
+		reason, _ = death["reason"].(string)
+		queue, _ = death["queue"].(string)
+		at, _ = death["time"].(time.Time)
+		return reason, queue, at
+	}
+	return "", "", time.Time{}
+}
+
+// DeadLetter is a message sitting in the dead-letter queue.
+type DeadLetter struct {
+	RoutingKey string    `json:"routing_key"`
+	Body       string    `json:"body"`
+	Deaths     int64     `json:"deaths"`
+	Reason     string    `json:"reason"`
+	Queue      string    `json:"queue"`
+	DiedAt     time.Time `json:"died_at"`
+}
+
+func newDeadLetter(msg amqp.Delivery) DeadLetter {
+	reason, queue, at := lastDeath(msg.Headers)
+	return DeadLetter{
+		RoutingKey: routingKey(msg),
+		Body:       string(msg.Body),
+		Deaths:     deathCount(msg.Headers),
+		Reason:     reason,
+		Queue:      queue,
+		DiedAt:     at,
+	}
+}
+
+// DeadLetters gives access to the dead-letter queue for inspection and recovery.
+type DeadLetters struct {
+	conn *amqp.Connection
+}
+
+func NewDeadLetters(conn *amqp.Connection) (*DeadLetters, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	if err := declareExchange(ch); err != nil {
+		return nil, err
+	}
+	if err := declareDeadLetter(ch); err != nil {
+		return nil, err
+	}
+
+	return &DeadLetters{conn: conn}, nil
+}
+
+// Inspect returns up to limit dead-lettered messages without removing them. The
+// messages are fetched unacknowledged and requeued when the channel closes.
+func (d *DeadLetters) Inspect(limit int) ([]DeadLetter, error) {
+	ch, err := d.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	letters := []DeadLetter{}
+	for len(letters) < limit {
+		msg, ok, err := ch.Get(deadLetterQueue, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, newDeadLetter(msg))
+	}
+
+	return letters, nil
+}
+
+// Replay republishes up to limit dead-lettered messages, with their headers, straight to
+// the consumer group queue they were rejected from, so they are consumed again with a
+// fresh set of retry attempts and no other group sees them twice. Messages whose queue
+// is unknown go back to logs_topic with their original routing key. Messages that
+// already died maxDeaths times are left in the queue unless force is set. It returns the
+// number of replayed and skipped messages.
+//
+// A message is only removed from the dead-letter queue once RabbitMQ confirmed its
+// persistent copy was routed, so a failure can leave a message in both queues but never
+// lose it.
+func (d *DeadLetters) Replay(limit int, force bool) (replayed, skipped int, err error) {
+	ch, err := d.conn.Channel()
+	if err != nil {
+		return 0, 0, err
+	}
+	// skipped messages stay unacknowledged until here, so they are not fetched twice
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return 0, 0, err
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	for replayed+skipped < limit {
+		msg, ok, err := ch.Get(deadLetterQueue, false)
+		if err != nil {
+			return replayed, skipped, err
+		}
+		if !ok {
+			break
+		}
+
+		if !force && deathCount(msg.Headers) >= maxDeaths {
+			skipped++
+			continue
+		}
+
+		exchange, key := "logs_topic", routingKey(msg)
+		if _, queue, _ := lastDeath(msg.Headers); queue != "" {
+			exchange, key = "", queue
+		}
+
+		if err := replayOne(ch, returns, exchange, key, msg); err != nil {
+			// back to the dead-letter queue, where it is fetched again by the next replay
+			msg.Nack(false, true)
+			return replayed, skipped, fmt.Errorf("replaying message: %w", err)
+		}
+		if err := msg.Ack(false); err != nil {
+			return replayed, skipped, err
+		}
+		replayed++
+	}
+
+	return replayed, skipped, nil
+}
+
+// replayOne publishes the copy of a dead-lettered message and waits for its
+// confirmation. It is mandatory, so a copy that no queue took is reported rather than
+// dropped.
+func replayOne(ch *amqp.Channel, returns <-chan amqp.Return, exchange, key string, msg amqp.Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+
+	publishing := republish(msg, replayHeaders(msg))
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, publishing)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a return arrives before the confirmation of the same message
+	select {
+	case ret := <-returns:
+		return fmt.Errorf("%s is unroutable: %s", key, ret.ReplyText)
+	default:
+	}
+	if !acked {
+		return errors.New("RabbitMQ refused the message")
+	}
+	return nil
+}
+
+// replayHeaders resets the attempt counter of a replayed message so it gets a full set
+// of retries again. Its attempt history and x-death are kept, and its original routing
+// key is recorded since it is replayed by queue name.
+func replayHeaders(msg amqp.Delivery) amqp.Table {
+	next := amqp.Table{}
+	for k, v := range msg.Headers {
+		if k != attemptsHeader {
+			next[k] = v
+		}
+	}
+	next[routingKeyHeader] = routingKey(msg)
+	return next
+}
+
+// Purge deletes every dead-lettered message and returns how many there were.
+func (d *DeadLetters) Purge() (int, error) {
+	ch, err := d.conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	return ch.QueuePurge(deadLetterQueue, false)
+}
@@ -0,0 +1,134 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	diedAt  = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	retryAt = diedAt.Add(-time.Minute)
+)
+
+// deaths builds an x-death header as RabbitMQ sets it, newest first.
+func deaths(entries ...amqp.Table) amqp.Table {
+	xdeath := make([]any, len(entries))
+	for i, e := range entries {
+		xdeath[i] = e
+	}
+	return amqp.Table{"x-death": xdeath}
+}
+
+func rejected(queue string, count int64, at time.Time) amqp.Table {
+	return amqp.Table{"reason": "rejected", "queue": queue, "count": count, "time": at, "exchange": ""}
+}
+
+func expired(queue string, count int64, at time.Time) amqp.Table {
+	return amqp.Table{"reason": "expired", "queue": queue, "count": count, "time": at, "exchange": ""}
+}
+
+func TestDeathCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int64
+	}{
+		{"no headers", nil, 0},
+		{"no x-death", amqp.Table{"x-attempts": int32(2)}, 0},
+		{"once", deaths(rejected("logs.listener", 1, diedAt)), 1},
+		{"replayed and rejected again", deaths(rejected("logs.listener", 2, diedAt)), 2},
+		{"rejected from two groups", deaths(rejected("logs.audit", 1, diedAt), rejected("logs.listener", 2, retryAt)), 3},
+		// the expiries of the retry tiers are not failures
+		{"retry tiers", deaths(expired("logs.listener.retry.10s", 1, retryAt), expired("logs.listener.retry.1s", 1, retryAt)), 0},
+		{"rejected after retries", deaths(rejected("logs.listener", 1, diedAt), expired("logs.listener.retry.1s", 4, retryAt)), 1},
+		{"malformed entries", amqp.Table{"x-death": []any{"rejected", amqp.Table{"reason": "rejected", "count": int32(1)}}}, 0},
+		{"malformed header", amqp.Table{"x-death": "rejected"}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := deathCount(tt.headers); got != tt.want {
+			t.Errorf("%s: deathCount = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLastDeath(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    amqp.Table
+		wantReason string
+		wantQueue  string
+		wantAt     time.Time
+	}{
+		{"no x-death", nil, "", "", time.Time{}},
+		{"once", deaths(rejected("logs.listener", 1, diedAt)), "rejected", "logs.listener", diedAt},
+		{"newest first", deaths(rejected("logs.audit", 1, diedAt), rejected("logs.listener", 1, retryAt)), "rejected", "logs.audit", diedAt},
+		{"skips retry tiers", deaths(expired("logs.listener.retry.1s", 1, diedAt), rejected("logs.listener", 1, retryAt)), "rejected", "logs.listener", retryAt},
+		{"only retry tiers", deaths(expired("logs.listener.retry.1s", 1, diedAt)), "", "", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		reason, queue, at := lastDeath(tt.headers)
+		if reason != tt.wantReason || queue != tt.wantQueue || !at.Equal(tt.wantAt) {
+			t.Errorf("%s: lastDeath = %q, %q, %v, want %q, %q, %v", tt.name, reason, queue, at, tt.wantReason, tt.wantQueue, tt.wantAt)
+		}
+	}
+}
+
+func TestReplayHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		routingKey string
+		headers    amqp.Table
+		wantKey    string
+	}{
+		{
+			name:       "first failure",
+			routingKey: "log.ERROR.auth",
+			headers:    amqp.Table{"x-attempts": int32(4)},
+			wantKey:    "log.ERROR.auth",
+		},
+		{
+			// a retried message comes back with its queue as routing key
+			name:       "after retries",
+			routingKey: "logs.listener",
+			headers:    amqp.Table{"x-attempts": int64(4), routingKeyHeader: "log.ERROR.auth"},
+			wantKey:    "log.ERROR.auth",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := deaths(rejected("logs.listener", 1, diedAt))
+			for k, v := range tt.headers {
+				headers[k] = v
+			}
+			headers[historyHeader] = []any{amqp.Table{"attempt": int32(1)}}
+			msg := amqp.Delivery{RoutingKey: tt.routingKey, Headers: headers}
+
+			got := replayHeaders(msg)
+			if _, ok := got[attemptsHeader]; ok {
+				t.Errorf("%s = %v, want it reset", attemptsHeader, got[attemptsHeader])
+			}
+			if attempts(got) != 0 {
+				t.Errorf("attempts = %d, want 0", attempts(got))
+			}
+			if got[routingKeyHeader] != tt.wantKey {
+				t.Errorf("%s = %v, want %s", routingKeyHeader, got[routingKeyHeader], tt.wantKey)
+			}
+			// the history and deaths are kept, so the replay is still bounded by maxDeaths
+			if deathCount(got) != 1 {
+				t.Errorf("deathCount = %d, want 1", deathCount(got))
+			}
+			if history, _ := got[historyHeader].([]any); len(history) != 1 {
+				t.Errorf("%s = %v, want it kept", historyHeader, got[historyHeader])
+			}
+			// the delivery itself is left alone
+			if _, ok := msg.Headers[attemptsHeader]; !ok {
+				t.Error("replayHeaders changed the delivery's headers")
+			}
+		})
+	}
+}
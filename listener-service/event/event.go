@@ -1,6 +1,8 @@
 package event
 
 import (
+	"fmt"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -16,13 +18,43 @@ func declareExchange(ch *amqp.Channel) error {
 	)
 }
 
-func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
-	return ch.QueueDeclare(
-		"",    //random name
-		true,  //durable
+// groupQueue names the queue shared by the consumers of a group.
+func groupQueue(group string) string {
+	return "logs." + group
+}
+
+// declareGroupQueue declares the durable queue of a consumer group and binds it to the
+// topics. Every listener of the group consumes from it, so they compete for messages,
+// and messages published while all of them are down wait in the queue. Rejected
+// messages are routed to the dead-letter exchange.
+//
+// Bindings are only ever added: a topic removed from the configuration stays bound
+// until it is unbound by hand.
+func declareGroupQueue(ch *amqp.Channel, queue string, topics []string) error {
+	_, err := ch.QueueDeclare(
+		queue,
+		true,  // durable
 		false, // auto delete?
-		true,  //exclusive?
+		false, // exclusive?
 		false, // no wait?
-		nil,   //arguments
+		amqp.Table{
+			"x-dead-letter-exchange": deadLetterExchange,
+		},
 	)
+	if err != nil {
+		return fmt.Errorf("declaring queue %s: %w", queue, err)
+	}
+
+	for _, topic := range topics {
+		if err = ch.QueueBind(
+			queue,        // queue
+			topic,        // routing key = topic
+			"logs_topic", // exchange
+			false,
+			nil,
+		); err != nil {
+			return fmt.Errorf("failed to bind queue to topic %s: %w", topic, err)
+		}
+	}
+	return nil
 }
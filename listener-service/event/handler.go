@@ -0,0 +1,160 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"shared/envelope"
+	"slices"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrNoHandler is returned for events no handler is registered for.
+	ErrNoHandler = errors.New("no handler for event")
+	// ErrUntrusted is returned for events of a restricted type from a source that may not
+	// publish it. Such an event is never retried.
+	ErrUntrusted = errors.New("event from an untrusted source")
+)
+
+// Event is a decoded delivery.
+type Event struct {
+	RoutingKey string
+	Envelope   envelope.Envelope
+	// Payload is the data of a "log" event, or else the event type and its JSON data.
+	Payload Payload
+	Headers amqp.Table
+}
+
+// decode reads the envelope of a delivery.
+func decode(msg amqp.Delivery) (Event, error) {
+	env, err := envelope.Decode(msg)
+	if err != nil {
+		return Event{}, err
+	}
+
+	ev := Event{
+		RoutingKey: routingKey(msg),
+		Envelope:   env,
+		Payload:    Payload{Name: env.Type, Data: string(env.Data)},
+		Headers:    msg.Headers,
+	}
+	if env.Type == envelope.TypeLog {
+		var data envelope.LogData
+		if err := json.Unmarshal(env.Data, &data); err != nil {
+			return Event{}, err
+		}
+		ev.Payload = Payload{Name: data.Name, Data: data.Data, Severity: data.Severity}
+	}
+	ev.Payload.IdempotencyKey = env.ID
+	return ev, nil
+}
+
+// EventHandler handles one kind of event. A returned error makes the consumer retry the
+// delivery.
+type EventHandler interface {
+	Handle(ctx context.Context, ev Event) error
+}
+
+// HandlerFunc adapts a function to EventHandler.
+type HandlerFunc func(ctx context.Context, ev Event) error
+
+func (f HandlerFunc) Handle(ctx context.Context, ev Event) error {
+	return f(ctx, ev)
+}
+
+// Middleware wraps a handler, e.g. to log, measure or recover.
+type Middleware func(name string, next EventHandler) EventHandler
+
+type topicRoute struct {
+	pattern string
+	handler EventHandler
+}
+
+// Registry dispatches events to the handler registered for the envelope type, else to
+// the first handler whose routing-key pattern matches, else to the fallback. The data of
+// an event, such as the name of a log event, never selects the handler: it comes from
+// the client that asked the broker to publish the event.
+type Registry struct {
+	types      map[string]EventHandler
+	sources    map[string][]string
+	topics     []topicRoute
+	fallback   EventHandler
+	middleware []Middleware
+}
+
+func NewRegistry() *Registry {
+	return &Registry{types: map[string]EventHandler{}, sources: map[string][]string{}}
+}
+
+// Register registers h for events whose envelope type is one of types.
+func (r *Registry) Register(h EventHandler, types ...string) {
+	for _, eventType := range types {
+		r.types[eventType] = h
+	}
+}
+
+// Trust restricts the events of a type to the given sources; events of the type from any
+// other source fail with ErrUntrusted. The source is set by the producer, so this holds
+// as long as only internal services can publish to RabbitMQ; clients reach it through
+// the broker, which publishes their events as "log" events.
+func (r *Registry) Trust(eventType string, sources ...string) {
+	r.sources[eventType] = append(r.sources[eventType], sources...)
+}
+
+// RegisterTopic registers h for routing keys matching pattern, using path.Match
+// wildcards such as "auth.*". Patterns are tried in the order they were registered.
+func (r *Registry) RegisterTopic(pattern string, h EventHandler) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid topic pattern %q: %w", pattern, err)
+	}
+	r.topics = append(r.topics, topicRoute{pattern: pattern, handler: h})
+	return nil
+}
+
+// Fallback registers h for events no other handler matches.
+func (r *Registry) Fallback(h EventHandler) {
+	r.fallback = h
+}
+
+// Use wraps every handler in the middleware; the first one added is the outermost.
+func (r *Registry) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Handle dispatches the event through the middleware to its handler.
+func (r *Registry) Handle(ctx context.Context, ev Event) error {
+	env := ev.Envelope
+	if sources, ok := r.sources[env.Type]; ok && !slices.Contains(sources, env.Source) {
+		return fmt.Errorf("%w: %q event %s from %q", ErrUntrusted, env.Type, env.ID, env.Source)
+	}
+
+	name, h := r.lookup(ev)
+	if h == nil {
+		return fmt.Errorf("%w %q (topic %s)", ErrNoHandler, env.Type, ev.RoutingKey)
+	}
+
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](name, h)
+	}
+	return h.Handle(ctx, ev)
+}
+
+// lookup returns the handler for ev and the name it was registered under.
+func (r *Registry) lookup(ev Event) (string, EventHandler) {
+	if h, ok := r.types[ev.Envelope.Type]; ok {
+		return ev.Envelope.Type, h
+	}
+	for _, route := range r.topics {
+		if ok, _ := path.Match(route.pattern, ev.RoutingKey); ok {
+			return route.pattern, route.handler
+		}
+	}
+	if r.fallback != nil {
+		return "fallback", r.fallback
+	}
+	return "", nil
+}
@@ -0,0 +1,229 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"shared/envelope"
+)
+
+// recorder is a LogWriter and MailSender that records what it was asked to do.
+type recorder struct {
+	mu    sync.Mutex
+	logs  []Payload
+	mails []Mail
+}
+
+func (r *recorder) WriteLog(ctx context.Context, payload Payload) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, payload)
+	return nil
+}
+
+func (r *recorder) SendMail(ctx context.Context, mail Mail) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mails = append(r.mails, mail)
+	return nil
+}
+
+// named returns a handler that records the name of the handler each event went to.
+func named(name string, got *[]string) EventHandler {
+	return HandlerFunc(func(ctx context.Context, ev Event) error {
+		*got = append(*got, name)
+		return nil
+	})
+}
+
+func TestRegistryDispatch(t *testing.T) {
+	var got []string
+	r := NewRegistry()
+	r.Register(named("log", &got), envelope.TypeLog)
+	r.Register(named("mail", &got), envelope.TypeMail)
+	r.Register(named("auth", &got), envelope.TypeAuth)
+	if err := r.RegisterTopic("audit.*", named("audit", &got)); err != nil {
+		t.Fatal(err)
+	}
+	r.Fallback(named("fallback", &got))
+
+	mail := envelope.MailData{To: "jane@example.com", Template: "security.html"}
+	tests := []struct {
+		name    string
+		key     string
+		typ     string
+		source  string
+		data    any
+		handler string
+	}{
+		// the names of log events are chosen by clients of the broker
+		{"log named mail", "log.INFO.mail", envelope.TypeLog, "broker-service", envelope.LogData{Name: "mail", Data: `{"to":"x@example.com"}`}, "log"},
+		{"log named auth", "log.INFO.auth", envelope.TypeLog, "broker-service", envelope.LogData{Name: "auth", Data: "x"}, "log"},
+		{"mail", "mail.send", envelope.TypeMail, "auth-service", mail, "mail"},
+		{"auth", "auth.login", envelope.TypeAuth, "auth-service", envelope.AuthData{Type: AuthLogin, Email: "jane@example.com"}, "auth"},
+		{"topic", "audit.user", "audit", "auth-service", map[string]string{"user": "jane"}, "audit"},
+		{"fallback", "other", "other", "auth-service", map[string]string{}, "fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			ev, err := decode(eventDelivery(t, nil, tt.key, tt.typ, tt.source, tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Handle(context.Background(), ev); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != tt.handler {
+				t.Errorf("handled by %v, want %s", got, tt.handler)
+			}
+		})
+	}
+}
+
+func TestRegistryNoHandler(t *testing.T) {
+	r := NewRegistry()
+	ev, err := decode(delivery(t, nil, "log.INFO.x", envelope.LogData{Name: "x", Data: "x"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Handle(context.Background(), ev); !errors.Is(err, ErrNoHandler) {
+		t.Errorf("err = %v, want %v", err, ErrNoHandler)
+	}
+}
+
+func TestRegistryTrust(t *testing.T) {
+	var got []string
+	r := NewRegistry()
+	r.Register(named("mail", &got), envelope.TypeMail)
+	r.Trust(envelope.TypeMail, "auth-service")
+
+	mail := envelope.MailData{To: "jane@example.com", Template: "security.html"}
+	for _, source := range []string{"broker-service", "unknown", "auth-service-2"} {
+		ev, err := decode(eventDelivery(t, nil, "mail.send", envelope.TypeMail, source, mail))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Handle(context.Background(), ev); !errors.Is(err, ErrUntrusted) {
+			t.Errorf("event from %s: err = %v, want %v", source, err, ErrUntrusted)
+		}
+	}
+	if len(got) != 0 {
+		t.Fatalf("untrusted events were handled by %v", got)
+	}
+
+	ev, err := decode(eventDelivery(t, nil, "mail.send", envelope.TypeMail, "auth-service", mail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Handle(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("the trusted event was handled by %v", got)
+	}
+}
+
+func TestConsumerDeadLettersUntrusted(t *testing.T) {
+	sent := &recorder{}
+	r := NewRegistry()
+	r.Register(NewMailHandler(sent), envelope.TypeMail)
+	r.Trust(envelope.TypeMail, "auth-service")
+
+	c := &Consumer{handler: r, queue: "logs.test", retry: DefaultRetryPolicy()}
+	ack := &acks{}
+	mail := envelope.MailData{To: "jane@example.com", Template: "security.html"}
+	// a nil retry channel: the event must not be scheduled for a retry
+	c.process(nil, eventDelivery(t, ack, "mail.send", envelope.TypeMail, "broker-service", mail))
+
+	if ack.acked != 0 || ack.nacked != 1 {
+		t.Errorf("acked %d and nacked %d deliveries, want 1 nacked", ack.acked, ack.nacked)
+	}
+	if len(sent.mails) != 0 {
+		t.Errorf("sent %d mails", len(sent.mails))
+	}
+}
+
+func TestAuthHandler(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		typ     string
+		subject string
+	}{
+		{AuthLogin, ""},
+		{AuthLoginFailed, "Failed sign-in attempt"},
+		{AuthPasswordChanged, "Your password was changed"},
+		{AuthAccountLocked, "Your account was locked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			rec := &recorder{}
+			h := NewAuthHandler(rec, rec)
+
+			data := envelope.AuthData{Type: tt.typ, Email: "jane@example.com", IP: "10.0.0.1", At: at}
+			ev, err := decode(eventDelivery(t, nil, "auth."+tt.typ, envelope.TypeAuth, "auth-service", data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := h.Handle(context.Background(), ev); err != nil {
+				t.Fatal(err)
+			}
+
+			want := tt.typ + " jane@example.com from 10.0.0.1"
+			if len(rec.logs) != 1 || rec.logs[0].Name != "auth" || rec.logs[0].Data != want {
+				t.Errorf("logs = %+v, want %q", rec.logs, want)
+			}
+			if rec.logs[0].IdempotencyKey != ev.Envelope.ID {
+				t.Errorf("idempotency key = %q, want the event ID", rec.logs[0].IdempotencyKey)
+			}
+
+			if tt.subject == "" {
+				if len(rec.mails) != 0 {
+					t.Errorf("sent %+v", rec.mails)
+				}
+				return
+			}
+			if len(rec.mails) != 1 {
+				t.Fatalf("sent %d mails, want 1", len(rec.mails))
+			}
+			mail := rec.mails[0]
+			if mail.To != "jane@example.com" || mail.Subject != tt.subject || mail.Template != "security.html" {
+				t.Errorf("mail = %+v", mail)
+			}
+			if mail.Data["At"] != at.Format(time.RFC1123) {
+				t.Errorf("mail time = %v", mail.Data["At"])
+			}
+		})
+	}
+}
+
+func TestMailHandler(t *testing.T) {
+	rec := &recorder{}
+	h := NewMailHandler(rec)
+
+	mail := envelope.MailData{To: "jane@example.com", Subject: "Hi", Template: "welcome.html", Data: map[string]any{"Name": "Jane"}}
+	ev, err := decode(eventDelivery(t, nil, "mail.send", envelope.TypeMail, "auth-service", mail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Handle(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.mails) != 1 || rec.mails[0].To != mail.To || rec.mails[0].Data["Name"] != "Jane" {
+		t.Errorf("sent %+v", rec.mails)
+	}
+
+	// malformed data is dropped, not retried
+	ev.Payload.Data = "not json"
+	if err := h.Handle(context.Background(), ev); err != nil {
+		t.Errorf("malformed mail: err = %v", err)
+	}
+	if len(rec.mails) != 1 {
+		t.Errorf("sent %d mails, want 1", len(rec.mails))
+	}
+}
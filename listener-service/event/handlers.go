@@ -0,0 +1,119 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"shared/envelope"
+	"strings"
+	"time"
+)
+
+// LogHandler forwards events to logger-service.
+type LogHandler struct {
+	logs LogWriter
+}
+
+func NewLogHandler(logs LogWriter) *LogHandler {
+	return &LogHandler{logs: logs}
+}
+
+func (h *LogHandler) Handle(ctx context.Context, ev Event) error {
+	return h.logs.WriteLog(ctx, ev.Payload)
+}
+
+// MailHandler dispatches "mail" events, whose data is a JSON encoded Mail.
+type MailHandler struct {
+	mail MailSender
+}
+
+func NewMailHandler(mail MailSender) *MailHandler {
+	return &MailHandler{mail: mail}
+}
+
+func (h *MailHandler) Handle(ctx context.Context, ev Event) error {
+	var mail Mail
+	if err := json.Unmarshal([]byte(ev.Payload.Data), &mail); err != nil {
+		// a malformed message never succeeds, so it is dropped rather than retried
+		log.Printf("dropping malformed mail event: %v", err)
+		return nil
+	}
+	if mail.To == "" || mail.Template == "" {
+		log.Printf("dropping mail event without recipient or template")
+		return nil
+	}
+
+	return h.mail.SendMail(ctx, mail)
+}
+
+// Auth event types.
+const (
+	AuthLogin           = "login"
+	AuthLoginFailed     = "login_failed"
+	AuthPasswordChanged = "password_changed"
+	AuthAccountLocked   = "account_locked"
+)
+
+// AuthEvent is the data of an "auth" event.
+type AuthEvent = envelope.AuthData
+
+// securityEvents are the auth events the user is notified about, with their subject.
+var securityEvents = map[string]string{
+	AuthLoginFailed:     "Failed sign-in attempt",
+	AuthPasswordChanged: "Your password was changed",
+	AuthAccountLocked:   "Your account was locked",
+}
+
+// AuthHandler handles "auth" events: every event is logged, and security relevant events
+// notify the user by mail.
+type AuthHandler struct {
+	logs LogWriter
+	mail MailSender
+}
+
+func NewAuthHandler(logs LogWriter, mail MailSender) *AuthHandler {
+	return &AuthHandler{logs: logs, mail: mail}
+}
+
+func (h *AuthHandler) Handle(ctx context.Context, ev Event) error {
+	var auth AuthEvent
+	if err := json.Unmarshal([]byte(ev.Payload.Data), &auth); err != nil || auth.Type == "" || auth.Email == "" {
+		// not a structured auth event; keep it in the logs at least
+		return h.logs.WriteLog(ctx, ev.Payload)
+	}
+	if auth.At.IsZero() {
+		auth.At = time.Now()
+	}
+
+	if err := h.logs.WriteLog(ctx, Payload{Name: "auth", Data: authSummary(auth), IdempotencyKey: ev.Payload.IdempotencyKey}); err != nil {
+		return err
+	}
+
+	subject, ok := securityEvents[auth.Type]
+	if !ok {
+		return nil
+	}
+	return h.mail.SendMail(ctx, Mail{
+		To:       auth.Email,
+		Subject:  subject,
+		Template: "security.html",
+		Data: map[string]any{
+			"Subject":   subject,
+			"Event":     auth.Type,
+			"IP":        auth.IP,
+			"UserAgent": auth.UserAgent,
+			"At":        auth.At.UTC().Format(time.RFC1123),
+		},
+	})
+}
+
+// authSummary describes an auth event for the logs.
+func authSummary(a AuthEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", a.Type, a.Email)
+	if a.IP != "" {
+		fmt.Fprintf(&b, " from %s", a.IP)
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"listener/logs"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// LogWriter forwards a log payload to logger-service.
+type LogWriter interface {
+	WriteLog(ctx context.Context, payload Payload) error
+}
+
+type LogResponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+}
+
+// HTTPLogWriter posts payloads to logger-service's /log endpoint.
+type HTTPLogWriter struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewHTTPLogWriter(url string) *HTTPLogWriter {
+	return &HTTPLogWriter{
+		url:        url,
+		httpClient: newHTTPClient(),
+	}
+}
+
+// newHTTPClient returns the client the listener uses to call other services.
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: httpTimeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 20,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// postJSON posts body to url and fails on any status but 200, 201 and 202.
+func postJSON(ctx context.Context, client *http.Client, url string, body any) error {
+	j, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+		return nil
+	}
+	return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+}
+
+func (h *HTTPLogWriter) WriteLog(ctx context.Context, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if payload.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", payload.IdempotencyKey)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("logger service returned status %d", resp.StatusCode)
+	}
+
+	var logResp LogResponse
+	if err := json.NewDecoder(resp.Body).Decode(&logResp); err != nil {
+		return fmt.Errorf("failed to decode logger response: %w", err)
+	}
+
+	if logResp.Error {
+		return fmt.Errorf("logger service error: %s", logResp.Message)
+	}
+
+	return nil
+}
+
+// GRPCLogWriter sends payloads through logger-service's gRPC LogService.
+type GRPCLogWriter struct {
+	conn   *grpc.ClientConn
+	client logs.LogServiceClient
+}
+
+func NewGRPCLogWriter(addr string) (*GRPCLogWriter, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCLogWriter{
+		conn:   conn,
+		client: logs.NewLogServiceClient(conn),
+	}, nil
+}
+
+func (g *GRPCLogWriter) WriteLog(ctx context.Context, payload Payload) error {
+	_, err := g.client.Write(ctx, &logs.WriteRequest{
+		Name:           payload.Name,
+		Data:           payload.Data,
+		Severity:       payload.Severity,
+		IdempotencyKey: payload.IdempotencyKey,
+	})
+	return err
+}
+
+func (g *GRPCLogWriter) Close() error {
+	return g.conn.Close()
+}
@@ -0,0 +1,93 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Logging logs every failed event with the handler it was dispatched to.
+func Logging(name string, next EventHandler) EventHandler {
+	return HandlerFunc(func(ctx context.Context, ev Event) error {
+		err := next.Handle(ctx, ev)
+		if err != nil {
+			log.Printf("handler %s: event %q (topic %s) failed: %v", name, ev.Payload.Name, ev.RoutingKey, err)
+		}
+		return err
+	})
+}
+
+// Recovery turns a panicking handler into a failed event, so the delivery is retried
+// instead of taking the listener down.
+func Recovery(name string, next EventHandler) EventHandler {
+	return HandlerFunc(func(ctx context.Context, ev Event) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("handler %s panicked: %v\n%s", name, p, debug.Stack())
+				err = fmt.Errorf("handler %s panicked: %v", name, p)
+			}
+		}()
+		return next.Handle(ctx, ev)
+	})
+}
+
+// HandlerStats counts the events a handler processed.
+type HandlerStats struct {
+	Handled int64         `json:"handled"`
+	Failed  int64         `json:"failed"`
+	Total   time.Duration `json:"total_ns"`
+	Max     time.Duration `json:"max_ns"`
+}
+
+// Metrics collects HandlerStats per handler. It is safe for concurrent use.
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[string]*HandlerStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{stats: map[string]*HandlerStats{}}
+}
+
+// Middleware records the outcome and duration of every event.
+func (m *Metrics) Middleware(name string, next EventHandler) EventHandler {
+	return HandlerFunc(func(ctx context.Context, ev Event) error {
+		start := time.Now()
+		err := next.Handle(ctx, ev)
+		m.record(name, time.Since(start), err)
+		return err
+	})
+}
+
+func (m *Metrics) record(name string, took time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.stats[name]
+	if !ok {
+		stats = &HandlerStats{}
+		m.stats[name] = stats
+	}
+
+	stats.Handled++
+	if err != nil {
+		stats.Failed++
+	}
+	stats.Total += took
+	stats.Max = max(stats.Max, took)
+}
+
+// Snapshot returns a copy of the stats per handler.
+func (m *Metrics) Snapshot() map[string]HandlerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]HandlerStats, len(m.stats))
+	for name, stats := range m.stats {
+		snapshot[name] = *stats
+	}
+	return snapshot
+}
@@ -0,0 +1,272 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers carrying the retry state of a message.
+const (
+	attemptsHeader = "x-attempts"
+	historyHeader  = "x-attempt-history"
+	// routingKeyHeader keeps the routing key a message was published with, since a
+	// retried message comes back with its queue's name as routing key.
+	routingKeyHeader = "x-routing-key"
+)
+
+// maxHistory bounds how many failed attempts are kept in the history header.
+const maxHistory = 10
+
+// RetryPolicy decides how failed deliveries are retried. A failed message is published
+// to the retry tier for its attempt (the last tier once they run out); the tier queue
+// holds it for its delay and then dead-letters it back to the consumer group's queue, so
+// other groups never see the retry. After MaxAttempts the message goes to the
+// dead-letter queue.
+type RetryPolicy struct {
+	// Delays are the retry tiers, one queue each.
+	Delays []time.Duration
+	// MaxAttempts is the default number of deliveries before a message is dead-lettered.
+	MaxAttempts int
+	// TopicAttempts overrides MaxAttempts per routing key; keys may use path.Match
+	// wildcards such as "log.*", and the most specific matching key applies.
+	TopicAttempts map[string]int
+}
+
+// DefaultRetryPolicy retries after 1s, 10s, 1m and 10m before giving up.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Delays:      []time.Duration{time.Second, 10 * time.Second, time.Minute, 10 * time.Minute},
+		MaxAttempts: 5,
+	}
+}
+
+// ParseRetryDelays parses a comma separated list of durations such as "1s,10s,1m".
+func ParseRetryDelays(s string) ([]time.Duration, error) {
+	var delays []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(field))
+		if err != nil || d < time.Millisecond {
+			return nil, fmt.Errorf("invalid retry delay %q", field)
+		}
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
+
+// ParseTopicLimits parses per topic limits such as "log.INFO.auth=3,log.ERROR.*=8".
+func ParseTopicLimits(s string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, field := range strings.Split(s, ",") {
+		topic, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		n, err := strconv.Atoi(value)
+		if !ok || topic == "" || err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid topic limit %q", field)
+		}
+		limits[topic] = n
+	}
+	return limits, nil
+}
+
+// topicPattern returns the key of limits that applies to a routing key: the key itself,
+// or else the most specific matching wildcard pattern. A path.Match "*" also matches
+// dots, so "log.*" matches "log.ERROR.auth" as well as "log.ERROR.*" does; the pattern
+// with the fewest wildcards wins, then the one with the longest literal prefix.
+func topicPattern(limits map[string]int, routingKey string) (string, bool) {
+	if _, ok := limits[routingKey]; ok {
+		return routingKey, true
+	}
+
+	best, found := "", false
+	for pattern := range limits {
+		if ok, _ := path.Match(pattern, routingKey); !ok {
+			continue
+		}
+		if !found || moreSpecific(pattern, best) {
+			best, found = pattern, true
+		}
+	}
+	return best, found
+}
+
+// moreSpecific reports whether pattern a is more specific than b. Ties are broken by
+// the patterns themselves, so the choice does not depend on map order.
+func moreSpecific(a, b string) bool {
+	wa, wb := wildcards(a), wildcards(b)
+	if wa != wb {
+		return wa < wb
+	}
+	pa, pb := literalPrefix(a), literalPrefix(b)
+	if pa != pb {
+		return pa > pb
+	}
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a < b
+}
+
+func wildcards(pattern string) int {
+	return strings.Count(pattern, "*") + strings.Count(pattern, "?") + strings.Count(pattern, "[")
+}
+
+func literalPrefix(pattern string) int {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return i
+	}
+	return len(pattern)
+}
+
+// maxAttempts returns the attempt limit for a routing key.
+func (p RetryPolicy) maxAttempts(routingKey string) int {
+	if pattern, ok := topicPattern(p.TopicAttempts, routingKey); ok {
+		return p.TopicAttempts[pattern]
+	}
+	return p.MaxAttempts
+}
+
+// tierName names the retry tier queue of a consumer group queue, e.g.
+// "logs.listener.retry.10s".
+func tierName(queue string, d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%s.retry.%dh", queue, d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%s.retry.%dm", queue, d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%s.retry.%ds", queue, d/time.Second)
+	default:
+		return fmt.Sprintf("%s.retry.%dms", queue, d/time.Millisecond)
+	}
+}
+
+// tier returns the retry tier of queue used after the given failed attempt.
+func (p RetryPolicy) tier(queue string, attempt int) string {
+	i := min(attempt, len(p.Delays)) - 1
+	return tierName(queue, p.Delays[i])
+}
+
+// declareRetryTiers declares one queue per delay for the consumer group queue. Retries
+// are published to it through the default exchange; its TTL is the delay and it
+// dead-letters expired messages back to queue.
+func declareRetryTiers(ch *amqp.Channel, queue string, delays []time.Duration) error {
+	for _, d := range delays {
+		name := tierName(queue, d)
+
+		_, err := ch.QueueDeclare(
+			name,
+			true,  // durable
+			false, // auto delete?
+			false, // exclusive?
+			false, // no wait?
+			amqp.Table{
+				"x-message-ttl":             d.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("declaring retry queue %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// routingKey returns the routing key the message was originally published with.
+func routingKey(msg amqp.Delivery) string {
+	if key, ok := msg.Headers[routingKeyHeader].(string); ok && key != "" {
+		return key
+	}
+	return msg.RoutingKey
+}
+
+// attempts returns how many times the message has already failed.
+func attempts(headers amqp.Table) int {
+	switch n := headers[attemptsHeader].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	}
+	return 0
+}
+
+// retryHeaders returns a copy of headers recording one more failed attempt.
+func retryHeaders(headers amqp.Table, attempt int, routingKey string, cause error) amqp.Table {
+	next := amqp.Table{}
+	for k, v := range headers {
+		next[k] = v
+	}
+
+	history, _ := headers[historyHeader].([]any)
+	history = append(history, amqp.Table{
+		"attempt":     int32(attempt),
+		"error":       cause.Error(),
+		"routing_key": routingKey,
+		"failed_at":   time.Now().UTC(),
+	})
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+
+	next[attemptsHeader] = int32(attempt)
+	next[historyHeader] = history
+	next[routingKeyHeader] = routingKey
+	return next
+}
+
+// scheduleRetry publishes the failed message to its retry tier.
+func (c *Consumer) scheduleRetry(ch *amqp.Channel, msg amqp.Delivery, attempt int, cause error) error {
+	return publishRetry(ch, c.retry.tier(c.queue, attempt), republish(msg, retryHeaders(msg.Headers, attempt, routingKey(msg), cause)))
+}
+
+// publishRetry publishes a copy of a delivery to a retry tier and waits for RabbitMQ to
+// confirm it, so the delivery is acknowledged only once its copy is safe. The channel
+// must be in confirm mode.
+func publishRetry(ch *amqp.Channel, tier string, publishing amqp.Publishing) error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"", // default exchange, routed by queue name
+		tier,
+		false,
+		false,
+		publishing,
+	)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("RabbitMQ refused the message")
+	}
+	return nil
+}
+
+// republish copies a delivery into a persistent message with new headers, keeping the
+// envelope properties.
+func republish(msg amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     msg.MessageId,
+		Type:          msg.Type,
+		AppId:         msg.AppId,
+		Timestamp:     msg.Timestamp,
+		CorrelationId: msg.CorrelationId,
+		Body:          msg.Body,
+	}
+}
@@ -0,0 +1,180 @@
+package event
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestTopicPattern(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits map[string]int
+		key    string
+		want   string
+	}{
+		{"exact", map[string]int{"log.ERROR.auth": 1, "log.ERROR.*": 2}, "log.ERROR.auth", "log.ERROR.auth"},
+		{"wildcard", map[string]int{"log.ERROR.*": 2}, "log.ERROR.auth", "log.ERROR.*"},
+		{"no match", map[string]int{"log.ERROR.*": 2}, "log.INFO.auth", ""},
+		// "*" matches dots too, so both patterns match; the narrower one wins
+		{"longer prefix", map[string]int{"log.*": 3, "log.ERROR.*": 8}, "log.ERROR.auth", "log.ERROR.*"},
+		{"broad pattern for the rest", map[string]int{"log.*": 3, "log.ERROR.*": 8}, "log.INFO.auth", "log.*"},
+		{"fewer wildcards", map[string]int{"log.*.*": 1, "log.*.auth": 2}, "log.ERROR.auth", "log.*.auth"},
+		{"question mark", map[string]int{"log.*": 1, "log.ERROR.aut?": 2}, "log.ERROR.auth", "log.ERROR.aut?"},
+		{"same prefix, longer pattern", map[string]int{"log.*": 1, "log.*.auth": 2}, "log.ERROR.auth", "log.*.auth"},
+		{"literal prefix over suffix", map[string]int{"log.E*": 1, "log.*R": 2}, "log.ERROR", "log.E*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// map order varies between runs; the result must not
+			for range 20 {
+				got, ok := topicPattern(tt.limits, tt.key)
+				if got != tt.want || ok != (tt.want != "") {
+					t.Fatalf("topicPattern(%q) = %q, %v, want %q", tt.key, got, ok, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestTopicMaxAttemptsExample pins the LISTENER_TOPIC_MAX_ATTEMPTS example of main.go.
+func TestTopicMaxAttemptsExample(t *testing.T) {
+	limits, err := ParseTopicLimits("log.ERROR.*=8,log.*=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := DefaultRetryPolicy()
+	policy.TopicAttempts = limits
+
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"log.ERROR.auth", 8},
+		{"log.ERROR.general", 8},
+		{"log.INFO.auth", 3},
+		{"log.WARN.mail", 3},
+		{"auth.login", policy.MaxAttempts},
+	}
+	for _, tt := range tests {
+		if got := policy.maxAttempts(tt.key); got != tt.want {
+			t.Errorf("maxAttempts(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestTierName(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		want  string
+	}{
+		{250 * time.Millisecond, "logs.listener.retry.250ms"},
+		{1500 * time.Millisecond, "logs.listener.retry.1500ms"},
+		{time.Second, "logs.listener.retry.1s"},
+		{90 * time.Second, "logs.listener.retry.90s"},
+		{10 * time.Minute, "logs.listener.retry.10m"},
+		{90 * time.Minute, "logs.listener.retry.90m"},
+		{2 * time.Hour, "logs.listener.retry.2h"},
+	}
+
+	for _, tt := range tests {
+		if got := tierName("logs.listener", tt.delay); got != tt.want {
+			t.Errorf("tierName(%v) = %s, want %s", tt.delay, got, tt.want)
+		}
+	}
+}
+
+func TestTier(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	tests := []struct {
+		attempt int
+		want    string
+	}{
+		{1, "logs.listener.retry.1s"},
+		{2, "logs.listener.retry.10s"},
+		{3, "logs.listener.retry.1m"},
+		{4, "logs.listener.retry.10m"},
+		// the last tier once they run out
+		{5, "logs.listener.retry.10m"},
+		{12, "logs.listener.retry.10m"},
+	}
+
+	for _, tt := range tests {
+		if got := policy.tier("logs.listener", tt.attempt); got != tt.want {
+			t.Errorf("tier after attempt %d = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{"first delivery", nil, 0},
+		{"int32", amqp.Table{attemptsHeader: int32(3)}, 3},
+		// the server may widen the integer when the message passes through it
+		{"int64", amqp.Table{attemptsHeader: int64(4)}, 4},
+		{"string", amqp.Table{attemptsHeader: "3"}, 0},
+		{"other headers", amqp.Table{"x-death": []any{}}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := attempts(tt.headers); got != tt.want {
+			t.Errorf("%s: attempts = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	policy.TopicAttempts = map[string]int{"log.ERROR.*": 8, "log.INFO.auth": 2}
+
+	tests := []struct {
+		routingKey string
+		want       int
+	}{
+		{"log.ERROR.auth", 8},
+		{"log.INFO.auth", 2},
+		{"log.INFO.mail", policy.MaxAttempts},
+		{"auth.login", policy.MaxAttempts},
+	}
+
+	for _, tt := range tests {
+		if got := policy.maxAttempts(tt.routingKey); got != tt.want {
+			t.Errorf("maxAttempts(%s) = %d, want %d", tt.routingKey, got, tt.want)
+		}
+	}
+}
+
+func TestRetryHeaders(t *testing.T) {
+	headers := amqp.Table{"x-custom": "kept", attemptsHeader: int32(1)}
+	for i := range maxHistory {
+		history, _ := headers[historyHeader].([]any)
+		headers[historyHeader] = append(history, amqp.Table{"attempt": int32(i + 1)})
+	}
+
+	next := retryHeaders(headers, 2, "log.ERROR.auth", errors.New("mail-service is down"))
+
+	if attempts(next) != 2 {
+		t.Errorf("attempts = %d, want 2", attempts(next))
+	}
+	if next[routingKeyHeader] != "log.ERROR.auth" || next["x-custom"] != "kept" {
+		t.Errorf("headers = %v", next)
+	}
+	history, _ := next[historyHeader].([]any)
+	if len(history) != maxHistory {
+		t.Fatalf("history holds %d attempts, want %d", len(history), maxHistory)
+	}
+	if last := history[len(history)-1].(amqp.Table); last["error"] != "mail-service is down" || last["attempt"] != int32(2) {
+		t.Errorf("last attempt = %v", last)
+	}
+	// the delivery's headers are left alone
+	if attempts(headers) != 1 {
+		t.Error("retryHeaders changed the delivery's headers")
+	}
+}
@@ -0,0 +1,29 @@
+package event
+
+import (
+	"context"
+	"net/http"
+	"shared/envelope"
+)
+
+// Mail is a message for mail-service's /send-email endpoint, the data of a "mail" event.
+type Mail = envelope.MailData
+
+// MailSender dispatches mail.
+type MailSender interface {
+	SendMail(ctx context.Context, mail Mail) error
+}
+
+// HTTPMailSender posts mail to mail-service.
+type HTTPMailSender struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewHTTPMailSender(url string) *HTTPMailSender {
+	return &HTTPMailSender{url: url, httpClient: newHTTPClient()}
+}
+
+func (m *HTTPMailSender) SendMail(ctx context.Context, mail Mail) error {
+	return postJSON(ctx, m.httpClient, m.url, mail)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"listener/event"
+	"log"
+	"net/http"
+	"shared/rabbit"
+)
+
+// serveHealth reports the RabbitMQ connection state on /health. It answers 503 while
+// the listener is reconnecting, so orchestrators can tell a stalled consumer apart.
+// /metrics reports the event handler stats.
+func serveHealth(addr string, manager *rabbit.Manager, metrics *event.Metrics) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		status := manager.Status()
+
+		w.Header().Set("Content-Type", "application/json")
+		if status.State != rabbit.StateConnected {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics.Snapshot())
+	})
+
+	log.Println("Serving health checks on", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Println("health server:", err)
+	}
+}
@@ -0,0 +1,175 @@
+package alert
+
+import (
+	"context"
+	"log"
+	"logger-service/data"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const notifyTimeout = 10 * time.Second
+
+// Alert is what gets delivered when a rule fires.
+type Alert struct {
+	Rule    data.Rule     `json:"rule"`
+	Count   int           `json:"count"`
+	Entry   data.LogEntry `json:"entry"`
+	FiredAt time.Time     `json:"fired_at"`
+}
+
+// Notifier delivers fired alerts to one kind of target.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// TargetValidator is implemented by notifiers that restrict where alerts may be sent.
+type TargetValidator interface {
+	ValidateTarget(rule data.Rule) error
+}
+
+// Engine evaluates the loaded rules against incoming log entries. Every rule keeps a
+// sliding window of its recent matches; the engine is safe for concurrent use.
+type Engine struct {
+	mu        sync.Mutex
+	rules     map[string]*ruleState
+	notifiers map[string]Notifier
+	now       func() time.Time
+}
+
+type ruleState struct {
+	rule      data.Rule
+	pattern   *regexp.Regexp
+	hits      []time.Time
+	lastFired time.Time
+}
+
+// NewEngine returns an engine that delivers alerts through the given notifiers, keyed by
+// notification type (data.NotifyMail, data.NotifyWebhook).
+func NewEngine(notifiers map[string]Notifier) *Engine {
+	return &Engine{
+		rules:     map[string]*ruleState{},
+		notifiers: notifiers,
+		now:       time.Now,
+	}
+}
+
+// Load replaces the active rule set. The window and cooldown state of rules that are
+// still present is kept, so reloading after an edit does not re-fire or forget matches.
+func (e *Engine) Load(rules []data.Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	active := make(map[string]*ruleState, len(rules))
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Printf("Skipping alert rule %s: %v", rule.ID, err)
+			continue
+		}
+
+		state := &ruleState{rule: rule, pattern: pattern}
+		if old, ok := e.rules[rule.ID]; ok {
+			state.hits = old.hits
+			state.lastFired = old.lastFired
+		}
+		active[rule.ID] = state
+	}
+
+	e.rules = active
+}
+
+// ValidateTarget checks that the notifier of a rule accepts its target, so that a rule is
+// refused when it is created rather than when it fires.
+func (e *Engine) ValidateTarget(rule data.Rule) error {
+	if validator, ok := e.notifiers[rule.Notify.Type].(TargetValidator); ok {
+		return validator.ValidateTarget(rule)
+	}
+	return nil
+}
+
+// Observe evaluates an incoming entry against every rule and sends notifications for the
+// rules that fire. Delivery happens in the background so ingestion is never blocked.
+func (e *Engine) Observe(entry data.LogEntry) {
+	for _, alert := range e.evaluate(entry) {
+		go e.deliver(alert)
+	}
+}
+
+func (e *Engine) evaluate(entry data.LogEntry) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	var fired []Alert
+
+	for _, state := range e.rules {
+		rule := state.rule
+		if rule.Tenant != entry.Tenant {
+			continue
+		}
+		if rule.LogName != "" && rule.LogName != entry.Name {
+			continue
+		}
+		if rule.Severity != "" && !strings.EqualFold(rule.Severity, entry.Severity) {
+			continue
+		}
+		if rule.Pattern != "" && !state.pattern.MatchString(entry.Data) {
+			continue
+		}
+
+		// slide the window forward and record this match
+		window := time.Duration(rule.WindowSeconds) * time.Second
+		keep := state.hits[:0]
+		for _, t := range state.hits {
+			if now.Sub(t) < window {
+				keep = append(keep, t)
+			}
+		}
+		state.hits = append(keep, now)
+
+		if len(state.hits) <= rule.Threshold {
+			continue
+		}
+
+		// de-duplicate: a rule that already fired stays quiet until its cooldown is over
+		cooldown := time.Duration(rule.CooldownSeconds) * time.Second
+		if !state.lastFired.IsZero() && now.Sub(state.lastFired) < cooldown {
+			continue
+		}
+
+		state.lastFired = now
+		fired = append(fired, Alert{
+			Rule:    rule,
+			Count:   len(state.hits),
+			Entry:   entry,
+			FiredAt: now,
+		})
+		state.hits = nil
+	}
+
+	return fired
+}
+
+func (e *Engine) deliver(alert Alert) {
+	notifier, ok := e.notifiers[alert.Rule.Notify.Type]
+	if !ok {
+		log.Printf("No notifier for alert rule %q (type %q)", alert.Rule.Name, alert.Rule.Notify.Type)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	if err := notifier.Notify(ctx, alert); err != nil {
+		log.Printf("Failed to deliver alert for rule %q: %v", alert.Rule.Name, err)
+		return
+	}
+	log.Printf("Alert rule %q fired (%d matches)", alert.Rule.Name, alert.Count)
+}
@@ -0,0 +1,179 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"logger-service/data"
+)
+
+// clock is a fake time source the tests move forward by hand.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestEngine(rules ...data.Rule) (*Engine, *clock) {
+	c := &clock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	e := NewEngine(nil)
+	e.now = c.Now
+	for i := range rules {
+		rules[i].ID = string(rune('a' + i))
+		rules[i].Enabled = true
+	}
+	e.Load(rules)
+	return e, c
+}
+
+// authErrors is the headline example: more than 3 ERROR entries from auth in 5 minutes.
+func authErrors() data.Rule {
+	return data.Rule{Name: "auth errors", LogName: "auth", Severity: "ERROR", Threshold: 3, WindowSeconds: 300}
+}
+
+func authError() data.LogEntry {
+	return data.LogEntry{Name: "auth", Severity: "ERROR", Data: "login failed"}
+}
+
+// observe feeds entries one second apart and returns how many alerts fired.
+func observe(e *Engine, c *clock, n int, entry data.LogEntry) int {
+	fired := 0
+	for range n {
+		fired += len(e.evaluate(entry))
+		c.Advance(time.Second)
+	}
+	return fired
+}
+
+func TestEngineThreshold(t *testing.T) {
+	e, c := newTestEngine(authErrors())
+
+	if fired := observe(e, c, 3, authError()); fired != 0 {
+		t.Fatalf("fired %d alerts at the threshold, want 0", fired)
+	}
+	alerts := e.evaluate(authError())
+	if len(alerts) != 1 {
+		t.Fatalf("fired %d alerts past the threshold, want 1", len(alerts))
+	}
+	if alerts[0].Count != 4 || alerts[0].Rule.Name != "auth errors" || !alerts[0].FiredAt.Equal(c.now) {
+		t.Errorf("alert = %+v", alerts[0])
+	}
+}
+
+func TestEngineMatching(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry data.LogEntry
+		match bool
+	}{
+		{"match", authError(), true},
+		{"severity ignores case", data.LogEntry{Name: "auth", Severity: "error"}, true},
+		{"other severity", data.LogEntry{Name: "auth", Severity: "INFO"}, false},
+		{"no severity", data.LogEntry{Name: "auth"}, false},
+		{"other name", data.LogEntry{Name: "mail", Severity: "ERROR"}, false},
+		{"other tenant", data.LogEntry{Tenant: "acme", Name: "auth", Severity: "ERROR"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := authErrors()
+			rule.Threshold = 0
+			e, _ := newTestEngine(rule)
+			if fired := len(e.evaluate(tt.entry)) == 1; fired != tt.match {
+				t.Errorf("fired = %v, want %v", fired, tt.match)
+			}
+		})
+	}
+
+	e, _ := newTestEngine(data.Rule{Name: "timeouts", Pattern: `time(d )?out`})
+	if len(e.evaluate(data.LogEntry{Name: "x", Data: "request timed out"})) != 1 {
+		t.Error("pattern did not match")
+	}
+	if len(e.evaluate(data.LogEntry{Name: "x", Data: "ok"})) != 0 {
+		t.Error("pattern matched other data")
+	}
+}
+
+func TestEngineSlidingWindow(t *testing.T) {
+	e, c := newTestEngine(authErrors())
+
+	// three matches, then the first of them leaves the window
+	observe(e, c, 3, authError())
+	c.Advance(300*time.Second - 3*time.Second)
+	if fired := len(e.evaluate(authError())); fired != 0 {
+		t.Fatalf("fired with a match outside the window")
+	}
+	// the window holds the last three and this one
+	if fired := len(e.evaluate(authError())); fired != 1 {
+		t.Fatalf("fired %d alerts with four matches in the window, want 1", fired)
+	}
+
+	// matches spread wider than the window never fire
+	e, c = newTestEngine(authErrors())
+	for range 10 {
+		if len(e.evaluate(authError())) != 0 {
+			t.Fatal("fired with one match per window")
+		}
+		c.Advance(200 * time.Second)
+	}
+}
+
+func TestEngineCooldown(t *testing.T) {
+	rule := authErrors()
+	rule.Threshold = 0
+	rule.CooldownSeconds = 60
+	e, c := newTestEngine(rule)
+
+	if len(e.evaluate(authError())) != 1 {
+		t.Fatal("the first match did not fire")
+	}
+	// de-duplicated: the same condition does not alert again during the cooldown
+	if fired := observe(e, c, 59, authError()); fired != 0 {
+		t.Fatalf("fired %d alerts during the cooldown, want 0", fired)
+	}
+	c.Advance(time.Second)
+	if len(e.evaluate(authError())) != 1 {
+		t.Error("did not fire after the cooldown")
+	}
+}
+
+func TestEngineResetsAfterFiring(t *testing.T) {
+	e, c := newTestEngine(authErrors())
+
+	if fired := observe(e, c, 4, authError()); fired != 1 {
+		t.Fatalf("fired %d alerts, want 1", fired)
+	}
+	// the matches that caused the alert are not counted for the next one
+	if fired := observe(e, c, 3, authError()); fired != 0 {
+		t.Fatalf("fired %d alerts right after firing, want 0", fired)
+	}
+	if fired := observe(e, c, 1, authError()); fired != 1 {
+		t.Errorf("fired %d alerts after four new matches, want 1", fired)
+	}
+}
+
+func TestEngineLoadKeepsState(t *testing.T) {
+	rule := authErrors()
+	rule.CooldownSeconds = 600
+	e, c := newTestEngine(rule)
+
+	observe(e, c, 3, authError())
+	rules := []data.Rule{rule}
+	rules[0].ID, rules[0].Enabled = "a", true
+	e.Load(rules)
+	if len(e.evaluate(authError())) != 1 {
+		t.Fatal("reloading forgot the matches in the window")
+	}
+
+	// reloading does not re-fire a rule that is cooling down
+	e.Load(rules)
+	if fired := observe(e, c, 8, authError()); fired != 0 {
+		t.Errorf("fired %d alerts during the cooldown after a reload, want 0", fired)
+	}
+
+	// disabled rules are dropped
+	rules[0].Enabled = false
+	e.Load(rules)
+	if fired := observe(e, c, 10, authError()); fired != 0 {
+		t.Errorf("a disabled rule fired %d alerts", fired)
+	}
+}
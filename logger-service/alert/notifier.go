@@ -0,0 +1,134 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"logger-service/data"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MailNotifier sends alerts through mail-service's /send-email endpoint.
+type MailNotifier struct {
+	baseUrl string
+	client  *http.Client
+}
+
+func NewMailNotifier(url string, client *http.Client) *MailNotifier {
+	return &MailNotifier{
+		baseUrl: url,
+		client:  client,
+	}
+}
+
+func (m *MailNotifier) Notify(ctx context.Context, alert Alert) error {
+	payload := map[string]any{
+		"to":       alert.Rule.Notify.To,
+		"subject":  fmt.Sprintf("[alert] %s", alert.Rule.Name),
+		"template": "alert.html",
+		"data": map[string]any{
+			"Rule":     alert.Rule.Name,
+			"Count":    alert.Count,
+			"Window":   alert.Rule.WindowSeconds,
+			"Name":     alert.Entry.Name,
+			"Severity": alert.Entry.Severity,
+			"Data":     alert.Entry.Data,
+			"FiredAt":  alert.FiredAt.Format("2006-01-02 15:04:05 MST"),
+		},
+	}
+
+	return postJSON(ctx, m.client, m.baseUrl+"/send-email", payload)
+}
+
+// WebhookNotifier posts the alert as JSON to the URL configured on the rule. Only http and
+// https URLs on an allowed host are accepted, so that rules cannot make the logger call
+// internal services.
+type WebhookNotifier struct {
+	client       *http.Client
+	allowedHosts []string
+}
+
+// NewWebhookNotifier returns a notifier posting to the allowed hosts. A host is either a
+// name such as hooks.example.com or a wildcard such as *.example.com, which matches the
+// subdomains of example.com. Without allowed hosts every webhook is refused.
+func NewWebhookNotifier(client *http.Client, allowedHosts []string) *WebhookNotifier {
+	// a redirect could lead to a host that is not allowed
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	hosts := make([]string, 0, len(allowedHosts))
+	for _, host := range allowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return &WebhookNotifier{client: &noRedirects, allowedHosts: hosts}
+}
+
+func (wh *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	// the rule may have been stored before the allowed hosts changed
+	if err := wh.ValidateTarget(alert.Rule); err != nil {
+		return err
+	}
+	return postJSON(ctx, wh.client, alert.Rule.Notify.URL, alert)
+}
+
+// ValidateTarget checks the webhook URL of a rule against the scheme and allowed hosts.
+func (wh *WebhookNotifier) ValidateTarget(rule data.Rule) error {
+	u, err := url.Parse(rule.Notify.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("webhook url must use http or https")
+	}
+	if u.User != nil {
+		return errors.New("webhook url must not contain credentials")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.New("webhook url has no host")
+	}
+	for _, allowed := range wh.allowedHosts {
+		if host == allowed {
+			return nil
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook host %q is not allowed", host)
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, payload any) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status code %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
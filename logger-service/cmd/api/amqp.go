@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"logger-service/data"
+	"logger-service/tenant"
+	"os"
+	"shared/rabbit"
+	"time"
+)
+
+// amqpRPCQueue is the queue the broker sends log requests to over RabbitMQ.
+const amqpRPCQueue = "rpc.logger"
+
+// AMQPPayload is the request of the "log.write" method. APIKey identifies the caller's
+// tenant.
+type AMQPPayload struct {
+	JSONPayload
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// serveAMQPRPC starts the RPC server on amqpRPCQueue when RABBITMQ_URL is set. It
+// connects in the background, so the other servers do not wait for RabbitMQ.
+func (app *Config) serveAMQPRPC() {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		return
+	}
+
+	manager := rabbit.NewManager(url)
+	server, err := rabbit.NewRPCServer(manager, amqpRPCQueue, app.handleAMQPRPC)
+	if err != nil {
+		log.Fatal("AMQP RPC server failed: ", err)
+	}
+
+	go func() {
+		manager.Start()
+		server.Serve()
+	}()
+}
+
+// handleAMQPRPC serves the requests on amqpRPCQueue. A reply carries the same
+// jsonResponse WriteLog writes.
+func (app *Config) handleAMQPRPC(ctx context.Context, method string, body []byte) (any, error) {
+	switch method {
+	case "log.write":
+		var payload AMQPPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+
+		id, err := app.Tenants.Resolve(payload.APIKey)
+		if err != nil {
+			return nil, err
+		}
+
+		duplicate, err := app.ingestOnce(tenant.NewContext(ctx, id), payload.IdempotencyKey, data.LogEntry{
+			Name:       payload.Name,
+			Data:       payload.Data,
+			Severity:   payload.Severity,
+			Attributes: payload.Attributes,
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			log.Println("Error writing log via AMQP RPC:", err)
+			return nil, err
+		}
+
+		if duplicate {
+			return jsonResponse{Error: false, Message: "already logged"}, nil
+		}
+		return jsonResponse{Error: false, Message: "logged"}, nil
+	}
+
+	return nil, fmt.Errorf("unknown method %q", method)
+}
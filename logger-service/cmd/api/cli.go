@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"logger-service/data"
+	"os"
+	"time"
+)
+
+// runExport implements the `export` subcommand, which writes the stored logs to a file
+// using the same encoders as GET /logs/export:
+//
+//	logger export -format csv -fields name,data -from 2026-01-01T00:00:00Z -out logs.csv
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "ndjson", "output format: ndjson, csv or parquet")
+	out := fs.String("out", "", "output file (defaults to stdout)")
+	fields := fs.String("fields", "", "comma separated list of columns to export")
+	tenantID := fs.String("tenant", "", "export the logs of this tenant")
+	name := fs.String("name", "", "only export logs with this name")
+	from := fs.String("from", "", "only export logs created at or after this RFC 3339 time")
+	to := fs.String("to", "", "only export logs created before this RFC 3339 time")
+	limit := fs.Int64("limit", 0, "maximum number of logs to export")
+	compress := fs.Bool("gzip", false, "gzip the output")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if _, ok := exportContentTypes[*format]; !ok {
+		fmt.Fprintf(os.Stderr, "unsupported export format %q\n", *format)
+		return 2
+	}
+
+	columns, err := parseColumns(*fields)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	filter := data.LogFilter{Tenant: *tenantID, Name: *name, Limit: *limit}
+	if *from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			fmt.Fprintln(os.Stderr, "from must be an RFC 3339 timestamp")
+			return 2
+		}
+	}
+	if *to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			fmt.Fprintln(os.Stderr, "to must be an RFC 3339 timestamp")
+			return 2
+		}
+	}
+
+	output := os.Stdout
+	if *out != "" {
+		output, err = os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	models, closeStore, err := openModels()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer closeStore()
+
+	app := Config{
+		Models: *models,
+	}
+
+	err = app.exportLogs(context.Background(), output, *format, columns, filter, *compress)
+	if output != os.Stdout {
+		// a failed close can mean the file was not fully written
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		return 1
+	}
+
+	return 0
+}
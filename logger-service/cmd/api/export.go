@@ -0,0 +1,300 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"logger-service/data"
+	"logger-service/tenant"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportColumns lists the columns that can be selected for an export, in output order.
+var exportColumns = []string{"id", "name", "data", "severity", "attributes", "created_at", "updated_at"}
+
+// exportContentTypes maps every supported export format to its content type.
+var exportContentTypes = map[string]string{
+	"ndjson":  "application/x-ndjson",
+	"csv":     "text/csv",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// logWriter encodes log entries one at a time into an export format.
+type logWriter interface {
+	Write(entry *data.LogEntry) error
+	Close() error
+}
+
+func newLogWriter(format string, w io.Writer, columns []string) (logWriter, error) {
+	switch format {
+	case "ndjson":
+		return &ndjsonWriter{enc: json.NewEncoder(w), columns: columns}, nil
+	case "csv":
+		return newCSVWriter(w, columns)
+	case "parquet":
+		return newParquetWriter(w, columns), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// parseColumns validates a comma separated column list. An empty list selects every column.
+func parseColumns(fields string) ([]string, error) {
+	if fields == "" {
+		return exportColumns, nil
+	}
+
+	var columns []string
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if !isExportColumn(field) {
+			return nil, fmt.Errorf("unknown column %q", field)
+		}
+		columns = append(columns, field)
+	}
+
+	return columns, nil
+}
+
+func isExportColumn(name string) bool {
+	for _, column := range exportColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+// columnValue returns the value of a column as a string or time.Time. Attributes are
+// flattened into a JSON object string.
+func columnValue(entry *data.LogEntry, column string) any {
+	switch column {
+	case "attributes":
+		if len(entry.Attributes) == 0 {
+			return ""
+		}
+		b, _ := json.Marshal(entry.Attributes)
+		return string(b)
+	case "id":
+		return entry.ID
+	case "name":
+		return entry.Name
+	case "data":
+		return entry.Data
+	case "severity":
+		return entry.Severity
+	case "created_at":
+		return entry.CreatedAt
+	case "updated_at":
+		return entry.UpdatedAt
+	}
+	return nil
+}
+
+type ndjsonWriter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func (n *ndjsonWriter) Write(entry *data.LogEntry) error {
+	row := make(map[string]any, len(n.columns))
+	for _, column := range n.columns {
+		if column == "attributes" {
+			row[column] = entry.Attributes
+			continue
+		}
+		row[column] = columnValue(entry, column)
+	}
+	return n.enc.Encode(row)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	c := &csvWriter{
+		w:       csv.NewWriter(w),
+		columns: columns,
+		record:  make([]string, len(columns)),
+	}
+
+	// header row
+	if err := c.w.Write(columns); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *csvWriter) Write(entry *data.LogEntry) error {
+	for i, column := range c.columns {
+		switch v := columnValue(entry, column).(type) {
+		case time.Time:
+			c.record[i] = v.Format(time.RFC3339Nano)
+		case string:
+			c.record[i] = v
+		}
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// parquetWriter builds a struct type holding only the selected columns and lets
+// parquet-go derive the file schema from it.
+type parquetWriter struct {
+	w       *parquet.Writer
+	rowType reflect.Type
+	columns []string
+}
+
+func newParquetWriter(w io.Writer, columns []string) *parquetWriter {
+	fields := make([]reflect.StructField, len(columns))
+	for i, column := range columns {
+		fields[i] = reflect.StructField{
+			Name: "F" + strconv.Itoa(i),
+			Type: reflect.TypeOf(columnValue(&data.LogEntry{}, column)),
+			Tag:  reflect.StructTag(fmt.Sprintf(`parquet:"%s"`, column)),
+		}
+	}
+	rowType := reflect.StructOf(fields)
+
+	return &parquetWriter{
+		w:       parquet.NewWriter(w, parquet.SchemaOf(reflect.New(rowType).Interface())),
+		rowType: rowType,
+		columns: columns,
+	}
+}
+
+func (p *parquetWriter) Write(entry *data.LogEntry) error {
+	row := reflect.New(p.rowType)
+	for i, column := range p.columns {
+		row.Elem().Field(i).Set(reflect.ValueOf(columnValue(entry, column)))
+	}
+	return p.w.Write(row.Interface())
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
+
+// parseLogFilter reads the name, severity, from, to and limit query parameters shared by
+// the read endpoints. Times are RFC 3339.
+func parseLogFilter(r *http.Request) (data.LogFilter, error) {
+	q := r.URL.Query()
+	filter := data.LogFilter{
+		Tenant:   tenant.FromContext(r.Context()),
+		Name:     q.Get("name"),
+		Severity: q.Get("severity"),
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("from must be an RFC 3339 timestamp")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("to must be an RFC 3339 timestamp")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.ParseInt(v, 10, 64); err != nil || filter.Limit < 0 {
+			return filter, errors.New("limit must be a positive integer")
+		}
+	}
+
+	return filter, nil
+}
+
+// ExportLogs streams the filtered logs as ndjson, csv or parquet.
+// Query parameters: format, fields, gzip, plus the filters accepted by parseLogFilter.
+func (app *Config) ExportLogs(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("unsupported export format %q", format))
+		return
+	}
+
+	columns, err := parseColumns(r.URL.Query().Get("fields"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	compress := r.URL.Query().Get("gzip") == "true"
+
+	filename := "logs." + format
+	if compress {
+		filename += ".gz"
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Headers are sent with the first write, so errors past this point can only be logged.
+	if err := app.exportLogs(r.Context(), w, format, columns, filter, compress); err != nil {
+		log.Println("Error exporting logs:", err)
+	}
+}
+
+// exportLogs encodes the logs matching filter into w, optionally gzip compressed.
+func (app *Config) exportLogs(ctx context.Context, w io.Writer, format string, columns []string, filter data.LogFilter, compress bool) error {
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	lw, err := newLogWriter(format, w, columns)
+	if err != nil {
+		return err
+	}
+
+	err = app.Models.Logs.Query(ctx, filter, lw.Write)
+	if err != nil {
+		lw.Close()
+		return err
+	}
+	if err := lw.Close(); err != nil {
+		return err
+	}
+
+	// closing the gzip writer flushes the last block and writes the footer
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
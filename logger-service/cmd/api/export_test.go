@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"logger-service/data"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var exportTime = time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+
+// exportEntries are awkward to encode: separators, quotes, newlines and attributes.
+func exportEntries() []data.LogEntry {
+	return []data.LogEntry{
+		{ID: "1", Name: "orders", Data: "order placed", Severity: "INFO", CreatedAt: exportTime, UpdatedAt: exportTime},
+		{ID: "2", Name: "orders", Data: `said "hello", then left`, Attributes: map[string]string{"user": "42"}, CreatedAt: exportTime.Add(time.Second)},
+		{ID: "3", Name: "mail", Data: "line one\nline two", Severity: "ERROR", CreatedAt: exportTime.Add(2 * time.Second)},
+	}
+}
+
+// encode writes entries in a format through the writer the exports use.
+func encode(t *testing.T, format string, columns []string, entries []data.LogEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	lw, err := newLogWriter(format, &buf, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range entries {
+		if err := lw.Write(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSVWriter(t *testing.T) {
+	out := encode(t, "csv", exportColumns, exportEntries())
+
+	records, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("reading %q: %v", out, err)
+	}
+	want := [][]string{
+		exportColumns,
+		{"1", "orders", "order placed", "INFO", "", "2026-01-02T03:04:05.0000006Z", "2026-01-02T03:04:05.0000006Z"},
+		{"2", "orders", `said "hello", then left`, "", `{"user":"42"}`, "2026-01-02T03:04:06.0000006Z", "0001-01-01T00:00:00Z"},
+		{"3", "mail", "line one\nline two", "ERROR", "", "2026-01-02T03:04:07.0000006Z", "0001-01-01T00:00:00Z"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %q", len(records), len(want), records)
+	}
+	for i := range want {
+		if !slices.Equal(records[i], want[i]) {
+			t.Errorf("record %d = %q, want %q", i, records[i], want[i])
+		}
+	}
+
+	// the quoting itself, as spreadsheets read it
+	if !strings.Contains(string(out), `"said ""hello"", then left"`) || !strings.Contains(string(out), "\"line one\nline two\"") {
+		t.Errorf("fields are not quoted: %s", out)
+	}
+}
+
+func TestCSVWriterColumns(t *testing.T) {
+	out := encode(t, "csv", []string{"severity", "name"}, exportEntries()[:1])
+	if got, want := string(out), "severity,name\nINFO,orders\n"; got != want {
+		t.Errorf("csv = %q, want %q", got, want)
+	}
+
+	// the header is written even without rows
+	if got := string(encode(t, "csv", []string{"name", "data"}, nil)); got != "name,data\n" {
+		t.Errorf("empty csv = %q", got)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	type row struct {
+		Name       string    `parquet:"name"`
+		Data       string    `parquet:"data"`
+		Attributes string    `parquet:"attributes"`
+		CreatedAt  time.Time `parquet:"created_at"`
+	}
+
+	out := encode(t, "parquet", []string{"name", "data", "attributes", "created_at"}, exportEntries())
+	rows, err := parquet.Read[row](bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := exportEntries()
+	if len(rows) != len(entries) {
+		t.Fatalf("read %d rows, want %d", len(rows), len(entries))
+	}
+	for i, entry := range entries {
+		got := rows[i]
+		if got.Name != entry.Name || got.Data != entry.Data || !got.CreatedAt.Equal(entry.CreatedAt) {
+			t.Errorf("row %d = %+v, want %+v", i, got, entry)
+		}
+	}
+	if rows[1].Attributes != `{"user":"42"}` {
+		t.Errorf("attributes = %q", rows[1].Attributes)
+	}
+
+	// only the selected columns are in the file
+	file, err := parquet.OpenFile(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var columns []string
+	for _, field := range file.Schema().Fields() {
+		columns = append(columns, field.Name())
+	}
+	if want := []string{"name", "data", "attributes", "created_at"}; !slices.Equal(columns, want) {
+		t.Errorf("columns = %v, want %v", columns, want)
+	}
+}
+
+func TestExportLogsGzip(t *testing.T) {
+	app := newTestApp(t)
+	for _, entry := range exportEntries() {
+		if err := app.Models.Logs.Insert(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := serve(app, http.MethodGet, "/logs/export?format=csv&fields=name,data&gzip=true", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("content type = %q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="logs.csv.gz"` {
+		t.Errorf("content disposition = %q", cd)
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(gz).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || !slices.Equal(records[0], []string{"name", "data"}) || records[3][1] != "line one\nline two" {
+		t.Errorf("records = %q", records)
+	}
+}
+
+func TestRunExport(t *testing.T) {
+	dir := t.TempDir()
+	models, logs, err := data.NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range exportEntries() {
+		if err := models.Logs.Insert(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := logs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LOG_STORE", "file")
+	t.Setenv("LOG_STORE_PATH", dir)
+	out := filepath.Join(t.TempDir(), "logs.ndjson.gz")
+
+	if code := runExport([]string{"-format", "ndjson", "-fields", "name,data", "-name", "orders", "-gzip", "-out", out}); code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var row map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, row["data"])
+	}
+	if want := []string{"order placed", `said "hello", then left`}; !slices.Equal(got, want) {
+		t.Errorf("exported %q, want %q", got, want)
+	}
+}
+
+func TestRunExportInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"-format", "xml"},
+		{"-fields", "password"},
+		{"-from", "yesterday"},
+		{"-unknown"},
+	} {
+		if code := runExport(args); code != 2 {
+			t.Errorf("%v: exit code = %d, want 2", args, code)
+		}
+	}
+}
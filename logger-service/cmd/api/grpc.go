@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"logger-service/data"
+	"logger-service/logs"
+	"logger-service/tenant"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// writeStreamBatch is how many streamed entries are buffered before they are stored.
+const writeStreamBatch = 100
+
+// logServer implements the gRPC LogService on top of the same store and ingest path as
+// the HTTP handlers.
+type logServer struct {
+	logs.UnimplementedLogServiceServer
+	app *Config
+}
+
+// newGRPCServer returns the server of the LogService, which resolves the caller's tenant
+// on every call.
+func (app *Config) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(app.unaryTenant),
+		grpc.StreamInterceptor(app.streamTenant),
+	)
+	logs.RegisterLogServiceServer(srv, &logServer{app: app})
+	return srv
+}
+
+func serveGRPC(srv *grpc.Server, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("gRPC server listening on", addr)
+	return srv.Serve(lis)
+}
+
+// stopGRPC lets the running calls finish, then cuts off those still running when ctx is
+// done; Tail streams only end when their clients leave.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+func entryFromRequest(req *logs.WriteRequest, now time.Time) data.LogEntry {
+	return data.LogEntry{
+		Name:       req.GetName(),
+		Data:       req.GetData(),
+		Severity:   req.GetSeverity(),
+		Attributes: req.GetAttributes(),
+		CreatedAt:  now,
+	}
+}
+
+func entryToProto(entry *data.LogEntry) *logs.LogEntry {
+	return &logs.LogEntry{
+		Id:         entry.ID,
+		Name:       entry.Name,
+		Data:       entry.Data,
+		Severity:   entry.Severity,
+		Attributes: entry.Attributes,
+		CreatedAt:  timestamppb.New(entry.CreatedAt),
+	}
+}
+
+func (s *logServer) Write(ctx context.Context, req *logs.WriteRequest) (*logs.WriteResponse, error) {
+	duplicate, err := s.app.ingestOnce(ctx, req.GetIdempotencyKey(), entryFromRequest(req, time.Now()))
+	if err != nil {
+		return nil, grpcIngestError(err)
+	}
+	if duplicate {
+		return &logs.WriteResponse{Message: "already logged"}, nil
+	}
+	return &logs.WriteResponse{Message: "logged"}, nil
+}
+
+func (s *logServer) WriteStream(stream logs.LogService_WriteStreamServer) error {
+	var count int64
+	// batches are never larger than the tenant may write at once
+	size := writeStreamBatch
+	if burst := s.app.Tenants.MaxBatch(tenant.FromContext(stream.Context())); burst > 0 {
+		size = min(size, burst)
+	}
+	batch := make([]data.LogEntry, 0, size)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.app.ingest(stream.Context(), batch...); err != nil {
+			return grpcIngestError(err)
+		}
+		count += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if err := flush(); err != nil {
+				return err
+			}
+			return stream.SendAndClose(&logs.WriteStreamResponse{Count: count})
+		}
+		if err != nil {
+			return err
+		}
+
+		// an entry with an idempotency key is written on its own, after the entries
+		// before it, so that a duplicate is skipped instead of failing the batch; it
+		// counts as written, as in Write
+		if key := req.GetIdempotencyKey(); key != "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			if _, err := s.app.ingestOnce(stream.Context(), key, entryFromRequest(req, time.Now())); err != nil {
+				return grpcIngestError(err)
+			}
+			count++
+			continue
+		}
+
+		batch = append(batch, entryFromRequest(req, time.Now()))
+		if len(batch) == size {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *logServer) Query(req *logs.QueryRequest, stream logs.LogService_QueryServer) error {
+	filter := data.LogFilter{
+		Tenant: tenant.FromContext(stream.Context()),
+		Name:   req.GetName(),
+		Limit:  req.GetLimit(),
+	}
+	if req.GetFrom() != nil {
+		filter.From = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		filter.To = req.GetTo().AsTime()
+	}
+
+	return s.app.Models.Logs.Query(stream.Context(), filter, func(entry *data.LogEntry) error {
+		return stream.Send(entryToProto(entry))
+	})
+}
+
+func (s *logServer) Tail(req *logs.TailRequest, stream logs.LogService_TailServer) error {
+	entries, unsubscribe := s.app.Tail.Subscribe(tenant.FromContext(stream.Context()), req.GetName())
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case entry := <-entries:
+			if err := stream.Send(entryToProto(&entry)); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"logger-service/logs"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// writeStream feeds requests to WriteStream as a client stream would.
+type writeStream struct {
+	grpc.ServerStream
+	reqs []*logs.WriteRequest
+	resp *logs.WriteStreamResponse
+}
+
+func (s *writeStream) Context() context.Context { return context.Background() }
+
+func (s *writeStream) Recv() (*logs.WriteRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *writeStream) SendAndClose(resp *logs.WriteStreamResponse) error {
+	s.resp = resp
+	return nil
+}
+
+func TestWriteStreamIdempotencyKey(t *testing.T) {
+	app := newTestApp(t)
+	srv := &logServer{app: app}
+
+	if _, err := srv.Write(context.Background(), &logs.WriteRequest{Name: "a", Data: "unary", IdempotencyKey: "k1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := &writeStream{reqs: []*logs.WriteRequest{
+		{Name: "a", Data: "first"},
+		// already written by Write
+		{Name: "a", Data: "unary again", IdempotencyKey: "k1"},
+		{Name: "a", Data: "keyed", IdempotencyKey: "k2"},
+		// repeated within the stream
+		{Name: "a", Data: "keyed again", IdempotencyKey: "k2"},
+		{Name: "a", Data: "last"},
+	}}
+	if err := srv.WriteStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	// duplicates count as written, as Write answers "already logged"
+	if stream.resp.GetCount() != 5 {
+		t.Errorf("count = %d, want 5", stream.resp.GetCount())
+	}
+
+	var got []string
+	for _, entry := range stored(t, app, "") {
+		got = append(got, entry.Data)
+	}
+	want := []string{"unary", "first", "keyed", "last"}
+	if !slices.Equal(got, want) {
+		t.Errorf("stored %q, want %q", got, want)
+	}
+}
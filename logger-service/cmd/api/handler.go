@@ -1,13 +1,79 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"logger-service/data"
+	"logger-service/tenant"
 	"net/http"
+	"time"
 )
 
+// ingest stores new entries for the tenant in ctx and hands them to the alerting engine
+// and to tail subscribers. Every write path (HTTP, gRPC) goes through here, so this is
+// also where sensitive values are redacted and the tenant quotas are enforced.
+func (app *Config) ingest(ctx context.Context, entries ...data.LogEntry) error {
+	// redact before anything else sees the entries, dropping those a drop rule matched
+	kept := entries[:0:0]
+	for _, entry := range entries {
+		if app.Redactor.Entry(&entry) {
+			kept = append(kept, entry)
+		}
+	}
+	entries = kept
+	if len(entries) == 0 {
+		return nil
+	}
+
+	id := tenant.FromContext(ctx)
+	err := app.Tenants.Admit(id, len(entries), func() (int64, error) {
+		return app.storedEntries(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range entries {
+		entries[i].Tenant = id
+	}
+
+	if len(entries) == 1 {
+		err = app.Models.Logs.Insert(ctx, entries[0])
+	} else {
+		err = app.Models.Logs.InsertMany(ctx, entries)
+	}
+	if err != nil {
+		app.Tenants.Release(id, int64(len(entries)))
+		return err
+	}
+
+	for _, entry := range entries {
+		app.Alerts.Observe(entry)
+		app.Tail.Publish(entry)
+	}
+
+	return nil
+}
+
+// ingestOnce stores an entry with its idempotency key and reports whether it was a
+// duplicate: the store refuses the entry when the tenant already has one with the key,
+// in the same write that stores it, so concurrent retries store it once.
+func (app *Config) ingestOnce(ctx context.Context, key string, entry data.LogEntry) (bool, error) {
+	entry.IdempotencyKey = key
+	err := app.ingest(ctx, entry)
+	if errors.Is(err, data.ErrDuplicate) {
+		return true, nil
+	}
+	return false, err
+}
+
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string            `json:"name"`
+	Data       string            `json:"data"`
+	Severity   string            `json:"severity,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// IdempotencyKey may also be sent in the Idempotency-Key header.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
@@ -17,13 +83,30 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	// insert data
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Name:       requestPayload.Name,
+		Data:       requestPayload.Data,
+		Severity:   requestPayload.Severity,
+		Attributes: requestPayload.Attributes,
 	}
 
-	err := app.Models.LogEntry.Insert(event)
+	event.CreatedAt = time.Now()
+
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = requestPayload.IdempotencyKey
+	}
+
+	duplicate, err := app.ingestOnce(r.Context(), key, event)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.ingestError(w, err)
+		return
+	}
+
+	if duplicate {
+		app.writeJSON(w, http.StatusOK, jsonResponse{
+			Error:   false,
+			Message: "already logged",
+		})
 		return
 	}
 
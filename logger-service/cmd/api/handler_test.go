@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"logger-service/alert"
+	"logger-service/data"
+	"logger-service/redact"
+	"logger-service/tenant"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestApp returns a service backed by the memory store, in single-tenant mode unless
+// tenants are given.
+func newTestApp(t *testing.T, tenants ...tenant.Tenant) *Config {
+	t.Helper()
+
+	registry, err := tenant.New(tenant.Config{Tenants: tenants})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor, err := redact.New(redact.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Config{
+		Models:   *data.NewMemory(),
+		Alerts:   alert.NewEngine(nil),
+		Tail:     newTailHub(),
+		Tenants:  registry,
+		Redactor: redactor,
+	}
+}
+
+// serve sends a request through the routes and returns the recorded response.
+func serve(app *Config, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	app.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+// stored returns the entries of a tenant, oldest first.
+func stored(t *testing.T, app *Config, id string) []data.LogEntry {
+	t.Helper()
+
+	var entries []data.LogEntry
+	err := app.Models.Logs.Query(context.Background(), data.LogFilter{Tenant: id}, func(entry *data.LogEntry) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) jsonResponse {
+	t.Helper()
+
+	var resp jsonResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestWriteLog(t *testing.T) {
+	app := newTestApp(t)
+
+	rec := serve(app, http.MethodPost, "/log", `{"name":"orders","data":"order placed","severity":"info"}`, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	if resp := decodeResponse(t, rec); resp.Error || resp.Message != "logged" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	entries := stored(t, app, "")
+	if len(entries) != 1 {
+		t.Fatalf("stored %d entries, want 1", len(entries))
+	}
+	got := entries[0]
+	if got.Name != "orders" || got.Data != "order placed" || got.Severity != "info" {
+		t.Errorf("stored %+v", got)
+	}
+	if got.CreatedAt.IsZero() {
+		t.Error("created_at was not set")
+	}
+}
+
+func TestWriteLogIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+	}{
+		{"header", http.Header{"Idempotency-Key": {"k1"}}, `{"name":"a","data":"x"}`},
+		{"field", nil, `{"name":"a","data":"x","idempotency_key":"k1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+
+			first := serve(app, http.MethodPost, "/log", tt.body, tt.header)
+			if first.Code != http.StatusAccepted {
+				t.Fatalf("first write: status = %d: %s", first.Code, first.Body)
+			}
+			second := serve(app, http.MethodPost, "/log", tt.body, tt.header)
+			if second.Code != http.StatusOK {
+				t.Fatalf("repeated write: status = %d, want %d: %s", second.Code, http.StatusOK, second.Body)
+			}
+			if resp := decodeResponse(t, second); resp.Message != "already logged" {
+				t.Errorf("repeated write: message = %q", resp.Message)
+			}
+
+			if n := len(stored(t, app, "")); n != 1 {
+				t.Errorf("stored %d entries, want 1", n)
+			}
+		})
+	}
+}
+
+func TestWriteLogIdempotencyKeyConcurrent(t *testing.T) {
+	app := newTestApp(t)
+
+	const retries = 20
+	codes := make(chan int, retries)
+	var wg sync.WaitGroup
+	for range retries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(app, http.MethodPost, "/log", `{"name":"a","data":"x"}`, http.Header{"Idempotency-Key": {"k1"}}).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	if count[http.StatusAccepted] != 1 || count[http.StatusOK] != retries-1 {
+		t.Errorf("statuses = %v, want one %d and %d %d", count, http.StatusAccepted, retries-1, http.StatusOK)
+	}
+	if n := len(stored(t, app, "")); n != 1 {
+		t.Errorf("stored %d entries, want 1", n)
+	}
+}
+
+// failingStore fails the first insert, as a store that is briefly unavailable.
+type failingStore struct {
+	data.LogStore
+	failed bool
+}
+
+func (f *failingStore) Insert(ctx context.Context, entry data.LogEntry) error {
+	if !f.failed {
+		f.failed = true
+		return errors.New("store unavailable")
+	}
+	return f.LogStore.Insert(ctx, entry)
+}
+
+func TestWriteLogIdempotencyKeyRetry(t *testing.T) {
+	app := newTestApp(t)
+	app.Models.Logs = &failingStore{LogStore: app.Models.Logs}
+
+	header := http.Header{"Idempotency-Key": {"k1"}}
+	if rec := serve(app, http.MethodPost, "/log", `{"name":"a","data":"x"}`, header); rec.Code == http.StatusAccepted || rec.Code == http.StatusOK {
+		t.Fatalf("failed write: status = %d", rec.Code)
+	}
+	// nothing was stored, so the retry is not a duplicate
+	if rec := serve(app, http.MethodPost, "/log", `{"name":"a","data":"x"}`, header); rec.Code != http.StatusAccepted {
+		t.Fatalf("retry: status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	if n := len(stored(t, app, "")); n != 1 {
+		t.Errorf("stored %d entries, want 1", n)
+	}
+}
+
+func TestWriteLogTenant(t *testing.T) {
+	app := newTestApp(t,
+		tenant.Tenant{ID: "payments", Keys: []string{"pay-key"}},
+		tenant.Tenant{ID: "orders", Keys: []string{"order-key"}},
+	)
+
+	rec := serve(app, http.MethodPost, "/log", `{"name":"a","data":"x"}`, http.Header{"X-Api-Key": {"pay-key"}})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if n := len(stored(t, app, "payments")); n != 1 {
+		t.Errorf("payments has %d entries, want 1", n)
+	}
+	if n := len(stored(t, app, "orders")); n != 0 {
+		t.Errorf("orders has %d entries, want 0", n)
+	}
+
+	rec = serve(app, http.MethodPost, "/log", `{"name":"a","data":"x"}`, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+// insertAt stores entries named name at the given offsets from base.
+func insertAt(t *testing.T, app *Config, tenantID, name string, base time.Time, offsets ...time.Duration) {
+	t.Helper()
+
+	for _, offset := range offsets {
+		entry := data.LogEntry{Tenant: tenantID, Name: name, Data: offset.String(), CreatedAt: base.Add(offset)}
+		if err := app.Models.Logs.Insert(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExportLogs(t *testing.T) {
+	app := newTestApp(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// inserted out of order: the export is oldest first
+	insertAt(t, app, "", "orders", base, 3*time.Minute, time.Minute, 2*time.Minute)
+	insertAt(t, app, "", "payments", base, 90*time.Second)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"all", "", []string{"1m0s", "1m30s", "2m0s", "3m0s"}},
+		{"name", "name=orders", []string{"1m0s", "2m0s", "3m0s"}},
+		{"range", "from=2026-01-01T00:01:30Z&to=2026-01-01T00:03:00Z", []string{"1m30s", "2m0s"}},
+		{"limit", "limit=2", []string{"1m0s", "1m30s"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(app, http.MethodGet, "/logs/export?format=ndjson&fields=name,data&"+tt.query, "", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("content type = %q", ct)
+			}
+
+			var got []string
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				var row map[string]any
+				if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+					t.Fatalf("line %q: %v", scanner.Text(), err)
+				}
+				if _, ok := row["severity"]; ok {
+					t.Errorf("row has an unselected column: %v", row)
+				}
+				got = append(got, row["data"].(string))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("exported %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportLogsInvalid(t *testing.T) {
+	app := newTestApp(t)
+
+	for _, query := range []string{"format=xml", "fields=password", "from=yesterday", "limit=-1"} {
+		rec := serve(app, http.MethodGet, "/logs/export?"+query, "", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLogStats(t *testing.T) {
+	app := newTestApp(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	insertAt(t, app, "", "orders", base, time.Minute, 2*time.Minute, 11*time.Minute)
+	insertAt(t, app, "", "payments", base, 3*time.Minute)
+
+	rec := serve(app, http.MethodGet, "/logs/stats?group_by=name&bucket=5m&from=2026-01-01T00:00:00Z&to=2026-01-01T00:20:00Z", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Data data.Stats `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	stats := resp.Data
+
+	if len(stats.Buckets) != 4 {
+		t.Fatalf("got %d buckets, want 4: %v", len(stats.Buckets), stats.Buckets)
+	}
+	if len(stats.Series) != 2 {
+		t.Fatalf("got %d series, want 2", len(stats.Series))
+	}
+	orders := stats.Series[0]
+	if orders.Label != "orders" || orders.Total != 3 || !slices.Equal(orders.Values, []int64{2, 0, 1, 0}) {
+		t.Errorf("orders series = %+v", orders)
+	}
+	payments := stats.Series[1]
+	if payments.Label != "payments" || payments.Total != 1 || !slices.Equal(payments.Values, []int64{1, 0, 0, 0}) {
+		t.Errorf("payments series = %+v", payments)
+	}
+
+	rec = serve(app, http.MethodGet, "/logs/stats?group_by=name&top=1", "", nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data.Series) != 1 || resp.Data.Series[0].Label != "orders" {
+		t.Errorf("top=1 returned %+v", resp.Data.Series)
+	}
+}
+
+func TestLogStatsSeverity(t *testing.T) {
+	app := newTestApp(t)
+	base := time.Now().Add(-time.Hour)
+	for _, entry := range []data.LogEntry{
+		{Name: "auth", Data: "wrong password", Severity: "ERROR", CreatedAt: base},
+		{Name: "auth", Data: "login", Severity: "INFO", CreatedAt: base},
+		{Name: "mail", Data: "bounced", Severity: "ERROR", CreatedAt: base},
+		{Name: "auth", Data: "wrong password", Severity: "ERROR", CreatedAt: base},
+	} {
+		if err := app.Models.Logs.Insert(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the error count per service of the documentation
+	rec := serve(app, http.MethodGet, "/logs/stats?severity=ERROR&group_by=name&since=24h", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data data.Stats `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	totals := map[string]int64{}
+	for _, series := range resp.Data.Series {
+		totals[series.Label] = series.Total
+	}
+	if len(totals) != 2 || totals["auth"] != 2 || totals["mail"] != 1 {
+		t.Errorf("errors per service = %v, want auth 2 and mail 1", totals)
+	}
+}
+
+func TestLogStatsInvalid(t *testing.T) {
+	app := newTestApp(t)
+
+	for _, query := range []string{"top=0", "top=-1", "group_by=password", "metric=sum", "metric=distinct", "bucket=1ms", "since=-1h"} {
+		rec := serve(app, http.MethodGet, "/logs/stats?"+query, "", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
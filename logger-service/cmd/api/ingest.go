@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"logger-service/data"
+	"logger-service/ingest"
+	"logger-service/tenant"
+	"os"
+)
+
+// ingestListeners are the optional receivers for non-Go emitters. Each is enabled by
+// setting its <PREFIX>_ADDR variable; <PREFIX>_NAME sets the log name used when a
+// message carries none and <PREFIX>_API_KEY the tenant its messages are stored for.
+var ingestListeners = []struct {
+	prefix string
+	serve  func(ingest.Listener) error
+}{
+	{"SYSLOG_UDP", ingest.ServeSyslogUDP},
+	{"SYSLOG_TCP", ingest.ServeSyslogTCP},
+	{"OTLP_HTTP", ingest.ServeOTLPHTTP},
+	{"OTLP_GRPC", ingest.ServeOTLPGRPC},
+}
+
+// serveIngest starts the configured syslog and OTLP listeners.
+func (app *Config) serveIngest() {
+	for _, l := range ingestListeners {
+		addr := os.Getenv(l.prefix + "_ADDR")
+		if addr == "" {
+			continue
+		}
+
+		id, err := app.Tenants.Resolve(os.Getenv(l.prefix + "_API_KEY"))
+		if err != nil {
+			log.Fatalf("%s listener: %v", l.prefix, err)
+		}
+
+		listener := ingest.Listener{
+			Addr:     addr,
+			Name:     os.Getenv(l.prefix + "_NAME"),
+			MaxBatch: app.Tenants.MaxBatch(id),
+			Sink: func(ctx context.Context, entries ...data.LogEntry) error {
+				return app.ingest(tenant.NewContext(ctx, id), entries...)
+			},
+		}
+		if listener.Name == "" {
+			listener.Name = "unknown"
+		}
+
+		go func() {
+			if err := l.serve(listener); err != nil {
+				log.Fatalf("%s listener failed: %v", l.prefix, err)
+			}
+		}()
+	}
+}
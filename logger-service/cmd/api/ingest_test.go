@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"logger-service/data"
+	"logger-service/logs"
+	"logger-service/tenant"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIngestBatchTooLarge(t *testing.T) {
+	app := newTestApp(t, tenant.Tenant{ID: "otel", Keys: []string{"k"}, Quota: tenant.Quota{Rate: 1000, Burst: 10}})
+	ctx := tenant.NewContext(context.Background(), "otel")
+
+	entries := make([]data.LogEntry, 11)
+	for i := range entries {
+		entries[i] = data.LogEntry{Name: "a", Data: "x"}
+	}
+
+	if err := app.ingest(ctx, entries...); !errors.Is(err, tenant.ErrBatchTooLarge) {
+		t.Fatalf("err = %v, want %v", err, tenant.ErrBatchTooLarge)
+	}
+	// the refused batch took no tokens, so a full burst still fits
+	if err := app.ingest(ctx, entries[:10]...); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(stored(t, app, "otel")); n != 10 {
+		t.Errorf("stored %d entries, want 10", n)
+	}
+}
+
+func TestIngestErrorStatus(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{tenant.ErrUnauthorized, http.StatusUnauthorized},
+		{tenant.ErrBatchTooLarge, http.StatusRequestEntityTooLarge},
+		{tenant.ErrRateLimited, http.StatusTooManyRequests},
+		{tenant.ErrStorageQuota, http.StatusTooManyRequests},
+		{errors.New("store down"), http.StatusBadRequest},
+	} {
+		app := newTestApp(t)
+		rec := httptest.NewRecorder()
+		app.ingestError(rec, tt.err)
+		if rec.Code != tt.want {
+			t.Errorf("%v: status = %d, want %d", tt.err, rec.Code, tt.want)
+		}
+	}
+}
+
+// TestIngestRedacts writes entries full of sensitive values through every write path and
+// checks that none of them reaches the store.
+func TestIngestRedacts(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	secrets := []string{"jane@example.com", "4111111111111111", "hunter2", "s3cr3t-token"}
+	name := "signup jane@example.com"
+	text := "card 4111111111111111 password=hunter2"
+	attrs := map[string]string{"user": "jane@example.com", "api_key": "s3cr3t-token", "note": "pwd=hunter2"}
+
+	body, err := json.Marshal(JSONPayload{Name: name, Data: text, Attributes: attrs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(app, http.MethodPost, "/log", string(body), nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("http: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	grpcSrv := &logServer{app: app}
+	if _, err := grpcSrv.Write(ctx, &logs.WriteRequest{Name: name, Data: text, Attributes: attrs}); err != nil {
+		t.Fatalf("grpc: %v", err)
+	}
+
+	var reply string
+	if err := (&RPCServer{app: app}).LogInfo(RPCPayload{Name: name, Data: text}, &reply); err != nil {
+		t.Fatalf("rpc: %v", err)
+	}
+
+	amqpBody, err := json.Marshal(AMQPPayload{JSONPayload: JSONPayload{Name: name, Data: text, Attributes: attrs}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.handleAMQPRPC(ctx, "log.write", amqpBody); err != nil {
+		t.Fatalf("amqp: %v", err)
+	}
+
+	// the syslog and OTLP listeners store through the same sink
+	if err := app.ingest(ctx, data.LogEntry{Name: name, Data: text, Attributes: attrs}); err != nil {
+		t.Fatalf("listener: %v", err)
+	}
+
+	entries := stored(t, app, "")
+	if len(entries) != 5 {
+		t.Fatalf("stored %d entries, want 5", len(entries))
+	}
+	dump, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range secrets {
+		if strings.Contains(string(dump), secret) {
+			t.Errorf("%q was stored: %s", secret, dump)
+		}
+	}
+	// the caller's attributes are left alone
+	if attrs["api_key"] != "s3cr3t-token" {
+		t.Error("the request attributes were modified")
+	}
+}
@@ -2,52 +2,247 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
+	"logger-service/alert"
 	"logger-service/data"
+	"logger-service/redact"
+	"logger-service/tenant"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 var webPort = ":6001"
-var mongoUrl string
-var client *mongo.Client
+var grpcPort = ":50001"
+var rpcPort = ":5001"
 
 type Config struct {
-	Models data.Models
+	Models   data.Models
+	Alerts   *alert.Engine
+	Tail     *tailHub
+	Tenants  *tenant.Registry
+	AdminKey string
+	Redactor *redact.Redactor
 }
 
 func main() {
-	mongoClinet, err := ConnectMongo()
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+
+	tenants, err := loadTenants()
 	if err != nil {
 		log.Panic(err)
 	}
-	client = mongoClinet
 
-	fmt.Println("MongoDB connected.")
-	defer func() {
-		if err := client.Disconnect(context.TODO()); err != nil {
-			panic(err)
-		}
-		fmt.Println("MongoDB disconnected.")
-	}()
+	redactor, err := newRedactor()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	models, closeStore, err := openModels()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer closeStore()
 
 	// Server
 	app := Config{
-		Models: *data.New(client),
+		Models:   *models,
+		Alerts:   newAlertEngine(),
+		Tail:     newTailHub(),
+		Tenants:  tenants,
+		AdminKey: os.Getenv("ADMIN_API_KEY"),
+		Redactor: redactor,
 	}
+	app.reloadRules(context.Background())
+
+	grpcServer := app.newGRPCServer()
+	go func() {
+		if err := serveGRPC(grpcServer, grpcPort); err != nil {
+			log.Fatal("gRPC server failed: ", err)
+		}
+	}()
+
+	go func() {
+		if err := app.serveRPC(rpcPort); err != nil {
+			log.Fatal("RPC server failed: ", err)
+		}
+	}()
+
+	app.serveIngest()
+	app.serveAMQPRPC()
+
 	srv := &http.Server{
 		Addr:    webPort,
 		Handler: app.routes(),
 	}
 
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	fmt.Println("Shutdown signal received")
+
+	// Graceful shutdown with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("HTTP server forced to shutdown:", err)
+	}
+	stopGRPC(ctx, grpcServer)
+
+	fmt.Println("Server shut down gracefully")
+}
+
+// openModels opens the storage backend selected by LOG_STORE and returns it with a
+// function that releases it:
+//
+//	mongo     MongoDB at MONGODB_URI (default)
+//	postgres  PostgreSQL at POSTGRES_DSN
+//	file      embedded segment files in LOG_STORE_PATH (default ./logdata)
+//	memory    process memory, nothing is persisted
+func openModels() (*data.Models, func(), error) {
+	switch store := os.Getenv("LOG_STORE"); store {
+	case "memory":
+		log.Println("Using in-memory log store.")
+		return data.NewMemory(), func() {}, nil
+	case "file":
+		dir := os.Getenv("LOG_STORE_PATH")
+		if dir == "" {
+			dir = "./logdata"
+		}
+
+		models, logs, err := data.NewFile(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Println("Using file log store in", dir)
+
+		closeStore := func() {
+			if err := logs.Close(); err != nil {
+				log.Println("Error closing file store:", err)
+			}
+		}
+		return models, closeStore, nil
+	case "postgres":
+		db, err := connectPostgres(os.Getenv("POSTGRES_DSN"))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		models, err := data.NewPostgres(context.Background(), db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return models, func() { db.Close() }, nil
+	case "", "mongo":
+		client, err := ConnectMongo()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		closeStore := func() {
+			if err := client.Disconnect(context.TODO()); err != nil {
+				log.Println("Error disconnecting MongoDB:", err)
+				return
+			}
+			fmt.Println("MongoDB disconnected.")
+		}
+		return data.New(client), closeStore, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown LOG_STORE %q", store)
+	}
+}
+
+// loadTenants reads the tenants file named by TENANTS_FILE. Without one the service runs
+// in single-tenant mode and accepts every caller.
+func loadTenants() (*tenant.Registry, error) {
+	path := os.Getenv("TENANTS_FILE")
+	if path == "" {
+		return tenant.Single(), nil
+	}
+
+	tenants, err := tenant.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Loaded tenants from", path)
+	return tenants, nil
+}
+
+// newRedactor builds the redaction stage from the REDACT_CONFIG file, or from the
+// built-in defaults when it is not set.
+func newRedactor() (*redact.Redactor, error) {
+	cfg := redact.DefaultConfig()
+	if path := os.Getenv("REDACT_CONFIG"); path != "" {
+		var err error
+		if cfg, err = redact.LoadConfig(path); err != nil {
+			return nil, err
+		}
+		log.Println("Loaded redaction rules from", path)
+	}
+	return redact.New(cfg)
+}
+
+func newAlertEngine() *alert.Engine {
+	mailUrl := os.Getenv("MAIL_SERVICE_URL")
+	if mailUrl == "" {
+		mailUrl = "http://mail-service:6002"
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	return alert.NewEngine(map[string]alert.Notifier{
+		data.NotifyMail:    alert.NewMailNotifier(mailUrl, httpClient),
+		data.NotifyWebhook: alert.NewWebhookNotifier(httpClient, strings.Split(os.Getenv("WEBHOOK_ALLOWED_HOSTS"), ",")),
+	})
+}
+
+func connectPostgres(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	maxAttempts := 10
+
+	for i := 1; i <= maxAttempts; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+
+		if err == nil {
+			fmt.Println("Connected to PostgreSQL")
+			db.SetMaxOpenConns(25)
+			db.SetMaxIdleConns(10)
+			db.SetConnMaxLifetime(5 * time.Minute)
+			return db, nil
+		}
 
+		fmt.Printf("PostgreSQL not ready... retrying (%d/%d)\n", i, maxAttempts)
+		time.Sleep(2 * time.Second)
+	}
+
+	db.Close()
+	return nil, fmt.Errorf("could not connect to postgres after %d attempts: %v", maxAttempts, err)
 }
 
 func ConnectMongo() (*mongo.Client, error) {
@@ -62,7 +257,12 @@ func ConnectMongo() (*mongo.Client, error) {
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 
-		client, err = mongo.Connect(options.Client().ApplyURI(mongoUrl))
+		// ids are exposed as plain strings on LogEntry, so decode ObjectIDs as hex
+		opts := options.Client().
+			ApplyURI(mongoUrl).
+			SetBSONOptions(&options.BSONOptions{ObjectIDAsHexString: true})
+
+		client, err = mongo.Connect(opts)
 		if err == nil {
 			err = client.Ping(ctx, nil)
 		}
@@ -16,14 +16,36 @@ func (a *Config) routes() http.Handler {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Content-Type", "Authorization", "X-CSRF-TOKEN"},
+		AllowedHeaders:   []string{"Accept", "Content-Type", "Authorization", "X-API-Key", "X-CSRF-TOKEN"},
 		AllowCredentials: true,
 	}))
 	r.Use(middleware.Heartbeat("/health"))
 	r.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "Hello from logger server")
 	})
-	r.Post("/log", a.WriteLog)
+
+	r.Group(func(r chi.Router) {
+		r.Use(a.authenticate)
+
+		r.Post("/log", a.WriteLog)
+		r.Get("/logs/export", a.ExportLogs)
+		r.Get("/logs/stats", a.LogStats)
+
+		r.Route("/rules", func(r chi.Router) {
+			r.Get("/", a.ListRules)
+			r.Post("/", a.CreateRule)
+			r.Get("/{id}", a.GetRule)
+			r.Put("/{id}", a.UpdateRule)
+			r.Delete("/{id}", a.DeleteRule)
+		})
+	})
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(a.requireAdmin)
+
+		r.Get("/tenants", a.ListTenants)
+		r.Get("/tenants/usage", a.TenantUsage)
+	})
 
 	return r
 
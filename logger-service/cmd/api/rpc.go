@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"logger-service/data"
+	"logger-service/tenant"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// RPCServer is registered with net/rpc; its exported methods are callable as
+// "RPCServer.<Method>" by Go clients using rpc.Dial.
+type RPCServer struct {
+	app *Config
+}
+
+// RPCPayload is the argument of RPCServer.LogInfo. APIKey identifies the caller's tenant.
+type RPCPayload struct {
+	Name   string
+	Data   string
+	APIKey string
+}
+
+// LogInfo stores a log entry and replies with a confirmation message.
+func (r *RPCServer) LogInfo(payload RPCPayload, reply *string) error {
+	id, err := r.app.Tenants.Resolve(payload.APIKey)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(tenant.NewContext(context.Background(), id), 5*time.Second)
+	defer cancel()
+
+	err = r.app.ingest(ctx, data.LogEntry{
+		Name:      payload.Name,
+		Data:      payload.Data,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Println("Error writing log via RPC:", err)
+		return err
+	}
+
+	*reply = "Processed payload via RPC: " + payload.Name
+	return nil
+}
+
+func (app *Config) serveRPC(addr string) error {
+	srv := rpc.NewServer()
+	if err := srv.Register(&RPCServer{app: app}); err != nil {
+		return err
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+
+	log.Println("RPC server listening on", addr)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Println("RPC accept error:", err)
+			continue
+		}
+		go srv.ServeConn(conn)
+	}
+}
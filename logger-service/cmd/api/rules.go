@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"logger-service/data"
+	"logger-service/tenant"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (app *Config) ListRules(w http.ResponseWriter, r *http.Request) {
+	all, err := app.Models.Rules.All(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	id := tenant.FromContext(r.Context())
+	rules := []data.Rule{}
+	for _, rule := range all {
+		if rule.Tenant == id {
+			rules = append(rules, rule)
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "rules",
+		Data:    rules,
+	})
+}
+
+func (app *Config) GetRule(w http.ResponseWriter, r *http.Request) {
+	rule, err := app.tenantRule(r)
+	if err != nil {
+		app.ruleError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "rule",
+		Data:    rule,
+	})
+}
+
+func (app *Config) CreateRule(w http.ResponseWriter, r *http.Request) {
+	var rule data.Rule
+	if err := app.readJSON(w, r, &rule); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	rule.Tenant = tenant.FromContext(r.Context())
+
+	if err := rule.Validate(); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if err := app.Alerts.ValidateTarget(rule); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	id, err := app.Models.Rules.Insert(r.Context(), rule)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	rule.ID = id
+	app.reloadRules(r.Context())
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error:   false,
+		Message: "rule created",
+		Data:    rule,
+	})
+}
+
+func (app *Config) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	existing, err := app.tenantRule(r)
+	if err != nil {
+		app.ruleError(w, err)
+		return
+	}
+
+	var rule data.Rule
+	if err := app.readJSON(w, r, &rule); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	rule.ID = existing.ID
+	rule.Tenant = existing.Tenant
+	rule.CreatedAt = existing.CreatedAt
+
+	if err := rule.Validate(); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if err := app.Alerts.ValidateTarget(rule); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if err := app.Models.Rules.Update(r.Context(), rule); err != nil {
+		app.ruleError(w, err)
+		return
+	}
+	app.reloadRules(r.Context())
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "rule updated",
+		Data:    rule,
+	})
+}
+
+func (app *Config) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	rule, err := app.tenantRule(r)
+	if err != nil {
+		app.ruleError(w, err)
+		return
+	}
+
+	if err := app.Models.Rules.Delete(r.Context(), rule.ID); err != nil {
+		app.ruleError(w, err)
+		return
+	}
+	app.reloadRules(r.Context())
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "rule deleted",
+	})
+}
+
+// tenantRule loads the rule named in the URL, hiding the rules of other tenants.
+func (app *Config) tenantRule(r *http.Request) (*data.Rule, error) {
+	rule, err := app.Models.Rules.GetOne(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, err
+	}
+	if rule.Tenant != tenant.FromContext(r.Context()) {
+		return nil, data.ErrRuleNotFound
+	}
+	return rule, nil
+}
+
+func (app *Config) ruleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, data.ErrRuleNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
+
+// reloadRules pushes the stored rules into the alerting engine.
+func (app *Config) reloadRules(ctx context.Context) {
+	rules, err := app.Models.Rules.All(ctx)
+	if err != nil {
+		log.Println("Error loading alert rules:", err)
+		return
+	}
+	app.Alerts.Load(rules)
+}
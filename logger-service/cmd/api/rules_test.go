@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"logger-service/alert"
+	"logger-service/data"
+	"logger-service/tenant"
+)
+
+// notified records the alerts the engine delivers.
+type notified chan alert.Alert
+
+func (n notified) Notify(ctx context.Context, a alert.Alert) error {
+	n <- a
+	return nil
+}
+
+const authErrorsRule = `{"name":"auth errors","enabled":true,"log_name":"auth","severity":"ERROR",
+	"threshold":2,"window_seconds":300,"notify":{"type":"mail","to":"ops@example.com"}}`
+
+func createRule(t *testing.T, app *Config, body string, header http.Header) data.Rule {
+	t.Helper()
+
+	rec := serve(app, http.MethodPost, "/rules", body, header)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create rule: status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data data.Rule `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+func TestRulesFire(t *testing.T) {
+	app := newTestApp(t)
+	alerts := make(notified, 1)
+	app.Alerts = alert.NewEngine(map[string]alert.Notifier{data.NotifyMail: alerts})
+
+	rule := createRule(t, app, authErrorsRule, nil)
+	if rule.ID == "" || rule.Severity != "ERROR" {
+		t.Fatalf("created %+v", rule)
+	}
+
+	post := func(body string) {
+		if rec := serve(app, http.MethodPost, "/log", body, nil); rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+	post(`{"name":"auth","data":"x","severity":"INFO"}`)
+	post(`{"name":"mail","data":"x","severity":"ERROR"}`)
+	post(`{"name":"auth","data":"x","severity":"ERROR"}`)
+	post(`{"name":"auth","data":"x","severity":"ERROR"}`)
+	select {
+	case a := <-alerts:
+		t.Fatalf("fired at the threshold: %+v", a)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	post(`{"name":"auth","data":"x","severity":"error"}`)
+	select {
+	case a := <-alerts:
+		if a.Rule.ID != rule.ID || a.Count != 3 {
+			t.Errorf("alert = %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rule did not fire")
+	}
+}
+
+func TestRulesValidate(t *testing.T) {
+	app := newTestApp(t)
+
+	for _, body := range []string{
+		`{"name":"nothing to match","notify":{"type":"mail","to":"ops@example.com"}}`,
+		`{"name":"bad pattern","pattern":"(","notify":{"type":"mail","to":"ops@example.com"}}`,
+		`{"name":"no window","severity":"ERROR","threshold":5,"notify":{"type":"mail","to":"ops@example.com"}}`,
+		`{"name":"no recipient","severity":"ERROR","notify":{"type":"mail"}}`,
+		`{"name":"unknown target","severity":"ERROR","notify":{"type":"sms","to":"123"}}`,
+	} {
+		if rec := serve(app, http.MethodPost, "/rules", body, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	// a severity alone is enough to match on
+	createRule(t, app, `{"name":"errors","severity":"ERROR","notify":{"type":"mail","to":"ops@example.com"}}`, nil)
+}
+
+func TestRulesTenant(t *testing.T) {
+	app := newTestApp(t,
+		tenant.Tenant{ID: "payments", Keys: []string{"pay-key"}},
+		tenant.Tenant{ID: "orders", Keys: []string{"order-key"}},
+	)
+	pay := http.Header{"X-Api-Key": {"pay-key"}}
+	orders := http.Header{"X-Api-Key": {"order-key"}}
+
+	rule := createRule(t, app, authErrorsRule, pay)
+	if rule.Tenant != "payments" {
+		t.Errorf("tenant = %q, want payments", rule.Tenant)
+	}
+
+	var list struct {
+		Data []data.Rule `json:"data"`
+	}
+	json.NewDecoder(serve(app, http.MethodGet, "/rules", "", orders).Body).Decode(&list)
+	if len(list.Data) != 0 {
+		t.Errorf("another tenant lists %d rules", len(list.Data))
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if rec := serve(app, method, "/rules/"+rule.ID, "", orders); rec.Code != http.StatusNotFound {
+			t.Errorf("%s by another tenant: status = %d, want %d", method, rec.Code, http.StatusNotFound)
+		}
+	}
+	if rec := serve(app, http.MethodPut, "/rules/"+rule.ID, authErrorsRule, orders); rec.Code != http.StatusNotFound {
+		t.Errorf("update by another tenant: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	updated := `{"name":"auth warnings","enabled":true,"log_name":"auth","severity":"WARN","notify":{"type":"mail","to":"ops@example.com"}}`
+	if rec := serve(app, http.MethodPut, "/rules/"+rule.ID, updated, pay); rec.Code != http.StatusOK {
+		t.Fatalf("update: status = %d: %s", rec.Code, rec.Body)
+	}
+	stored, err := app.Models.Rules.GetOne(context.Background(), rule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Severity != "WARN" || stored.Tenant != "payments" {
+		t.Errorf("stored %+v", stored)
+	}
+
+	if rec := serve(app, http.MethodDelete, "/rules/"+rule.ID, "", pay); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d", rec.Code)
+	}
+	if rec := serve(app, http.MethodGet, "/rules/"+rule.ID, "", pay); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
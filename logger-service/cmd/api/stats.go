@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"logger-service/data"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogStats aggregates the stored logs. Query parameters:
+//
+//	group_by  comma separated fields to split the result on (name, data, severity)
+//	bucket    time bucket size, e.g. 5m or 1h
+//	metric    count (default) or distinct
+//	field     field counted by the distinct metric
+//	top       keep only the N largest series
+//	since     only consider logs from the last duration, e.g. 24h
+//
+// plus the name, severity, from and to filters of the other read endpoints.
+// "Error count per service per 5 minutes over the last day" is
+// /logs/stats?severity=ERROR&group_by=name&bucket=5m&since=24h, "top 10 log names" is
+// /logs/stats?group_by=name&top=10.
+func (app *Config) LogStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if v := q.Get("since"); v != "" {
+		since, err := time.ParseDuration(v)
+		if err != nil || since <= 0 {
+			app.errorJSON(w, errors.New("since must be a positive duration"))
+			return
+		}
+		filter.From = time.Now().Add(-since)
+	}
+
+	query := data.StatsQuery{
+		Filter: filter,
+		Metric: q.Get("metric"),
+		Field:  q.Get("field"),
+	}
+	if query.Metric == "" {
+		query.Metric = data.MetricCount
+	}
+	if v := q.Get("group_by"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			query.GroupBy = append(query.GroupBy, strings.TrimSpace(field))
+		}
+	}
+	if v := q.Get("bucket"); v != "" {
+		if query.Bucket, err = time.ParseDuration(v); err != nil {
+			app.errorJSON(w, errors.New("bucket must be a duration such as 5m"))
+			return
+		}
+	}
+	if v := q.Get("top"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit < 1 {
+			app.errorJSON(w, errors.New("top must be a positive integer"))
+			return
+		}
+	}
+
+	if err := query.Validate(); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	stats, err := app.Models.Logs.Stats(r.Context(), query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "stats",
+		Data:    stats,
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"logger-service/data"
+	"sync"
+)
+
+// tailBuffer is how many entries a slow subscriber may fall behind before entries are
+// dropped for it.
+const tailBuffer = 256
+
+// tailHub fans newly ingested entries out to live subscribers.
+type tailHub struct {
+	mu   sync.Mutex
+	subs map[chan data.LogEntry]data.LogFilter
+}
+
+func newTailHub() *tailHub {
+	return &tailHub{subs: map[chan data.LogEntry]data.LogFilter{}}
+}
+
+// Subscribe returns a channel receiving the tenant's entries with the given name (all its
+// entries when name is empty) and a function that ends the subscription.
+func (h *tailHub) Subscribe(tenant, name string) (<-chan data.LogEntry, func()) {
+	ch := make(chan data.LogEntry, tailBuffer)
+
+	h.mu.Lock()
+	h.subs[ch] = data.LogFilter{Tenant: tenant, Name: name}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Publish never blocks ingestion: subscribers whose buffer is full miss the entry.
+func (h *tailHub) Publish(entry data.LogEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch, filter := range h.subs {
+		if !filter.Matches(&entry) {
+			continue
+		}
+		select {
+		case ch <- entry:
+		default:
+			log.Println("Tail subscriber is falling behind, dropping entry")
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"logger-service/data"
+	"logger-service/tenant"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// apiKey returns the credential of a request, sent either as X-API-Key or as a bearer
+// token.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// authenticate resolves the caller's tenant and stores it in the request context, where
+// ingest and parseLogFilter pick it up.
+func (app *Config) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.Tenants.Resolve(apiKey(r))
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), id)))
+	})
+}
+
+// requireAdmin guards the admin endpoints with ADMIN_API_KEY. They are disabled when no
+// admin key is configured.
+func (app *Config) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.AdminKey == "" {
+			app.errorJSON(w, errors.New("admin API is disabled"), http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(apiKey(r)), []byte(app.AdminKey)) != 1 {
+			app.errorJSON(w, errors.New("invalid admin key"), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ingestError reports a failed write, telling quota rejections apart from store errors.
+func (app *Config) ingestError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tenant.ErrUnauthorized):
+		app.errorJSON(w, err, http.StatusUnauthorized)
+	case errors.Is(err, tenant.ErrBatchTooLarge):
+		app.errorJSON(w, err, http.StatusRequestEntityTooLarge)
+	case errors.Is(err, tenant.ErrRateLimited), errors.Is(err, tenant.ErrStorageQuota):
+		app.errorJSON(w, err, http.StatusTooManyRequests)
+	default:
+		app.errorJSON(w, err)
+	}
+}
+
+// storedEntries counts the entries a tenant has in the store.
+func (app *Config) storedEntries(ctx context.Context, id string) (int64, error) {
+	stats, err := app.Models.Logs.Stats(ctx, data.StatsQuery{
+		Filter: data.LogFilter{Tenant: id},
+		Metric: data.MetricCount,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, series := range stats.Series {
+		total += series.Total
+	}
+	return total, nil
+}
+
+// ListTenants returns the configured tenants and their quotas.
+func (app *Config) ListTenants(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "tenants",
+		Data:    app.Tenants.Tenants(),
+	})
+}
+
+// TenantUsage returns, per tenant, the entries ingested and rejected since startup and
+// the entries currently stored.
+func (app *Config) TenantUsage(w http.ResponseWriter, r *http.Request) {
+	usage := app.Tenants.Usage()
+	for i := range usage {
+		stored, err := app.storedEntries(r.Context(), usage[i].Tenant)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		usage[i].Stored = stored
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "usage",
+		Data:    usage,
+	})
+}
+
+// grpcTenant resolves the tenant from the x-api-key or authorization metadata.
+func (app *Config) grpcTenant(ctx context.Context) (context.Context, error) {
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-api-key"); len(v) > 0 {
+			key = v[0]
+		} else if v := md.Get("authorization"); len(v) > 0 {
+			key = strings.TrimPrefix(v[0], "Bearer ")
+		}
+	}
+
+	id, err := app.Tenants.Resolve(key)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return tenant.NewContext(ctx, id), nil
+}
+
+func (app *Config) unaryTenant(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := app.grpcTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// tenantStream overrides the context of a server stream.
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context { return s.ctx }
+
+func (app *Config) streamTenant(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := app.grpcTenant(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &tenantStream{ServerStream: stream, ctx: ctx})
+}
+
+// grpcIngestError maps a failed write onto a gRPC status.
+func grpcIngestError(err error) error {
+	switch {
+	case errors.Is(err, tenant.ErrUnauthorized):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, tenant.ErrBatchTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, tenant.ErrRateLimited), errors.Is(err, tenant.ErrStorageQuota):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,663 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSegmentSize = 64 << 20 // 64MB
+	maxRecordSize      = 4 << 20  // longest line accepted when reading a segment
+	indexFile          = "index.json"
+	rulesFile          = "rules.json"
+)
+
+// FileStore is an embedded single node backend. Entries are appended as JSON lines to
+// segment files in one directory; once the active segment grows past the size limit it
+// is sealed and a new one is started. index.json records the id and time range of every
+// sealed segment so queries only open the segments that can hold matching entries.
+// The idempotency keys of the stored entries are indexed in memory, loaded on open from
+// the segments that hold any.
+type FileStore struct {
+	dir         string
+	segmentSize int64
+
+	mu       sync.RWMutex
+	segments []*segmentInfo
+	active   *os.File
+	keys     idempotencyKeys
+	nextID   int64
+}
+
+// segmentInfo is the index record of one segment file.
+type segmentInfo struct {
+	Name    string    `json:"name"`
+	Count   int64     `json:"count"`
+	Size    int64     `json:"size"`
+	MinTime time.Time `json:"min_time"`
+	MaxTime time.Time `json:"max_time"`
+	LastID  int64     `json:"last_id"`
+	// Keys counts the entries with an idempotency key.
+	Keys int64 `json:"keys,omitempty"`
+}
+
+func (s *segmentInfo) add(entry *LogEntry, size int64) {
+	if s.Count == 0 || entry.CreatedAt.Before(s.MinTime) {
+		s.MinTime = entry.CreatedAt
+	}
+	if s.Count == 0 || entry.CreatedAt.After(s.MaxTime) {
+		s.MaxTime = entry.CreatedAt
+	}
+	s.Count++
+	s.Size += size
+	if entry.IdempotencyKey != "" {
+		s.Keys++
+	}
+	if id, err := strconv.ParseInt(entry.ID, 10, 64); err == nil && id > s.LastID {
+		s.LastID = id
+	}
+}
+
+// overlaps reports whether the segment may hold entries matching the filter.
+func (s *segmentInfo) overlaps(f LogFilter) bool {
+	if s.Count == 0 {
+		return false
+	}
+	if !f.From.IsZero() && s.MaxTime.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !s.MinTime.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// NewFileStore opens (or creates) a store in dir. The index of sealed segments is loaded
+// from index.json and the active segment is rescanned to recover its statistics.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		dir:         dir,
+		segmentSize: defaultSegmentSize,
+		keys:        idempotencyKeys{},
+	}
+
+	if err := f.loadIndex(); err != nil {
+		return nil, err
+	}
+	if err := f.openActive(); err != nil {
+		return nil, err
+	}
+	if err := f.loadKeys(); err != nil {
+		f.active.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// loadKeys indexes the idempotency keys of the segments holding entries with one.
+func (f *FileStore) loadKeys() error {
+	for _, s := range f.segments {
+		if s.Keys == 0 {
+			continue
+		}
+		err := scanSegment(f.path(s.Name), s.Size, func(entry *LogEntry, _ int64) error {
+			f.keys.add(entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileStore) path(name string) string {
+	return filepath.Join(f.dir, name)
+}
+
+func segmentName(n int) string {
+	return fmt.Sprintf("segment-%06d.log", n)
+}
+
+// loadIndex reads index.json, then picks up any segment file the index does not know
+// about (the active one, or segments written before a crash) by scanning it.
+func (f *FileStore) loadIndex() error {
+	b, err := os.ReadFile(f.path(indexFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &f.segments); err != nil {
+			return fmt.Errorf("reading %s: %w", indexFile, err)
+		}
+	}
+
+	known := map[string]bool{}
+	for _, s := range f.segments {
+		known[s.Name] = true
+	}
+
+	names, err := filepath.Glob(f.path("segment-*.log"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		name = filepath.Base(name)
+		if known[name] {
+			continue
+		}
+
+		info := &segmentInfo{Name: name}
+		err := scanSegment(f.path(name), -1, func(entry *LogEntry, size int64) error {
+			info.add(entry, size)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		f.segments = append(f.segments, info)
+	}
+
+	for _, s := range f.segments {
+		if s.LastID > f.nextID {
+			f.nextID = s.LastID
+		}
+	}
+
+	return nil
+}
+
+func (f *FileStore) saveIndex() error {
+	// the last segment is the active one and is rebuilt on open
+	sealed := f.segments
+	if len(sealed) > 0 {
+		sealed = sealed[:len(sealed)-1]
+	}
+
+	b, err := json.MarshalIndent(sealed, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path(indexFile + ".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(indexFile))
+}
+
+// openActive opens the last segment for appending, starting the first one if needed. A
+// torn record at its tail, left by a crash in the middle of a write, was never
+// acknowledged and is cut off, so the next record starts on a line of its own.
+func (f *FileStore) openActive() error {
+	if len(f.segments) == 0 {
+		f.segments = append(f.segments, &segmentInfo{Name: segmentName(1)})
+	}
+
+	active := f.segments[len(f.segments)-1]
+	file, err := os.OpenFile(f.path(active.Name), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	torn, err := truncateTornTail(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if torn {
+		// rebuild the statistics without the cut off record
+		info := &segmentInfo{Name: active.Name, LastID: active.LastID}
+		err := scanSegment(f.path(active.Name), -1, func(entry *LogEntry, size int64) error {
+			info.add(entry, size)
+			return nil
+		})
+		if err != nil {
+			file.Close()
+			return err
+		}
+		*active = *info
+	}
+
+	f.active = file
+	return nil
+}
+
+// truncateTornTail cuts the file after its last newline and reports whether anything was
+// cut.
+func truncateTornTail(file *os.File) (bool, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := stat.Size()
+
+	buf := make([]byte, 4096)
+	end := size
+	for end > 0 {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil {
+			return false, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+
+	if end == size {
+		return false, nil
+	}
+	log.Printf("Cutting off a torn record of %d bytes at the end of %s", size-end, filepath.Base(file.Name()))
+	return true, file.Truncate(end)
+}
+
+// roll seals the active segment and starts a new one.
+func (f *FileStore) roll() error {
+	if err := f.active.Close(); err != nil {
+		return err
+	}
+
+	f.segments = append(f.segments, &segmentInfo{Name: segmentName(len(f.segments) + 1)})
+	if err := f.saveIndex(); err != nil {
+		return err
+	}
+
+	return f.openActive()
+}
+
+func (f *FileStore) Insert(ctx context.Context, entry LogEntry) error {
+	return f.InsertMany(ctx, []LogEntry{entry})
+}
+
+func (f *FileStore) InsertMany(ctx context.Context, entries []LogEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.keys.check(entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		active := f.segments[len(f.segments)-1]
+		if active.Size >= f.segmentSize {
+			if err := f.roll(); err != nil {
+				return err
+			}
+			active = f.segments[len(f.segments)-1]
+		}
+
+		f.nextID++
+		entry.ID = strconv.FormatInt(f.nextID, 10)
+		entry.stamp()
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		if _, err := f.active.Write(line); err != nil {
+			log.Println("Error appending to segment:", err)
+			return err
+		}
+		active.add(&entry, int64(len(line)))
+		f.keys.add(&entry)
+	}
+
+	return nil
+}
+
+// snapshot returns a copy of the index entries that may hold matches. Segments are append
+// only, so reading a copy up to its recorded size needs no lock.
+func (f *FileStore) snapshot(filter LogFilter) []segmentInfo {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var segments []segmentInfo
+	for _, s := range f.segments {
+		if s.overlaps(filter) {
+			segments = append(segments, *s)
+		}
+	}
+	return segments
+}
+
+// Query returns entries oldest first. Entries are written in the order they arrive, which
+// is not creation order when the caller sets the time, so the entries of segments whose
+// time ranges overlap are sorted together; usually that is one segment at a time.
+func (f *FileStore) Query(ctx context.Context, filter LogFilter, fn func(*LogEntry) error) error {
+	var seen int64
+
+	for _, group := range overlappingSegments(f.snapshot(filter)) {
+		var entries []LogEntry
+		for _, s := range group {
+			err := scanSegment(f.path(s.Name), s.Size, func(entry *LogEntry, _ int64) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if filter.Matches(entry) {
+					entries = append(entries, *entry)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		sort.SliceStable(entries, func(i, j int) bool {
+			if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+				return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+			}
+			return entryID(&entries[i]) < entryID(&entries[j])
+		})
+
+		for i := range entries {
+			if filter.Limit > 0 && seen >= filter.Limit {
+				return nil
+			}
+			seen++
+			if err := fn(&entries[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// overlappingSegments orders segments by time and groups those whose time ranges overlap,
+// so that every entry of a group is older than the entries of the next group.
+func overlappingSegments(segments []segmentInfo) [][]segmentInfo {
+	sort.Slice(segments, func(i, j int) bool { return segments[i].MinTime.Before(segments[j].MinTime) })
+
+	var groups [][]segmentInfo
+	var groupEnd time.Time
+	for _, s := range segments {
+		if len(groups) == 0 || s.MinTime.After(groupEnd) {
+			groups = append(groups, nil)
+			groupEnd = s.MaxTime
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], s)
+		if s.MaxTime.After(groupEnd) {
+			groupEnd = s.MaxTime
+		}
+	}
+	return groups
+}
+
+func entryID(entry *LogEntry) int64 {
+	id, _ := strconv.ParseInt(entry.ID, 10, 64)
+	return id
+}
+
+func (f *FileStore) Stats(ctx context.Context, q StatsQuery) (*Stats, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	agg := newStatsAggregator(q)
+	for _, s := range f.snapshot(q.Filter) {
+		err := scanSegment(f.path(s.Name), s.Size, func(entry *LogEntry, _ int64) error {
+			agg.Add(entry)
+			return ctx.Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return agg.Stats(), nil
+}
+
+// Delete rewrites every segment holding matching entries without them.
+func (f *FileStore) Delete(ctx context.Context, filter LogFilter) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var deleted int64
+	last := len(f.segments) - 1
+
+	for i, s := range f.segments {
+		if !s.overlaps(filter) {
+			continue
+		}
+
+		if i == last {
+			if err := f.active.Close(); err != nil {
+				return deleted, err
+			}
+		}
+
+		info, removed, err := f.rewriteSegment(s, filter)
+		if i == last {
+			if err := f.openActive(); err != nil {
+				return deleted, err
+			}
+		}
+		if err != nil {
+			return deleted, err
+		}
+
+		f.segments[i] = info
+		deleted += removed
+	}
+
+	if deleted > 0 {
+		if err := f.saveIndex(); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+func (f *FileStore) rewriteSegment(s *segmentInfo, filter LogFilter) (*segmentInfo, int64, error) {
+	tmp := f.path(s.Name + ".tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(out)
+	info := &segmentInfo{Name: s.Name, LastID: s.LastID}
+	var removed int64
+	var keys []LogEntry
+
+	err = scanSegment(f.path(s.Name), -1, func(entry *LogEntry, _ int64) error {
+		if filter.Matches(entry) {
+			removed++
+			if entry.IdempotencyKey != "" {
+				keys = append(keys, LogEntry{Tenant: entry.Tenant, IdempotencyKey: entry.IdempotencyKey})
+			}
+			return nil
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		info.add(entry, int64(len(line)))
+		return nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if removed == 0 {
+		return s, 0, nil
+	}
+	if err := os.Rename(tmp, f.path(s.Name)); err != nil {
+		return nil, 0, err
+	}
+	for i := range keys {
+		f.keys.remove(&keys[i])
+	}
+
+	return info, removed, nil
+}
+
+// Close closes the active segment and writes the index.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.saveIndex(); err != nil {
+		return err
+	}
+	return f.active.Close()
+}
+
+// scanSegment decodes the JSON lines of a segment file, stopping after limit bytes when
+// limit is not negative. fn receives every entry with the size of its line.
+func scanSegment(path string, limit int64, fn func(*LogEntry, int64) error) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if limit >= 0 {
+		r = io.LimitReader(file, limit)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// a torn write at the tail of the active segment after a crash
+			log.Printf("Skipping unreadable record in %s: %v", filepath.Base(path), err)
+			continue
+		}
+
+		if err := fn(&entry, int64(len(line))+1); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// FileRuleStore keeps alerting rules in rules.json next to the log segments. The file is
+// small and rewritten on every change.
+type FileRuleStore struct {
+	*MemoryRuleStore
+	path string
+}
+
+func NewFileRuleStore(dir string) (*FileRuleStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	store := &FileRuleStore{
+		MemoryRuleStore: NewMemoryRuleStore(),
+		path:            filepath.Join(dir, rulesFile),
+	}
+
+	b, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", rulesFile, err)
+	}
+	for _, rule := range rules {
+		store.rules[rule.ID] = rule
+		if id, err := strconv.Atoi(rule.ID); err == nil && id > store.nextID {
+			store.nextID = id
+		}
+	}
+
+	return store, nil
+}
+
+func (f *FileRuleStore) save(ctx context.Context) error {
+	rules, err := f.MemoryRuleStore.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(rules, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *FileRuleStore) Insert(ctx context.Context, rule Rule) (string, error) {
+	id, err := f.MemoryRuleStore.Insert(ctx, rule)
+	if err != nil {
+		return "", err
+	}
+	return id, f.save(ctx)
+}
+
+func (f *FileRuleStore) Update(ctx context.Context, rule Rule) error {
+	if err := f.MemoryRuleStore.Update(ctx, rule); err != nil {
+		return err
+	}
+	return f.save(ctx)
+}
+
+func (f *FileRuleStore) Delete(ctx context.Context, id string) error {
+	if err := f.MemoryRuleStore.Delete(ctx, id); err != nil {
+		return err
+	}
+	return f.save(ctx)
+}
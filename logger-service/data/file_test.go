@@ -0,0 +1,140 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, store, LogEntry{Name: "a", Data: "1", CreatedAt: testBase})
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	mustInsert(t, store, LogEntry{Name: "a", Data: "2", CreatedAt: testBase.Add(time.Second)})
+
+	entries := query(t, store, LogFilter{})
+	if got := dataOf(entries); !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("got %v", got)
+	}
+	if entries[0].ID == entries[1].ID {
+		t.Errorf("the id %q was handed out twice", entries[0].ID)
+	}
+}
+
+func TestFileStoreTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, store, LogEntry{Name: "a", Data: "1", CreatedAt: testBase})
+	segment := store.path(store.segments[len(store.segments)-1].Name)
+	store.Close()
+
+	// a crash in the middle of appending the second record
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"id":"2","name":"a","data":"to`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	mustInsert(t, store, LogEntry{Name: "a", Data: "3", CreatedAt: testBase.Add(time.Second)})
+
+	if got := dataOf(query(t, store, LogFilter{})); !slices.Equal(got, []string{"1", "3"}) {
+		t.Errorf("got %v, want the torn record dropped and the next one readable", got)
+	}
+}
+
+func TestFileStoreSegments(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// roll after every entry
+	store.segmentSize = 1
+
+	// written out of order across segments
+	for _, minutes := range []int{5, 1, 3, 20, 21} {
+		mustInsert(t, store, LogEntry{Name: "a", Data: time.Duration(minutes * int(time.Minute)).String(), CreatedAt: testBase.Add(time.Duration(minutes) * time.Minute)})
+	}
+	if n := len(store.segments); n != 5 {
+		t.Fatalf("got %d segments, want 5", n)
+	}
+
+	got := dataOf(query(t, store, LogFilter{}))
+	if want := []string{"1m0s", "3m0s", "5m0s", "20m0s", "21m0s"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// only the segments overlapping the range are read
+	if n := len(store.snapshot(LogFilter{From: testBase.Add(19 * time.Minute)})); n != 2 {
+		t.Errorf("a query from 19m would read %d segments, want 2", n)
+	}
+
+	deleted, err := store.Delete(context.Background(), LogFilter{From: testBase.Add(2 * time.Minute), To: testBase.Add(6 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted %d entries, want 2", deleted)
+	}
+	if got := dataOf(query(t, store, LogFilter{})); !slices.Equal(got, []string{"1m0s", "20m0s", "21m0s"}) {
+		t.Errorf("after delete: got %v", got)
+	}
+}
+
+func TestFileStoreKeysSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// k1 ends up in a sealed segment, k2 in the active one
+	store.segmentSize = 1
+	mustInsert(t, store, LogEntry{Name: "a", Data: "1", IdempotencyKey: "k1", CreatedAt: testBase})
+	mustInsert(t, store, LogEntry{Name: "a", Data: "2", IdempotencyKey: "k2", CreatedAt: testBase})
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, key := range []string{"k1", "k2"} {
+		err := store.Insert(context.Background(), LogEntry{Name: "a", Data: "x", IdempotencyKey: key, CreatedAt: testBase})
+		if !errors.Is(err, ErrDuplicate) {
+			t.Errorf("%s after reopening: err = %v, want %v", key, err, ErrDuplicate)
+		}
+	}
+	mustInsert(t, store, LogEntry{Name: "a", Data: "3", IdempotencyKey: "k3", CreatedAt: testBase})
+}
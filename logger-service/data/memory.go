@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps log entries in a slice. It implements LogStore without any external
+// dependency, for local development and for exercising the handlers without MongoDB.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries []LogEntry
+	keys    idempotencyKeys
+	nextID  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: idempotencyKeys{}}
+}
+
+func (m *MemoryStore) Insert(ctx context.Context, entry LogEntry) error {
+	return m.InsertMany(ctx, []LogEntry{entry})
+}
+
+func (m *MemoryStore) InsertMany(ctx context.Context, entries []LogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.keys.check(entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		m.keys.add(&entry)
+		m.nextID++
+		entry.ID = strconv.Itoa(m.nextID)
+		entry.stamp()
+
+		// keep the slice in chronological order so queries can walk it front to back;
+		// entries mostly arrive in order, so this is usually an append
+		i := sort.Search(len(m.entries), func(i int) bool {
+			return m.entries[i].CreatedAt.After(entry.CreatedAt)
+		})
+		m.entries = slices.Insert(m.entries, i, entry)
+	}
+
+	return nil
+}
+
+// Query copies the matching entries before calling fn, so fn may use the store itself.
+func (m *MemoryStore) Query(ctx context.Context, filter LogFilter, fn func(*LogEntry) error) error {
+	m.mu.RLock()
+	var matches []LogEntry
+	for _, entry := range m.entries {
+		if filter.Limit > 0 && int64(len(matches)) >= filter.Limit {
+			break
+		}
+		if filter.Matches(&entry) {
+			matches = append(matches, entry)
+		}
+	}
+	m.mu.RUnlock()
+
+	for i := range matches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&matches[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) Stats(ctx context.Context, q StatsQuery) (*Stats, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	agg := newStatsAggregator(q)
+	for i := range m.entries {
+		agg.Add(&m.entries[i])
+	}
+
+	return agg.Stats(), nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, filter LogFilter) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := m.entries[:0]
+	for _, entry := range m.entries {
+		if filter.Matches(&entry) {
+			m.keys.remove(&entry)
+		} else {
+			keep = append(keep, entry)
+		}
+	}
+
+	deleted := int64(len(m.entries) - len(keep))
+	m.entries = keep
+
+	return deleted, nil
+}
+
+// idempotencyKeys indexes the idempotency keys of the stored entries by tenant, for the
+// memory and file stores.
+type idempotencyKeys map[string]struct{}
+
+func keyID(entry *LogEntry) string {
+	return entry.Tenant + "\x00" + entry.IdempotencyKey
+}
+
+// check returns ErrDuplicate when an entry of the batch reuses a stored key, or a key of
+// an earlier entry of the batch.
+func (k idempotencyKeys) check(entries []LogEntry) error {
+	batch := map[string]struct{}{}
+	for i := range entries {
+		if entries[i].IdempotencyKey == "" {
+			continue
+		}
+		id := keyID(&entries[i])
+		if _, ok := k[id]; ok {
+			return ErrDuplicate
+		}
+		if _, ok := batch[id]; ok {
+			return ErrDuplicate
+		}
+		batch[id] = struct{}{}
+	}
+	return nil
+}
+
+func (k idempotencyKeys) add(entry *LogEntry) {
+	if entry.IdempotencyKey != "" {
+		k[keyID(entry)] = struct{}{}
+	}
+}
+
+func (k idempotencyKeys) remove(entry *LogEntry) {
+	if entry.IdempotencyKey != "" {
+		delete(k, keyID(entry))
+	}
+}
+
+// MemoryRuleStore keeps alerting rules in a map.
+type MemoryRuleStore struct {
+	mu     sync.RWMutex
+	rules  map[string]Rule
+	nextID int
+}
+
+func NewMemoryRuleStore() *MemoryRuleStore {
+	return &MemoryRuleStore{rules: map[string]Rule{}}
+}
+
+func (m *MemoryRuleStore) Insert(ctx context.Context, rule Rule) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	rule.ID = strconv.Itoa(m.nextID)
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+	m.rules[rule.ID] = rule
+
+	return rule.ID, nil
+}
+
+func (m *MemoryRuleStore) All(ctx context.Context) ([]Rule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rules := make([]Rule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+
+	return rules, nil
+}
+
+func (m *MemoryRuleStore) GetOne(ctx context.Context, id string) (*Rule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rule, ok := m.rules[id]
+	if !ok {
+		return nil, ErrRuleNotFound
+	}
+	return &rule, nil
+}
+
+func (m *MemoryRuleStore) Update(ctx context.Context, rule Rule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rules[rule.ID]; !ok {
+		return ErrRuleNotFound
+	}
+	rule.UpdatedAt = time.Now()
+	m.rules[rule.ID] = rule
+
+	return nil
+}
+
+func (m *MemoryRuleStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rules[id]; !ok {
+		return ErrRuleNotFound
+	}
+	delete(m.rules, id)
+
+	return nil
+}
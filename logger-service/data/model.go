@@ -2,82 +2,117 @@ package data
 
 import (
 	"context"
-	"log"
+	"database/sql"
 	"time"
 
-	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type LogEntry struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string    `bson:"name" json:"name"`
-	Data      string    `bson:"data" json:"data"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	ID         string            `bson:"_id,omitempty" json:"id,omitempty"`
+	Tenant     string            `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Name       string            `bson:"name" json:"name"`
+	Data       string            `bson:"data" json:"data"`
+	Severity   string            `bson:"severity,omitempty" json:"severity,omitempty"`
+	Attributes map[string]string `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	// IdempotencyKey is unique per tenant; a store refuses a second entry with the same
+	// key with ErrDuplicate.
+	IdempotencyKey string    `bson:"idempotency_key,omitempty" json:"idempotency_key,omitempty"`
+	CreatedAt      time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
 }
 
-var client *mongo.Client
+// stamp fills in the timestamps of an entry that is about to be stored.
+func (l *LogEntry) stamp() {
+	if l.CreatedAt.IsZero() {
+		l.CreatedAt = time.Now()
+	}
+	if l.UpdatedAt.IsZero() {
+		l.UpdatedAt = l.CreatedAt
+	}
+}
 
-type Models struct {
-	LogEntry *LogEntry
+// LogFilter narrows down the log entries returned by a query. Zero values are ignored,
+// except for Tenant: a query only ever sees the entries of one tenant, and the empty
+// tenant is the one used when multi-tenancy is not configured.
+type LogFilter struct {
+	Tenant string
+	Name   string
+	// Severity matches the severity exactly, as it was sent.
+	Severity string
+	From     time.Time
+	To       time.Time
+	Limit    int64
 }
 
-func New(mongo *mongo.Client) *Models {
-	client = mongo
-	return &Models{
-		LogEntry: &LogEntry{},
+// Matches reports whether the entry passes the filter, ignoring Limit.
+func (f LogFilter) Matches(entry *LogEntry) bool {
+	if entry.Tenant != f.Tenant {
+		return false
+	}
+	if f.Name != "" && entry.Name != f.Name {
+		return false
+	}
+	if f.Severity != "" && entry.Severity != f.Severity {
+		return false
+	}
+	if !f.From.IsZero() && entry.CreatedAt.Before(f.From) {
+		return false
 	}
+	if !f.To.IsZero() && !entry.CreatedAt.Before(f.To) {
+		return false
+	}
+	return true
 }
 
-func (l *LogEntry) Insert(entry LogEntry) error {
-	collection := client.Database("logs").Collection("logs")
+type Models struct {
+	Logs  LogStore
+	Rules RuleStore
+}
 
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
-		Name:      entry.Name,
-		Data:      entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
-	if err != nil {
-		log.Println("Error inserting into logs:", err)
-		return err
+// New returns models backed by the "logs" MongoDB database.
+func New(client *mongo.Client) *Models {
+	db := client.Database("logs")
+	return &Models{
+		Logs:  NewMongoStore(db),
+		Rules: NewMongoRuleStore(db),
 	}
-	return nil
-
 }
 
-func (l *LogEntry) All() ([]*LogEntry, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
-	defer cancel()
-
-	collection := client.Database("logs").Collection("logs")
+// NewMemory returns models that keep everything in process memory. Nothing survives a
+// restart; it is meant for tests and local development.
+func NewMemory() *Models {
+	return &Models{
+		Logs:  NewMemoryStore(),
+		Rules: NewMemoryRuleStore(),
+	}
+}
 
-	opts := options.Find()
-	opts.SetSort(bson.D{{Key: "created_at", Value: -1}})
-	cursor, err := collection.Find(ctx, bson.D{}, opts)
+// NewPostgres returns models backed by PostgreSQL, creating the schema if needed.
+func NewPostgres(ctx context.Context, db *sql.DB) (*Models, error) {
+	logs, err := NewPostgresStore(ctx, db)
 	if err != nil {
-		log.Println("Finding all logs error:", err)
 		return nil, err
 	}
-	defer cursor.Close(ctx)
-
-	var logs []*LogEntry
-
-	for cursor.Next(ctx) {
-		var logItem *LogEntry
-		err := cursor.Decode(&logItem)
-
-		if err != nil {
-			log.Println("Error decoding log item.")
-			return nil, err
-		} else {
-			logs = append(logs, logItem)
-		}
+	return &Models{
+		Logs:  logs,
+		Rules: NewPostgresRuleStore(db),
+	}, nil
+}
 
+// NewFile returns models backed by the embedded file store in dir, along with the log
+// store so it can be closed on shutdown.
+func NewFile(dir string) (*Models, *FileStore, error) {
+	logs, err := NewFileStore(dir)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	return logs, nil
-
+	rules, err := NewFileRuleStore(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Models{
+		Logs:  logs,
+		Rules: rules,
+	}, logs, nil
 }
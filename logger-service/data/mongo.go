@@ -0,0 +1,394 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MongoStore keeps log entries in the "logs" collection of a MongoDB database. A unique
+// index on the tenant and the idempotency key of the entries that have one refuses
+// duplicates.
+type MongoStore struct {
+	logs *mongo.Collection
+
+	indexMu sync.Mutex
+	indexed bool
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{
+		logs: db.Collection("logs"),
+	}
+}
+
+// ensureIndex creates the unique idempotency key index before the first write. Without
+// it duplicates would go unnoticed, so writes fail until it exists.
+func (m *MongoStore) ensureIndex(ctx context.Context) error {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	if m.indexed {
+		return nil
+	}
+	_, err := m.logs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "idempotency_key", Value: 1}},
+		Options: options.Index().
+			SetName("tenant_idempotency_key").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "idempotency_key", Value: bson.D{{Key: "$type", Value: "string"}}}}),
+	})
+	if err != nil {
+		log.Println("Error creating idempotency key index:", err)
+		return fmt.Errorf("creating idempotency key index: %w", err)
+	}
+	m.indexed = true
+	return nil
+}
+
+func (m *MongoStore) Insert(ctx context.Context, entry LogEntry) error {
+	if err := m.ensureIndex(ctx); err != nil {
+		return err
+	}
+
+	entry.ID = ""
+	entry.stamp()
+
+	_, err := m.logs.InsertOne(ctx, entry)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		log.Println("Error inserting into logs:", err)
+		return err
+	}
+	return nil
+}
+
+func (m *MongoStore) InsertMany(ctx context.Context, entries []LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := m.ensureIndex(ctx); err != nil {
+		return err
+	}
+
+	docs := make([]any, len(entries))
+	for i, entry := range entries {
+		entry.ID = ""
+		entry.stamp()
+		docs[i] = entry
+	}
+
+	_, err := m.logs.InsertMany(ctx, docs)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		log.Println("Error inserting batch into logs:", err)
+		return err
+	}
+	return nil
+}
+
+// Query decodes entries one at a time from the cursor, so the result set is never held
+// in memory as a whole.
+func (m *MongoStore) Query(ctx context.Context, filter LogFilter, fn func(*LogEntry) error) error {
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "created_at", Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+
+	cursor, err := m.logs.Find(ctx, mongoFilter(filter), opts)
+	if err != nil {
+		log.Println("Finding logs error:", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry LogEntry
+		if err := cursor.Decode(&entry); err != nil {
+			log.Println("Error decoding log item.")
+			return err
+		}
+
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (m *MongoStore) Stats(ctx context.Context, q StatsQuery) (*Stats, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.statsRows(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []statsRow
+	if q.needsTotals() {
+		unbucketed := q
+		unbucketed.Bucket = 0
+		if totals, err = m.statsRows(ctx, unbucketed); err != nil {
+			return nil, err
+		}
+	}
+
+	return q.shape(rows, totals), nil
+}
+
+func (m *MongoStore) statsRows(ctx context.Context, q StatsQuery) ([]statsRow, error) {
+	cursor, err := m.logs.Aggregate(ctx, statsPipeline(q))
+	if err != nil {
+		log.Println("Aggregating logs error:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []bson.M
+	if err := cursor.All(ctx, &rows); err != nil {
+		log.Println("Error decoding stats rows.")
+		return nil, err
+	}
+
+	stats := make([]statsRow, len(rows))
+	for i, row := range rows {
+		id, _ := row["_id"].(bson.M)
+		stats[i].Group = map[string]string{}
+		for _, field := range q.GroupBy {
+			stats[i].Group[field] = toString(id[field])
+		}
+		stats[i].Bucket = bucketTime(id["bucket"])
+		stats[i].Value = toInt64(row["value"])
+	}
+
+	return stats, nil
+}
+
+func (m *MongoStore) Delete(ctx context.Context, filter LogFilter) (int64, error) {
+	res, err := m.logs.DeleteMany(ctx, mongoFilter(filter))
+	if err != nil {
+		log.Println("Error deleting logs:", err)
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func mongoFilter(f LogFilter) bson.D {
+	// entries of the empty tenant are stored without the field, which null matches
+	var tenant any
+	if f.Tenant != "" {
+		tenant = f.Tenant
+	}
+	query := bson.D{{Key: "tenant", Value: tenant}}
+	if f.Name != "" {
+		query = append(query, bson.E{Key: "name", Value: f.Name})
+	}
+	if f.Severity != "" {
+		query = append(query, bson.E{Key: "severity", Value: f.Severity})
+	}
+
+	createdAt := bson.D{}
+	if !f.From.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: f.From})
+	}
+	if !f.To.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$lt", Value: f.To})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	return query
+}
+
+// statsFieldPaths maps the StatsFields onto document paths.
+var statsFieldPaths = map[string]string{
+	"name":     "$name",
+	"data":     "$data",
+	"severity": "$severity",
+}
+
+func statsPipeline(q StatsQuery) mongo.Pipeline {
+	groupID := bson.D{}
+	for _, field := range q.GroupBy {
+		groupID = append(groupID, bson.E{Key: field, Value: statsFieldPaths[field]})
+	}
+	if q.Bucket > 0 {
+		// created_at - (created_at - origin) mod bucket, in milliseconds: $dateTrunc
+		// aligns bins on its own reference date instead of StatsOrigin
+		sinceOrigin := bson.D{{Key: "$subtract", Value: bson.A{"$created_at", StatsOrigin}}}
+		groupID = append(groupID, bson.E{Key: "bucket", Value: bson.D{
+			{Key: "$subtract", Value: bson.A{
+				"$created_at",
+				bson.D{{Key: "$mod", Value: bson.A{sinceOrigin, q.Bucket.Milliseconds()}}},
+			}},
+		}})
+	}
+
+	group := bson.D{{Key: "_id", Value: groupID}}
+	project := bson.D{{Key: "_id", Value: 1}}
+	if q.Metric == MetricDistinct {
+		group = append(group, bson.E{Key: "values", Value: bson.D{{Key: "$addToSet", Value: statsFieldPaths[q.Field]}}})
+		project = append(project, bson.E{Key: "value", Value: bson.D{{Key: "$size", Value: "$values"}}})
+	} else {
+		group = append(group, bson.E{Key: "value", Value: bson.D{{Key: "$sum", Value: 1}}})
+		project = append(project, bson.E{Key: "value", Value: 1})
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: mongoFilter(q.Filter)}},
+		{{Key: "$group", Value: group}},
+		{{Key: "$project", Value: project}},
+	}
+}
+
+func bucketTime(v any) time.Time {
+	switch t := v.(type) {
+	case bson.DateTime:
+		return t.Time().UTC()
+	case time.Time:
+		return t.UTC()
+	}
+	return time.Time{}
+}
+
+func toInt64(v any) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+func toString(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// MongoRuleStore keeps alerting rules in the "rules" collection of a MongoDB database.
+type MongoRuleStore struct {
+	rules *mongo.Collection
+}
+
+func NewMongoRuleStore(db *mongo.Database) *MongoRuleStore {
+	return &MongoRuleStore{
+		rules: db.Collection("rules"),
+	}
+}
+
+func ruleID(id string) (bson.ObjectID, error) {
+	oid, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return oid, ErrRuleNotFound
+	}
+	return oid, nil
+}
+
+func (m *MongoRuleStore) Insert(ctx context.Context, rule Rule) (string, error) {
+	rule.ID = ""
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+
+	res, err := m.rules.InsertOne(ctx, rule)
+	if err != nil {
+		log.Println("Error inserting rule:", err)
+		return "", err
+	}
+
+	return res.InsertedID.(bson.ObjectID).Hex(), nil
+}
+
+func (m *MongoRuleStore) All(ctx context.Context) ([]Rule, error) {
+	cursor, err := m.rules.Find(ctx, bson.D{})
+	if err != nil {
+		log.Println("Finding all rules error:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rules := []Rule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		log.Println("Error decoding rules.")
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (m *MongoRuleStore) GetOne(ctx context.Context, id string) (*Rule, error) {
+	oid, err := ruleID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rule Rule
+	err = m.rules.FindOne(ctx, bson.D{{Key: "_id", Value: oid}}).Decode(&rule)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRuleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func (m *MongoRuleStore) Update(ctx context.Context, rule Rule) error {
+	oid, err := ruleID(rule.ID)
+	if err != nil {
+		return err
+	}
+
+	rule.ID = ""
+	rule.UpdatedAt = time.Now()
+
+	res, err := m.rules.ReplaceOne(ctx, bson.D{{Key: "_id", Value: oid}}, rule)
+	if err != nil {
+		log.Println("Error updating rule:", err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrRuleNotFound
+	}
+
+	return nil
+}
+
+func (m *MongoRuleStore) Delete(ctx context.Context, id string) error {
+	oid, err := ruleID(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.rules.DeleteOne(ctx, bson.D{{Key: "_id", Value: oid}})
+	if err != nil {
+		log.Println("Error deleting rule:", err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrRuleNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,478 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// postgresSchema creates the parent logs table, partitioned by day on created_at, and the
+// rules table. Partitions are created on demand by PostgresStore.ensurePartition.
+//
+// A unique index on a partitioned table has to include the partition key, so the unique
+// idempotency keys are kept in log_idempotency_keys, written and deleted together with
+// their entries.
+const postgresSchema = `
+create table if not exists logs (
+	id bigserial,
+	tenant text not null default '',
+	name text not null,
+	data text not null,
+	severity text not null default '',
+	attributes jsonb not null default '{}',
+	idempotency_key text,
+	created_at timestamptz not null,
+	updated_at timestamptz not null,
+	primary key (id, created_at)
+) partition by range (created_at);
+
+create index if not exists logs_name_created_at_idx on logs (name, created_at);
+create index if not exists logs_tenant_created_at_idx on logs (tenant, created_at);
+
+create table if not exists log_idempotency_keys (
+	tenant text not null,
+	key text not null,
+	log_id bigint not null,
+	created_at timestamptz not null,
+	primary key (tenant, key)
+);
+
+create table if not exists rules (
+	id bigserial primary key,
+	body jsonb not null,
+	created_at timestamptz not null,
+	updated_at timestamptz not null
+);
+`
+
+// PostgresStore keeps log entries in a PostgreSQL table partitioned by day. Attributes
+// are stored as JSONB.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu         sync.Mutex
+	partitions map[string]bool
+}
+
+// NewPostgresStore creates the schema if needed and returns the store.
+func NewPostgresStore(ctx context.Context, db *sql.DB) (*PostgresStore, error) {
+	if _, err := db.ExecContext(ctx, postgresSchema); err != nil {
+		return nil, fmt.Errorf("creating postgres schema: %w", err)
+	}
+
+	return &PostgresStore{
+		db:         db,
+		partitions: map[string]bool{},
+	}, nil
+}
+
+// ensurePartition creates the daily partition holding t unless it is known to exist.
+func (p *PostgresStore) ensurePartition(ctx context.Context, t time.Time) error {
+	day := t.UTC().Truncate(24 * time.Hour)
+	name := "logs_" + day.Format("20060102")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.partitions[name] {
+		return nil
+	}
+
+	stmt := fmt.Sprintf(`create table if not exists %s partition of logs for values from ('%s') to ('%s')`,
+		name, day.Format(time.RFC3339), day.Add(24*time.Hour).Format(time.RFC3339))
+	if _, err := p.db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("creating partition %s: %w", name, err)
+	}
+
+	p.partitions[name] = true
+	return nil
+}
+
+func (p *PostgresStore) Insert(ctx context.Context, entry LogEntry) error {
+	return p.InsertMany(ctx, []LogEntry{entry})
+}
+
+func (p *PostgresStore) InsertMany(ctx context.Context, entries []LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// Partitions are created before the transaction: creating one takes a lock on logs
+	// that conflicts with the one held by the transaction's inserts.
+	stamped := make([]LogEntry, len(entries))
+	for i, entry := range entries {
+		entry.stamp()
+		if err := p.ensurePartition(ctx, entry.CreatedAt); err != nil {
+			return err
+		}
+		stamped[i] = entry
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into logs (tenant, name, data, severity, attributes, idempotency_key, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, nullif($6, ''), $7, $8) returning id`
+	keyStmt := `insert into log_idempotency_keys (tenant, key, log_id, created_at) values ($1, $2, $3, $4)
+		on conflict (tenant, key) do nothing`
+	for _, entry := range stamped {
+		attributes, err := json.Marshal(entry.Attributes)
+		if err != nil {
+			return err
+		}
+		if entry.Attributes == nil {
+			attributes = []byte("{}")
+		}
+
+		var id int64
+		err = tx.QueryRowContext(ctx, stmt, entry.Tenant, entry.Name, entry.Data, entry.Severity, attributes,
+			entry.IdempotencyKey, entry.CreatedAt, entry.UpdatedAt).Scan(&id)
+		if err != nil {
+			log.Println("Error inserting into logs:", err)
+			return err
+		}
+
+		if entry.IdempotencyKey == "" {
+			continue
+		}
+		res, err := tx.ExecContext(ctx, keyStmt, entry.Tenant, entry.IdempotencyKey, id, entry.CreatedAt)
+		if err != nil {
+			log.Println("Error inserting idempotency key:", err)
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// the key belongs to a stored entry; the rollback drops this one
+			return ErrDuplicate
+		}
+	}
+
+	return tx.Commit()
+}
+
+// where renders the filter as a SQL condition with positional arguments.
+func (f LogFilter) where() (string, []any) {
+	conds := []string{"tenant = $1"}
+	args := []any{f.Tenant}
+
+	if f.Name != "" {
+		args = append(args, f.Name)
+		conds = append(conds, "name = $"+strconv.Itoa(len(args)))
+	}
+	if f.Severity != "" {
+		args = append(args, f.Severity)
+		conds = append(conds, "severity = $"+strconv.Itoa(len(args)))
+	}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		conds = append(conds, "created_at >= $"+strconv.Itoa(len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		conds = append(conds, "created_at < $"+strconv.Itoa(len(args)))
+	}
+
+	return strings.Join(conds, " and "), args
+}
+
+// Query streams rows from the result set one at a time.
+func (p *PostgresStore) Query(ctx context.Context, filter LogFilter, fn func(*LogEntry) error) error {
+	where, args := filter.where()
+	query := `select id, tenant, name, data, severity, attributes, coalesce(idempotency_key, ''), created_at, updated_at from logs where ` + where +
+		` order by created_at, id`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" limit %d", filter.Limit)
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Finding logs error:", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry LogEntry
+		var id int64
+		var attributes []byte
+		err := rows.Scan(&id, &entry.Tenant, &entry.Name, &entry.Data, &entry.Severity, &attributes, &entry.IdempotencyKey, &entry.CreatedAt, &entry.UpdatedAt)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return err
+		}
+		entry.ID = strconv.FormatInt(id, 10)
+		if err := json.Unmarshal(attributes, &entry.Attributes); err != nil {
+			return err
+		}
+		if len(entry.Attributes) == 0 {
+			entry.Attributes = nil
+		}
+
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// postgresStatsColumns maps the StatsFields onto SQL expressions.
+var postgresStatsColumns = map[string]string{
+	"name":     "name",
+	"data":     "data",
+	"severity": "severity",
+}
+
+func (p *PostgresStore) Stats(ctx context.Context, q StatsQuery) (*Stats, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	rows, err := p.statsRows(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []statsRow
+	if q.needsTotals() {
+		unbucketed := q
+		unbucketed.Bucket = 0
+		if totals, err = p.statsRows(ctx, unbucketed); err != nil {
+			return nil, err
+		}
+	}
+
+	return q.shape(rows, totals), nil
+}
+
+func (p *PostgresStore) statsRows(ctx context.Context, q StatsQuery) ([]statsRow, error) {
+	where, args := q.Filter.where()
+
+	var selects, groups []string
+	for i, field := range q.GroupBy {
+		selects = append(selects, fmt.Sprintf("%s as g%d", postgresStatsColumns[field], i))
+		groups = append(groups, fmt.Sprintf("g%d", i))
+	}
+
+	bucket := "null::timestamptz"
+	if q.Bucket > 0 {
+		bucket = fmt.Sprintf("date_bin('%d seconds', created_at, timestamptz '%s')", int64(q.Bucket/time.Second), StatsOrigin.Format(time.RFC3339))
+		groups = append(groups, "bucket")
+	}
+	selects = append(selects, bucket+" as bucket")
+
+	value := "count(*)"
+	if q.Metric == MetricDistinct {
+		value = fmt.Sprintf("count(distinct %s)", postgresStatsColumns[q.Field])
+	}
+	selects = append(selects, value+" as value")
+
+	query := `select ` + strings.Join(selects, ", ") + ` from logs where ` + where
+	if len(groups) > 0 {
+		query += ` group by ` + strings.Join(groups, ", ")
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Aggregating logs error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []statsRow
+	for rows.Next() {
+		groupValues := make([]sql.NullString, len(q.GroupBy))
+		dest := make([]any, 0, len(q.GroupBy)+2)
+		for i := range groupValues {
+			dest = append(dest, &groupValues[i])
+		}
+		var bucketTime sql.NullTime
+		var value int64
+		dest = append(dest, &bucketTime, &value)
+
+		if err := rows.Scan(dest...); err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+
+		row := statsRow{Group: map[string]string{}, Value: value}
+		for i, field := range q.GroupBy {
+			row.Group[field] = groupValues[i].String
+		}
+		if bucketTime.Valid {
+			row.Bucket = bucketTime.Time.UTC()
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (p *PostgresStore) Delete(ctx context.Context, filter LogFilter) (int64, error) {
+	where, args := filter.where()
+
+	// the idempotency keys of the deleted entries go in the same statement
+	var deleted int64
+	err := p.db.QueryRowContext(ctx, `with deleted as (
+			delete from logs where `+where+` returning id, created_at
+		), keys as (
+			delete from log_idempotency_keys k using deleted d
+			where k.tenant = $1 and k.log_id = d.id and k.created_at = d.created_at
+		)
+		select count(*) from deleted`, args...).Scan(&deleted)
+	if err != nil {
+		log.Println("Error deleting logs:", err)
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// PostgresRuleStore keeps alerting rules as JSONB documents in the rules table.
+type PostgresRuleStore struct {
+	db *sql.DB
+}
+
+// NewPostgresRuleStore expects the schema created by NewPostgresStore.
+func NewPostgresRuleStore(db *sql.DB) *PostgresRuleStore {
+	return &PostgresRuleStore{db: db}
+}
+
+func (p *PostgresRuleStore) Insert(ctx context.Context, rule Rule) (string, error) {
+	rule.ID = ""
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+
+	body, err := json.Marshal(rule)
+	if err != nil {
+		return "", err
+	}
+
+	var id int64
+	stmt := `insert into rules (body, created_at, updated_at) values ($1, $2, $3) returning id`
+	if err := p.db.QueryRowContext(ctx, stmt, body, rule.CreatedAt, rule.UpdatedAt).Scan(&id); err != nil {
+		log.Println("Error inserting rule:", err)
+		return "", err
+	}
+
+	return strconv.FormatInt(id, 10), nil
+}
+
+func (p *PostgresRuleStore) All(ctx context.Context) ([]Rule, error) {
+	rows, err := p.db.QueryContext(ctx, `select id, body from rules order by created_at`)
+	if err != nil {
+		log.Println("Finding all rules error:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// postgresRuleID parses a rule id, treating malformed ids as unknown rules.
+func postgresRuleID(id string) (int64, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, ErrRuleNotFound
+	}
+	return n, nil
+}
+
+func (p *PostgresRuleStore) GetOne(ctx context.Context, id string) (*Rule, error) {
+	n, err := postgresRuleID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	row := p.db.QueryRowContext(ctx, `select id, body from rules where id = $1`, n)
+
+	rule, err := scanRule(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRuleNotFound
+	}
+	return rule, err
+}
+
+func (p *PostgresRuleStore) Update(ctx context.Context, rule Rule) error {
+	n, err := postgresRuleID(rule.ID)
+	if err != nil {
+		return err
+	}
+	rule.UpdatedAt = time.Now()
+
+	body, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.db.ExecContext(ctx, `update rules set body = $1, updated_at = $2 where id = $3`, body, rule.UpdatedAt, n)
+	if err != nil {
+		log.Println("Error updating rule:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+
+	return nil
+}
+
+func (p *PostgresRuleStore) Delete(ctx context.Context, id string) error {
+	n, err := postgresRuleID(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.db.ExecContext(ctx, `delete from rules where id = $1`, n)
+	if err != nil {
+		log.Println("Error deleting rule:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRule(row rowScanner) (*Rule, error) {
+	var id int64
+	var body []byte
+	if err := row.Scan(&id, &body); err != nil {
+		return nil, err
+	}
+
+	var rule Rule
+	if err := json.Unmarshal(body, &rule); err != nil {
+		return nil, err
+	}
+	rule.ID = strconv.FormatInt(id, 10)
+
+	return &rule, nil
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// ErrRuleNotFound is returned when no rule matches the given id.
+var ErrRuleNotFound = errors.New("rule not found")
+
+// Notification targets.
+const (
+	NotifyMail    = "mail"
+	NotifyWebhook = "webhook"
+)
+
+// Rule is a user defined alerting rule evaluated against every incoming log entry.
+//
+// An entry matches when its name equals LogName (if set), its severity equals Severity
+// (if set, ignoring case) and its data matches Pattern (if set). "More than 20 ERROR
+// entries from auth in 5 minutes" is LogName "auth", Severity "ERROR", Threshold 20 and
+// WindowSeconds 300. The rule fires when more than Threshold entries matched within the last
+// WindowSeconds; a Threshold of 0 fires on every match. Once fired, the rule stays quiet
+// for CooldownSeconds. Rules only see the entries of their own tenant.
+type Rule struct {
+	ID              string       `bson:"_id,omitempty" json:"id,omitempty"`
+	Tenant          string       `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Name            string       `bson:"name" json:"name"`
+	Enabled         bool         `bson:"enabled" json:"enabled"`
+	LogName         string       `bson:"log_name" json:"log_name,omitempty"`
+	Severity        string       `bson:"severity" json:"severity,omitempty"`
+	Pattern         string       `bson:"pattern" json:"pattern,omitempty"`
+	Threshold       int          `bson:"threshold" json:"threshold"`
+	WindowSeconds   int          `bson:"window_seconds" json:"window_seconds"`
+	CooldownSeconds int          `bson:"cooldown_seconds" json:"cooldown_seconds"`
+	Notify          Notification `bson:"notify" json:"notify"`
+	CreatedAt       time.Time    `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// Notification tells the alerting engine where to send a fired alert.
+type Notification struct {
+	Type string `bson:"type" json:"type"`
+	// To is the recipient address for mail notifications.
+	To string `bson:"to" json:"to,omitempty"`
+	// URL is the endpoint for webhook notifications.
+	URL string `bson:"url" json:"url,omitempty"`
+}
+
+// Validate checks that the rule can be evaluated and delivered.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule name is required")
+	}
+	if r.LogName == "" && r.Severity == "" && r.Pattern == "" {
+		return errors.New("rule needs a log_name, a severity or a pattern to match")
+	}
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	if r.Threshold < 0 || r.WindowSeconds < 0 || r.CooldownSeconds < 0 {
+		return errors.New("threshold, window_seconds and cooldown_seconds must not be negative")
+	}
+	if r.Threshold > 0 && r.WindowSeconds == 0 {
+		return errors.New("window_seconds is required when a threshold is set")
+	}
+
+	switch r.Notify.Type {
+	case NotifyMail:
+		if r.Notify.To == "" {
+			return errors.New("mail notifications need a recipient")
+		}
+	case NotifyWebhook:
+		if r.Notify.URL == "" {
+			return errors.New("webhook notifications need a url")
+		}
+	default:
+		return fmt.Errorf("unknown notification type %q", r.Notify.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StatsOrigin is the instant time buckets are aligned on by every backend: a bucket of
+// size d starts at StatsOrigin plus a whole multiple of d.
+var StatsOrigin = time.Unix(0, 0).UTC()
+
+// maxStatsBuckets bounds the number of buckets a query may produce, as every series holds
+// a value for each of them.
+const maxStatsBuckets = 10000
+
+// Supported statistics metrics.
+const (
+	MetricCount    = "count"
+	MetricDistinct = "distinct"
+)
+
+// StatsFields lists the log fields that can be grouped on or counted as distinct values.
+var StatsFields = []string{"name", "data", "severity"}
+
+// StatsQuery describes an aggregation over the stored logs.
+type StatsQuery struct {
+	Filter LogFilter
+	// GroupBy splits the result into one series per distinct combination of these fields.
+	GroupBy []string
+	// Bucket, when set, splits every series into fixed size time buckets.
+	Bucket time.Duration
+	// Metric is either MetricCount or MetricDistinct.
+	Metric string
+	// Field is the field whose distinct values are counted by MetricDistinct.
+	Field string
+	// Limit keeps only the top series ranked by total.
+	Limit int
+}
+
+func isStatsField(name string) bool {
+	for _, field := range StatsFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// statsValue returns the value of one of the StatsFields.
+func statsValue(entry *LogEntry, field string) string {
+	switch field {
+	case "name":
+		return entry.Name
+	case "data":
+		return entry.Data
+	case "severity":
+		return entry.Severity
+	}
+	return ""
+}
+
+// Validate checks the query against the supported fields and metrics.
+func (q StatsQuery) Validate() error {
+	for _, field := range q.GroupBy {
+		if !isStatsField(field) {
+			return fmt.Errorf("cannot group by %q", field)
+		}
+	}
+
+	switch q.Metric {
+	case MetricCount:
+	case MetricDistinct:
+		if !isStatsField(q.Field) {
+			return fmt.Errorf("cannot count distinct values of %q", q.Field)
+		}
+	default:
+		return fmt.Errorf("unknown metric %q", q.Metric)
+	}
+
+	if q.Bucket != 0 && q.Bucket < time.Second {
+		return fmt.Errorf("bucket must be at least one second")
+	}
+	if q.Bucket > 0 && !q.Filter.From.IsZero() {
+		to := q.Filter.To
+		if to.IsZero() {
+			to = time.Now()
+		}
+		if to.Sub(q.Filter.From)/q.Bucket >= maxStatsBuckets {
+			return fmt.Errorf("the time range holds more than %d buckets", maxStatsBuckets)
+		}
+	}
+
+	return nil
+}
+
+// StatsSeries is one group of the result. Values are aligned with Stats.Buckets, or hold a
+// single value when the query is not bucketed.
+type StatsSeries struct {
+	Group  map[string]string `json:"group"`
+	Label  string            `json:"label"`
+	Total  int64             `json:"total"`
+	Values []int64           `json:"values"`
+}
+
+// Stats is the result of a StatsQuery, shaped to be fed straight into a chart:
+// Buckets are the x axis labels and every series is one line or bar set.
+type Stats struct {
+	Metric  string        `json:"metric"`
+	Bucket  string        `json:"bucket,omitempty"`
+	Buckets []time.Time   `json:"buckets,omitempty"`
+	Series  []StatsSeries `json:"series"`
+}
+
+// statsRow is one (group, bucket) cell of an aggregation, as produced by a backend.
+type statsRow struct {
+	Group  map[string]string
+	Bucket time.Time
+	Value  int64
+}
+
+// bucketStart returns the start of the bucket holding t.
+func bucketStart(t time.Time, bucket time.Duration) time.Time {
+	offset := t.Sub(StatsOrigin) % bucket
+	if offset < 0 {
+		offset += bucket
+	}
+	return t.Add(-offset).UTC()
+}
+
+// seriesKey identifies the series of a group. The separator cannot occur in the values,
+// unlike the one of the display label.
+func (q StatsQuery) seriesKey(group map[string]string) string {
+	values := make([]string, len(q.GroupBy))
+	for i, field := range q.GroupBy {
+		values[i] = group[field]
+	}
+	return strings.Join(values, "\x00")
+}
+
+// seriesLabel is the display name of the series of a group.
+func (q StatsQuery) seriesLabel(group map[string]string) string {
+	if len(q.GroupBy) == 0 {
+		return q.Metric
+	}
+	values := make([]string, len(q.GroupBy))
+	for i, field := range q.GroupBy {
+		values[i] = group[field]
+	}
+	return strings.Join(values, " / ")
+}
+
+// buckets returns every bucket of the queried range, so that empty buckets are reported as
+// zeros. An open end of the range is bounded by the rows found.
+func (q StatsQuery) buckets(rows []statsRow) []time.Time {
+	var first, last time.Time
+	for _, row := range rows {
+		if first.IsZero() || row.Bucket.Before(first) {
+			first = row.Bucket
+		}
+		if last.IsZero() || row.Bucket.After(last) {
+			last = row.Bucket
+		}
+	}
+
+	start := first
+	if !q.Filter.From.IsZero() {
+		start = bucketStart(q.Filter.From, q.Bucket)
+	}
+	if start.IsZero() {
+		return nil
+	}
+
+	end := q.Filter.To
+	if end.IsZero() {
+		end = last.Add(q.Bucket)
+		// a range open towards the future, like since=24h, runs up to now
+		if now := time.Now(); !q.Filter.From.IsZero() && end.Before(now) {
+			end = now
+		}
+	}
+
+	var buckets []time.Time
+	for t := start; t.Before(end) && len(buckets) < maxStatsBuckets; t = t.Add(q.Bucket) {
+		buckets = append(buckets, t)
+	}
+	return buckets
+}
+
+// shape turns the flat aggregation rows into chart series. totals holds one unbucketed
+// row per group when the series totals cannot be summed from the buckets, which is the
+// case for the distinct metric: a value seen in two buckets is counted once in the total.
+func (q StatsQuery) shape(rows, totals []statsRow) *Stats {
+	stats := &Stats{Metric: q.Metric, Series: []StatsSeries{}}
+
+	// every series is aligned on the buckets of the whole range
+	bucketIndex := map[time.Time]int{}
+	if q.Bucket > 0 {
+		stats.Bucket = q.Bucket.String()
+		stats.Buckets = q.buckets(rows)
+		for i, t := range stats.Buckets {
+			bucketIndex[t] = i
+		}
+	}
+
+	seriesIndex := map[string]int{}
+	series := func(group map[string]string) *StatsSeries {
+		key := q.seriesKey(group)
+		i, ok := seriesIndex[key]
+		if !ok {
+			size := 1
+			if q.Bucket > 0 {
+				size = len(stats.Buckets)
+			}
+			i = len(stats.Series)
+			seriesIndex[key] = i
+			stats.Series = append(stats.Series, StatsSeries{Group: group, Label: q.seriesLabel(group), Values: make([]int64, size)})
+		}
+		return &stats.Series[i]
+	}
+
+	for _, row := range rows {
+		s := series(row.Group)
+		if q.Bucket > 0 {
+			if b, ok := bucketIndex[row.Bucket]; ok {
+				s.Values[b] += row.Value
+			}
+		} else {
+			s.Values[0] += row.Value
+		}
+		if totals == nil {
+			s.Total += row.Value
+		}
+	}
+	for _, row := range totals {
+		series(row.Group).Total = row.Value
+	}
+
+	sort.SliceStable(stats.Series, func(i, j int) bool {
+		if stats.Series[i].Total != stats.Series[j].Total {
+			return stats.Series[i].Total > stats.Series[j].Total
+		}
+		return stats.Series[i].Label < stats.Series[j].Label
+	})
+	if q.Limit > 0 && len(stats.Series) > q.Limit {
+		stats.Series = stats.Series[:q.Limit]
+	}
+
+	return stats
+}
+
+// needsTotals reports whether the series totals must be aggregated apart from the buckets.
+func (q StatsQuery) needsTotals() bool {
+	return q.Metric == MetricDistinct && q.Bucket > 0
+}
+
+// statsAggregator computes a StatsQuery in process, for backends that cannot push the
+// aggregation down to their storage engine.
+type statsAggregator struct {
+	q      StatsQuery
+	cells  map[string]*statsCell
+	totals map[string]*statsCell
+}
+
+type statsCell struct {
+	row    statsRow
+	values map[string]struct{}
+}
+
+func newStatsAggregator(q StatsQuery) *statsAggregator {
+	return &statsAggregator{q: q, cells: map[string]*statsCell{}, totals: map[string]*statsCell{}}
+}
+
+func (a *statsAggregator) Add(entry *LogEntry) {
+	if !a.q.Filter.Matches(entry) {
+		return
+	}
+
+	group := make(map[string]string, len(a.q.GroupBy))
+	for _, field := range a.q.GroupBy {
+		group[field] = statsValue(entry, field)
+	}
+	key := a.q.seriesKey(group)
+
+	var bucket time.Time
+	if a.q.Bucket > 0 {
+		bucket = bucketStart(entry.CreatedAt, a.q.Bucket)
+	}
+
+	a.count(a.cells, key+"\x00"+bucket.String(), statsRow{Group: group, Bucket: bucket}, entry)
+	if a.q.needsTotals() {
+		a.count(a.totals, key, statsRow{Group: group}, entry)
+	}
+}
+
+func (a *statsAggregator) count(cells map[string]*statsCell, key string, row statsRow, entry *LogEntry) {
+	cell, ok := cells[key]
+	if !ok {
+		cell = &statsCell{row: row, values: map[string]struct{}{}}
+		cells[key] = cell
+	}
+
+	if a.q.Metric == MetricDistinct {
+		cell.values[statsValue(entry, a.q.Field)] = struct{}{}
+		cell.row.Value = int64(len(cell.values))
+	} else {
+		cell.row.Value++
+	}
+}
+
+func (a *statsAggregator) Stats() *Stats {
+	rows := make([]statsRow, 0, len(a.cells))
+	for _, cell := range a.cells {
+		rows = append(rows, cell.row)
+	}
+
+	var totals []statsRow
+	if a.q.needsTotals() {
+		totals = make([]statsRow, 0, len(a.totals))
+		for _, cell := range a.totals {
+			totals = append(totals, cell.row)
+		}
+	}
+	return a.q.shape(rows, totals)
+}
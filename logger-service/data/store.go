@@ -0,0 +1,43 @@
+package data
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDuplicate is returned when storing an entry whose idempotency key the tenant already
+// has an entry with.
+var ErrDuplicate = errors.New("an entry with this idempotency key is already stored")
+
+// LogStore is the storage backend for log entries.
+type LogStore interface {
+	// Insert stores a single entry. Missing timestamps are set to the current time. An
+	// entry with an idempotency key is stored with its key in one write, and fails with
+	// ErrDuplicate when the tenant already has an entry with the key.
+	Insert(ctx context.Context, entry LogEntry) error
+	// InsertMany stores a batch of entries. A batch reusing an idempotency key fails with
+	// ErrDuplicate; MongoDB keeps the entries before the duplicate, the other stores
+	// none of the batch.
+	InsertMany(ctx context.Context, entries []LogEntry) error
+	// Query calls fn for every entry matching the filter, oldest first, and stops at the
+	// first error returned by fn.
+	Query(ctx context.Context, filter LogFilter, fn func(*LogEntry) error) error
+	// Stats runs an aggregation over the stored entries.
+	Stats(ctx context.Context, q StatsQuery) (*Stats, error)
+	// Delete removes the entries matching the filter and returns how many were removed.
+	Delete(ctx context.Context, filter LogFilter) (int64, error)
+}
+
+// RuleStore is the storage backend for alerting rules.
+type RuleStore interface {
+	// Insert stores a new rule and returns its id.
+	Insert(ctx context.Context, rule Rule) (string, error)
+	// All returns every stored rule, oldest first.
+	All(ctx context.Context) ([]Rule, error)
+	// GetOne returns the rule with the given id or ErrRuleNotFound.
+	GetOne(ctx context.Context, id string) (*Rule, error)
+	// Update replaces the stored rule with the same id.
+	Update(ctx context.Context, rule Rule) error
+	// Delete removes the rule with the given id.
+	Delete(ctx context.Context, id string) error
+}
@@ -0,0 +1,446 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// The conformance suite runs against every LogStore. The memory and file stores always
+// run; MongoDB and PostgreSQL run when LOGGER_TEST_MONGODB_URI or LOGGER_TEST_POSTGRES_DSN
+// point at a server.
+
+func TestMemoryStore(t *testing.T) {
+	testLogStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	testLogStore(t, store)
+}
+
+func TestMongoStore(t *testing.T) {
+	uri := os.Getenv("LOGGER_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("LOGGER_TEST_MONGODB_URI is not set")
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("logs_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	testLogStore(t, NewMongoStore(db))
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("LOGGER_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LOGGER_TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewPostgresStore(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testLogStore(t, store)
+}
+
+// testLogStore checks the behaviour every backend must have. Every subtest writes to a
+// tenant of its own, so backends sharing a database between runs see no other entries.
+func testLogStore(t *testing.T, store LogStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store LogStore, tenant string)
+	}{
+		{"InsertAndQuery", testInsertAndQuery},
+		{"QueryOldestFirst", testQueryOldestFirst},
+		{"InsertManyStampsTime", testInsertManyStampsTime},
+		{"QueryFilters", testQueryFilters},
+		{"QueryStopsOnError", testQueryStopsOnError},
+		{"TenantIsolation", testTenantIsolation},
+		{"StatsCount", testStatsCount},
+		{"StatsBuckets", testStatsBuckets},
+		{"StatsDistinct", testStatsDistinct},
+		{"Delete", testDelete},
+		{"IdempotencyKey", testIdempotencyKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, store, fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano()))
+		})
+	}
+}
+
+// testBase is the time the suite's entries are created around. It is a whole millisecond,
+// the precision of MongoDB dates.
+var testBase = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func mustInsert(t *testing.T, store LogStore, entries ...LogEntry) {
+	t.Helper()
+
+	var err error
+	if len(entries) == 1 {
+		err = store.Insert(context.Background(), entries[0])
+	} else {
+		err = store.InsertMany(context.Background(), entries)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func query(t *testing.T, store LogStore, filter LogFilter) []LogEntry {
+	t.Helper()
+
+	var entries []LogEntry
+	err := store.Query(context.Background(), filter, func(entry *LogEntry) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func dataOf(entries []LogEntry) []string {
+	values := make([]string, len(entries))
+	for i, entry := range entries {
+		values[i] = entry.Data
+	}
+	return values
+}
+
+func stats(t *testing.T, store LogStore, q StatsQuery) *Stats {
+	t.Helper()
+
+	s, err := store.Stats(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testInsertAndQuery(t *testing.T, store LogStore, tenant string) {
+	mustInsert(t, store, LogEntry{
+		Tenant:     tenant,
+		Name:       "orders",
+		Data:       "order placed",
+		Severity:   "warn",
+		Attributes: map[string]string{"order_id": "42"},
+		CreatedAt:  testBase,
+	})
+
+	entries := query(t, store, LogFilter{Tenant: tenant})
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	got := entries[0]
+	if got.ID == "" {
+		t.Error("the entry has no id")
+	}
+	if got.Tenant != tenant || got.Name != "orders" || got.Data != "order placed" || got.Severity != "warn" {
+		t.Errorf("got %+v", got)
+	}
+	if got.Attributes["order_id"] != "42" || len(got.Attributes) != 1 {
+		t.Errorf("attributes = %v", got.Attributes)
+	}
+	if !got.CreatedAt.Equal(testBase) {
+		t.Errorf("created_at = %v, want %v", got.CreatedAt, testBase)
+	}
+	if !got.UpdatedAt.Equal(testBase) {
+		t.Errorf("updated_at = %v, want %v", got.UpdatedAt, testBase)
+	}
+}
+
+func testQueryOldestFirst(t *testing.T, store LogStore, tenant string) {
+	for _, minutes := range []int{3, 1, 4, 2} {
+		mustInsert(t, store, LogEntry{Tenant: tenant, Name: "a", Data: fmt.Sprint(minutes), CreatedAt: testBase.Add(time.Duration(minutes) * time.Minute)})
+	}
+
+	got := dataOf(query(t, store, LogFilter{Tenant: tenant}))
+	if want := []string{"1", "2", "3", "4"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got = dataOf(query(t, store, LogFilter{Tenant: tenant, Limit: 2}))
+	if want := []string{"1", "2"}; !slices.Equal(got, want) {
+		t.Errorf("with limit 2: got %v, want %v", got, want)
+	}
+}
+
+func testInsertManyStampsTime(t *testing.T, store LogStore, tenant string) {
+	before := time.Now().Add(-time.Second)
+	mustInsert(t, store,
+		LogEntry{Tenant: tenant, Name: "a", Data: "1"},
+		LogEntry{Tenant: tenant, Name: "a", Data: "2"},
+	)
+
+	entries := query(t, store, LogFilter{Tenant: tenant})
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.CreatedAt.Before(before) || entry.UpdatedAt.Before(before) {
+			t.Errorf("entry %s was not stamped: %+v", entry.Data, entry)
+		}
+	}
+	if entries[0].ID == entries[1].ID {
+		t.Errorf("both entries have id %q", entries[0].ID)
+	}
+}
+
+func testQueryFilters(t *testing.T, store LogStore, tenant string) {
+	mustInsert(t, store,
+		LogEntry{Tenant: tenant, Name: "orders", Data: "0", Severity: "INFO", CreatedAt: testBase},
+		LogEntry{Tenant: tenant, Name: "payments", Data: "1", Severity: "ERROR", CreatedAt: testBase.Add(time.Minute)},
+		LogEntry{Tenant: tenant, Name: "orders", Data: "2", Severity: "ERROR", CreatedAt: testBase.Add(2 * time.Minute)},
+		LogEntry{Tenant: tenant, Name: "orders", Data: "3", CreatedAt: testBase.Add(3 * time.Minute)},
+	)
+
+	tests := []struct {
+		name   string
+		filter LogFilter
+		want   []string
+	}{
+		{"name", LogFilter{Name: "orders"}, []string{"0", "2", "3"}},
+		{"from is inclusive", LogFilter{From: testBase.Add(time.Minute)}, []string{"1", "2", "3"}},
+		{"to is exclusive", LogFilter{To: testBase.Add(2 * time.Minute)}, []string{"0", "1"}},
+		{"range and name", LogFilter{Name: "orders", From: testBase.Add(time.Second), To: testBase.Add(time.Hour)}, []string{"2", "3"}},
+		{"severity", LogFilter{Severity: "ERROR"}, []string{"1", "2"}},
+		{"name and severity", LogFilter{Name: "orders", Severity: "ERROR"}, []string{"2"}},
+		{"limit", LogFilter{Name: "orders", Limit: 1}, []string{"0"}},
+		{"no match", LogFilter{Name: "shipping"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Tenant = tenant
+			if got := dataOf(query(t, store, tt.filter)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testQueryStopsOnError(t *testing.T, store LogStore, tenant string) {
+	mustInsert(t, store,
+		LogEntry{Tenant: tenant, Name: "a", Data: "1", CreatedAt: testBase},
+		LogEntry{Tenant: tenant, Name: "a", Data: "2", CreatedAt: testBase.Add(time.Second)},
+	)
+
+	stop := errors.New("stop")
+	calls := 0
+	err := store.Query(context.Background(), LogFilter{Tenant: tenant}, func(*LogEntry) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Query returned %v, want the error of fn", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn was called %d times after failing", calls)
+	}
+}
+
+func testTenantIsolation(t *testing.T, store LogStore, tenant string) {
+	other := tenant + "-other"
+	mustInsert(t, store,
+		LogEntry{Tenant: tenant, Name: "a", Data: "mine", CreatedAt: testBase},
+		LogEntry{Tenant: other, Name: "a", Data: "theirs", CreatedAt: testBase},
+	)
+
+	if got := dataOf(query(t, store, LogFilter{Tenant: tenant})); !slices.Equal(got, []string{"mine"}) {
+		t.Errorf("query saw %v", got)
+	}
+
+	s := stats(t, store, StatsQuery{Filter: LogFilter{Tenant: tenant}, Metric: MetricCount})
+	if len(s.Series) != 1 || s.Series[0].Total != 1 {
+		t.Errorf("stats saw %+v", s.Series)
+	}
+
+	if _, err := store.Delete(context.Background(), LogFilter{Tenant: tenant, Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dataOf(query(t, store, LogFilter{Tenant: other})); !slices.Equal(got, []string{"theirs"}) {
+		t.Errorf("delete removed the entries of another tenant: %v", got)
+	}
+}
+
+func testStatsCount(t *testing.T, store LogStore, tenant string) {
+	mustInsert(t, store,
+		LogEntry{Tenant: tenant, Name: "orders", Severity: "info", Data: "1", CreatedAt: testBase},
+		LogEntry{Tenant: tenant, Name: "orders", Severity: "error", Data: "2", CreatedAt: testBase},
+		LogEntry{Tenant: tenant, Name: "orders", Severity: "error", Data: "3", CreatedAt: testBase},
+		LogEntry{Tenant: tenant, Name: "payments", Severity: "error", Data: "4", CreatedAt: testBase},
+	)
+
+	s := stats(t, store, StatsQuery{Filter: LogFilter{Tenant: tenant}, GroupBy: []string{"name", "severity"}, Metric: MetricCount})
+
+	var got []string
+	for _, series := range s.Series {
+		got = append(got, fmt.Sprintf("%s=%d", series.Label, series.Total))
+	}
+	// ranked by total, then by label
+	want := []string{"orders / error=2", "orders / info=1", "payments / error=1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	s = stats(t, store, StatsQuery{Filter: LogFilter{Tenant: tenant}, GroupBy: []string{"name"}, Metric: MetricCount, Limit: 1})
+	if len(s.Series) != 1 || s.Series[0].Label != "orders" || s.Series[0].Total != 3 {
+		t.Errorf("top 1 = %+v", s.Series)
+	}
+}
+
+func testStatsBuckets(t *testing.T, store LogStore, tenant string) {
+	// 12:00 is a multiple of 5 minutes since StatsOrigin
+	mustInsert(t, store,
+		LogEntry{Tenant: tenant, Name: "a", Data: "1", CreatedAt: testBase.Add(time.Minute)},
+		LogEntry{Tenant: tenant, Name: "a", Data: "2", CreatedAt: testBase.Add(4 * time.Minute)},
+		LogEntry{Tenant: tenant, Name: "a", Data: "3", CreatedAt: testBase.Add(16 * time.Minute)},
+	)
+
+	s := stats(t, store, StatsQuery{
+		Filter: LogFilter{Tenant: tenant, From: testBase, To: testBase.Add(25 * time.Minute)},
+		Bucket: 5 * time.Minute,
+		Metric: MetricCount,
+	})
+
+	var buckets []time.Time
+	for i := range 5 {
+		buckets = append(buckets, testBase.Add(time.Duration(i)*5*time.Minute))
+	}
+	if !slices.EqualFunc(s.Buckets, buckets, time.Time.Equal) {
+		t.Errorf("buckets = %v, want %v", s.Buckets, buckets)
+	}
+	if len(s.Series) != 1 {
+		t.Fatalf("got %d series, want 1", len(s.Series))
+	}
+	if want := []int64{2, 0, 0, 1, 0}; !slices.Equal(s.Series[0].Values, want) {
+		t.Errorf("values = %v, want %v", s.Series[0].Values, want)
+	}
+	if s.Series[0].Total != 3 {
+		t.Errorf("total = %d, want 3", s.Series[0].Total)
+	}
+}
+
+func testStatsDistinct(t *testing.T, store LogStore, tenant string) {
+	mustInsert(t, store,
+		LogEntry{Tenant: tenant, Name: "a", Data: "x", CreatedAt: testBase},
+		LogEntry{Tenant: tenant, Name: "a", Data: "y", CreatedAt: testBase.Add(time.Minute)},
+		LogEntry{Tenant: tenant, Name: "a", Data: "x", CreatedAt: testBase.Add(10 * time.Minute)},
+		LogEntry{Tenant: tenant, Name: "b", Data: "x", CreatedAt: testBase},
+		LogEntry{Tenant: tenant, Name: "b", Data: "y", CreatedAt: testBase.Add(10 * time.Minute)},
+		LogEntry{Tenant: tenant, Name: "b", Data: "z", CreatedAt: testBase.Add(11 * time.Minute)},
+	)
+
+	s := stats(t, store, StatsQuery{
+		Filter:  LogFilter{Tenant: tenant, From: testBase, To: testBase.Add(15 * time.Minute)},
+		GroupBy: []string{"name"},
+		Bucket:  5 * time.Minute,
+		Metric:  MetricDistinct,
+		Field:   "data",
+	})
+
+	// a has x and y: its buckets add up to 3, but it has 2 distinct values
+	want := map[string]struct {
+		total  int64
+		values []int64
+	}{
+		"b": {3, []int64{1, 0, 2}},
+		"a": {2, []int64{2, 0, 1}},
+	}
+	if len(s.Series) != 2 || s.Series[0].Label != "b" {
+		t.Fatalf("series = %+v, want b ranked before a", s.Series)
+	}
+	for _, series := range s.Series {
+		w := want[series.Label]
+		if series.Total != w.total || !slices.Equal(series.Values, w.values) {
+			t.Errorf("%s: total %d values %v, want total %d values %v", series.Label, series.Total, series.Values, w.total, w.values)
+		}
+	}
+}
+
+func testDelete(t *testing.T, store LogStore, tenant string) {
+	mustInsert(t, store,
+		LogEntry{Tenant: tenant, Name: "orders", Data: "0", CreatedAt: testBase},
+		LogEntry{Tenant: tenant, Name: "orders", Data: "1", CreatedAt: testBase.Add(time.Minute)},
+		LogEntry{Tenant: tenant, Name: "payments", Data: "2", CreatedAt: testBase.Add(time.Minute)},
+	)
+
+	deleted, err := store.Delete(context.Background(), LogFilter{Tenant: tenant, Name: "orders", From: testBase.Add(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d entries, want 1", deleted)
+	}
+	if got := dataOf(query(t, store, LogFilter{Tenant: tenant})); !slices.Equal(got, []string{"0", "2"}) {
+		t.Errorf("left %v", got)
+	}
+}
+
+func testIdempotencyKey(t *testing.T, store LogStore, tenant string) {
+	ctx := context.Background()
+	mustInsert(t, store, LogEntry{Tenant: tenant, Name: "a", Data: "1", IdempotencyKey: "k1", CreatedAt: testBase})
+
+	if err := store.Insert(ctx, LogEntry{Tenant: tenant, Name: "a", Data: "2", IdempotencyKey: "k1", CreatedAt: testBase}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("second entry with k1: err = %v, want %v", err, ErrDuplicate)
+	}
+	// the duplicate comes first, so no backend stores any of the batch
+	err := store.InsertMany(ctx, []LogEntry{
+		{Tenant: tenant, Name: "a", Data: "3", IdempotencyKey: "k1", CreatedAt: testBase},
+		{Tenant: tenant, Name: "a", Data: "4", IdempotencyKey: "k2", CreatedAt: testBase},
+	})
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("batch reusing k1: err = %v, want %v", err, ErrDuplicate)
+	}
+
+	// keys are scoped to the tenant, and entries without one are never duplicates
+	mustInsert(t, store, LogEntry{Tenant: tenant + "-other", Name: "a", Data: "5", IdempotencyKey: "k1", CreatedAt: testBase})
+	mustInsert(t, store, LogEntry{Tenant: tenant, Name: "a", Data: "6", CreatedAt: testBase.Add(time.Second)})
+	mustInsert(t, store, LogEntry{Tenant: tenant, Name: "a", Data: "7", CreatedAt: testBase.Add(2 * time.Second)})
+
+	entries := query(t, store, LogFilter{Tenant: tenant})
+	if got := dataOf(entries); !slices.Equal(got, []string{"1", "6", "7"}) {
+		t.Fatalf("stored %v, want 1, 6 and 7", got)
+	}
+	if entries[0].IdempotencyKey != "k1" || entries[1].IdempotencyKey != "" {
+		t.Errorf("keys = %q, %q", entries[0].IdempotencyKey, entries[1].IdempotencyKey)
+	}
+
+	// deleting the entry frees its key
+	if _, err := store.Delete(ctx, LogFilter{Tenant: tenant, To: testBase.Add(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, store, LogEntry{Tenant: tenant, Name: "a", Data: "8", IdempotencyKey: "k1", CreatedAt: testBase})
+}
@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"logger-service/data"
+	"time"
+)
+
+// writeTimeout bounds how long a listener waits for the sink to store a batch.
+const writeTimeout = 5 * time.Second
+
+// Sink stores entries decoded by the listeners. The api passes its ingest method so
+// entries from syslog and OTLP are alerted on and tailed like any other write.
+type Sink func(ctx context.Context, entries ...data.LogEntry) error
+
+// Listener is the per-listener configuration shared by all receivers.
+type Listener struct {
+	// Addr is the address to listen on, e.g. ":5514".
+	Addr string
+	// Name is the log name used when a message does not carry one (syslog APP-NAME,
+	// OTLP service.name).
+	Name string
+	// MaxBatch is the largest number of entries stored at once, 0 for no limit. Larger
+	// OTLP requests are refused as too large so the exporter splits them.
+	MaxBatch int
+	Sink     Sink
+}
+
+// errBatchTooLarge is returned for more entries than MaxBatch.
+var errBatchTooLarge = errors.New("request holds more log records than may be stored at once")
+
+func (l Listener) write(entries ...data.LogEntry) error {
+	if l.MaxBatch > 0 && len(entries) > l.MaxBatch {
+		return errBatchTooLarge
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	return l.Sink(ctx, entries...)
+}
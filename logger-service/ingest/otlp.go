@@ -0,0 +1,329 @@
+package ingest
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"logger-service/data"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxOTLPBody is the largest OTLP/HTTP request body accepted, after decompression.
+const maxOTLPBody = 16 << 20
+
+// FromOTLP maps an OTLP export request onto log entries. Resource, scope and record
+// attributes are merged into Attributes (record attributes win), the service.name
+// resource attribute becomes the entry name (defaultName when unset), and the trace
+// context is kept as the trace_id and span_id attributes.
+func FromOTLP(req *collogspb.ExportLogsServiceRequest, defaultName string) []data.LogEntry {
+	var entries []data.LogEntry
+
+	for _, rl := range req.GetResourceLogs() {
+		resource := map[string]string{}
+		flattenAttributes(resource, rl.GetResource().GetAttributes())
+
+		name := defaultName
+		if v := resource["service.name"]; v != "" {
+			name = v
+		}
+
+		for _, sl := range rl.GetScopeLogs() {
+			for _, record := range sl.GetLogRecords() {
+				attrs := make(map[string]string, len(resource))
+				for k, v := range resource {
+					attrs[k] = v
+				}
+				if scope := sl.GetScope().GetName(); scope != "" {
+					attrs["otel.scope.name"] = scope
+				}
+				flattenAttributes(attrs, record.GetAttributes())
+				if id := record.GetTraceId(); len(id) > 0 {
+					attrs["trace_id"] = hex.EncodeToString(id)
+				}
+				if id := record.GetSpanId(); len(id) > 0 {
+					attrs["span_id"] = hex.EncodeToString(id)
+				}
+
+				entries = append(entries, data.LogEntry{
+					Name:       name,
+					Data:       anyValueString(record.GetBody()),
+					Severity:   otlpSeverity(record),
+					Attributes: attrs,
+					CreatedAt:  otlpTime(record),
+				})
+			}
+		}
+	}
+
+	return entries
+}
+
+// otlpSeverity prefers the severity text and otherwise names the severity number range.
+func otlpSeverity(record *logspb.LogRecord) string {
+	if text := record.GetSeverityText(); text != "" {
+		return text
+	}
+
+	switch n := record.GetSeverityNumber(); {
+	case n == logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED:
+		return ""
+	case n <= logspb.SeverityNumber_SEVERITY_NUMBER_TRACE4:
+		return "TRACE"
+	case n <= logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG4:
+		return "DEBUG"
+	case n <= logspb.SeverityNumber_SEVERITY_NUMBER_INFO4:
+		return "INFO"
+	case n <= logspb.SeverityNumber_SEVERITY_NUMBER_WARN4:
+		return "WARN"
+	case n <= logspb.SeverityNumber_SEVERITY_NUMBER_ERROR4:
+		return "ERROR"
+	default:
+		return "FATAL"
+	}
+}
+
+func otlpTime(record *logspb.LogRecord) time.Time {
+	if ns := record.GetTimeUnixNano(); ns != 0 {
+		return time.Unix(0, int64(ns))
+	}
+	if ns := record.GetObservedTimeUnixNano(); ns != 0 {
+		return time.Unix(0, int64(ns))
+	}
+	return time.Now()
+}
+
+func flattenAttributes(dst map[string]string, kvs []*commonpb.KeyValue) {
+	for _, kv := range kvs {
+		dst[kv.GetKey()] = anyValueString(kv.GetValue())
+	}
+}
+
+// anyValueString renders scalars as text and arrays and maps as JSON.
+func anyValueString(v *commonpb.AnyValue) string {
+	switch v.GetValue().(type) {
+	case nil:
+		return ""
+	case *commonpb.AnyValue_StringValue:
+		return v.GetStringValue()
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.GetBoolValue())
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.GetIntValue(), 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.GetDoubleValue(), 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.GetBytesValue())
+	}
+
+	out, err := json.Marshal(anyValueJSON(v))
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func anyValueJSON(v *commonpb.AnyValue) any {
+	switch v.GetValue().(type) {
+	case *commonpb.AnyValue_ArrayValue:
+		values := []any{}
+		for _, item := range v.GetArrayValue().GetValues() {
+			values = append(values, anyValueJSON(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		values := map[string]any{}
+		for _, kv := range v.GetKvlistValue().GetValues() {
+			values[kv.GetKey()] = anyValueJSON(kv.GetValue())
+		}
+		return values
+	case *commonpb.AnyValue_BoolValue:
+		return v.GetBoolValue()
+	case *commonpb.AnyValue_IntValue:
+		return v.GetIntValue()
+	case *commonpb.AnyValue_DoubleValue:
+		return v.GetDoubleValue()
+	default:
+		return anyValueString(v)
+	}
+}
+
+// OTLPHandler serves POST /v1/logs for OTLP/HTTP exporters, in both the protobuf and the
+// JSON encoding, optionally gzipped.
+func OTLPHandler(l Listener) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+
+		// one byte past the limit tells a body that is too large from one that fits exactly
+		raw, err := io.ReadAll(io.LimitReader(body, maxOTLPBody+1))
+		if err != nil {
+			http.Error(w, "error reading body", http.StatusBadRequest)
+			return
+		}
+		if len(raw) > maxOTLPBody {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+		req := &collogspb.ExportLogsServiceRequest{}
+		switch contentType {
+		case "application/x-protobuf":
+			err = proto.Unmarshal(raw, req)
+		case "application/json":
+			err = unmarshalOTLPJSON(raw, req)
+		default:
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, "invalid OTLP request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := l.write(FromOTLP(req, l.Name)...); err != nil {
+			if errors.Is(err, errBatchTooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			log.Println("Error writing OTLP logs:", err)
+			http.Error(w, "error storing logs", http.StatusServiceUnavailable)
+			return
+		}
+
+		resp := &collogspb.ExportLogsServiceResponse{}
+		var out []byte
+		if contentType == "application/json" {
+			out, err = protojson.Marshal(resp)
+		} else {
+			out, err = proto.Marshal(resp)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write(out)
+	})
+}
+
+// unmarshalOTLPJSON decodes the OTLP/JSON encoding, which differs from protojson in
+// spelling trace and span ids as hex rather than base64.
+func unmarshalOTLPJSON(raw []byte, req *collogspb.ExportLogsServiceRequest) error {
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	resourceLogs, _ := doc["resourceLogs"].([]any)
+	for _, rl := range resourceLogs {
+		rl, _ := rl.(map[string]any)
+		scopeLogs, _ := rl["scopeLogs"].([]any)
+		for _, sl := range scopeLogs {
+			sl, _ := sl.(map[string]any)
+			records, _ := sl["logRecords"].([]any)
+			for _, record := range records {
+				record, _ := record.(map[string]any)
+				for _, key := range []string{"traceId", "spanId"} {
+					id, ok := record[key].(string)
+					if !ok || id == "" {
+						continue
+					}
+					b, err := hex.DecodeString(id)
+					if err != nil {
+						return errors.New(key + " must be hex encoded")
+					}
+					record[key] = base64.StdEncoding.EncodeToString(b)
+				}
+			}
+		}
+	}
+
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(normalized, req)
+}
+
+// ServeOTLPHTTP serves the OTLP/HTTP logs endpoint.
+func ServeOTLPHTTP(l Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/logs", OTLPHandler(l))
+
+	srv := &http.Server{
+		Addr:    l.Addr,
+		Handler: mux,
+	}
+
+	log.Println("OTLP/HTTP listener on", l.Addr)
+	return srv.ListenAndServe()
+}
+
+// otlpServer implements the OTLP/gRPC LogsService.
+type otlpServer struct {
+	collogspb.UnimplementedLogsServiceServer
+	listener Listener
+}
+
+func (s *otlpServer) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	entries := FromOTLP(req, s.listener.Name)
+	if s.listener.MaxBatch > 0 && len(entries) > s.listener.MaxBatch {
+		return nil, status.Error(codes.InvalidArgument, errBatchTooLarge.Error())
+	}
+	if err := s.listener.Sink(ctx, entries...); err != nil {
+		log.Println("Error writing OTLP logs:", err)
+		return nil, status.Error(codes.Unavailable, "error storing logs")
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+// ServeOTLPGRPC serves the OTLP/gRPC LogsService.
+func ServeOTLPGRPC(l Listener) error {
+	lis, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("OTLP/gRPC listener on", l.Addr)
+	return newOTLPGRPCServer(l).Serve(lis)
+}
+
+func newOTLPGRPCServer(l Listener) *grpc.Server {
+	srv := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(srv, &otlpServer{listener: l})
+	return srv
+}
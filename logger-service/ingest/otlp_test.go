@@ -0,0 +1,274 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"logger-service/data"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpSample returns a recorded export request: otlp-logs.json in the OTLP/JSON encoding
+// and otlp-logs.pb, the same request in protobuf.
+func otlpSample(t *testing.T, name string) []byte {
+	t.Helper()
+
+	raw, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// checkOTLPSample compares what the listener stored for the recorded request.
+func checkOTLPSample(t *testing.T, entries []data.LogEntry) {
+	t.Helper()
+
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	first := entries[0]
+	if first.Name != "checkout" || first.Data != "payment authorized" || first.Severity != "Information" {
+		t.Errorf("first entry = %q %q %q", first.Name, first.Data, first.Severity)
+	}
+	if want := time.Unix(0, 1767225600000000000); !first.CreatedAt.Equal(want) {
+		t.Errorf("first entry created at %v, want %v", first.CreatedAt, want)
+	}
+	for k, v := range map[string]string{
+		"service.name":    "checkout",
+		"host.name":       "web-1",
+		"otel.scope.name": "checkout.payments",
+		"order.id":        "1042",
+		"retry":           "false",
+		"items":           `["sku-1","sku-2"]`,
+		"trace_id":        "5b8efff798038103d269b633813fc60c",
+		"span_id":         "eee19b7ec3c1b174",
+	} {
+		if first.Attributes[k] != v {
+			t.Errorf("attribute %s = %q, want %q", k, first.Attributes[k], v)
+		}
+	}
+
+	second := entries[1]
+	if second.Data != "card declined" || second.Severity != "ERROR" {
+		t.Errorf("second entry = %q %q", second.Data, second.Severity)
+	}
+	// without a time the observed time is used
+	if want := time.Unix(0, 1767225601000000000); !second.CreatedAt.Equal(want) {
+		t.Errorf("second entry created at %v, want %v", second.CreatedAt, want)
+	}
+}
+
+func gzipped(t *testing.T, raw []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	tests := []struct {
+		name        string
+		sample      string
+		contentType string
+		gzip        bool
+	}{
+		{"protobuf", "otlp-logs.pb", "application/x-protobuf", false},
+		{"json", "otlp-logs.json", "application/json", false},
+		{"gzipped protobuf", "otlp-logs.pb", "application/x-protobuf", true},
+		{"gzipped json", "otlp-logs.json", "application/json; charset=utf-8", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, ch := collect("default")
+			srv := httptest.NewServer(OTLPHandler(l))
+			defer srv.Close()
+
+			body := otlpSample(t, tt.sample)
+			if tt.gzip {
+				body = gzipped(t, body)
+			}
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/logs", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d", resp.StatusCode)
+			}
+
+			checkOTLPSample(t, receive(t, ch, 2))
+		})
+	}
+}
+
+func TestOTLPHTTPRejected(t *testing.T) {
+	l, _ := collect("default")
+	// the recorded request holds two records
+	l.MaxBatch = 1
+	srv := httptest.NewServer(OTLPHandler(l))
+	defer srv.Close()
+
+	// a body that only passes the limit after decompression
+	oversized := gzipped(t, make([]byte, maxOTLPBody+1))
+
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        []byte
+		want        int
+	}{
+		{"too large", "application/x-protobuf", "gzip", oversized, http.StatusRequestEntityTooLarge},
+		{"content type", "text/plain", "", otlpSample(t, "otlp-logs.json"), http.StatusUnsupportedMediaType},
+		{"malformed", "application/json", "", []byte(`{"resourceLogs":`), http.StatusBadRequest},
+		{"trace id", "application/json", "", []byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"traceId":"xyz"}]}]}]}`), http.StatusBadRequest},
+		{"gzip", "application/json", "gzip", []byte("not gzip"), http.StatusBadRequest},
+		{"batch", "application/json", "", otlpSample(t, "otlp-logs.json"), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/logs", bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestOTLPHTTPLimit(t *testing.T) {
+	l, ch := collect("default")
+	srv := httptest.NewServer(OTLPHandler(l))
+	defer srv.Close()
+
+	// a valid request padded to exactly the limit with an unknown field is accepted
+	raw := otlpSample(t, "otlp-logs.pb")
+	padding := maxOTLPBody - len(raw) - 6
+	raw = append(raw, protowireBytes(1000, make([]byte, padding))...)
+	if len(raw) != maxOTLPBody {
+		t.Fatalf("padded body is %d bytes, want %d", len(raw), maxOTLPBody)
+	}
+
+	resp, err := http.Post(srv.URL+"/v1/logs", "application/x-protobuf", bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	checkOTLPSample(t, receive(t, ch, 2))
+}
+
+// protowireBytes encodes a length-delimited field with a 2 byte tag and a 4 byte length.
+func protowireBytes(field int, value []byte) []byte {
+	key := uint64(field)<<3 | 2
+	out := []byte{byte(key) | 0x80, byte(key >> 7)}
+	n := len(value)
+	out = append(out, byte(n)|0x80, byte(n>>7)|0x80, byte(n>>14)|0x80, byte(n>>21))
+	return append(out, value...)
+}
+
+func TestOTLPGRPC(t *testing.T) {
+	l, ch := collect("default")
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newOTLPGRPCServer(l)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	req := &collogspb.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(otlpSample(t, "otlp-logs.pb"), req); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := collogspb.NewLogsServiceClient(conn).Export(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	checkOTLPSample(t, receive(t, ch, 2))
+}
+
+func TestOTLPGRPCBatchTooLarge(t *testing.T) {
+	l, _ := collect("default")
+	l.MaxBatch = 1
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newOTLPGRPCServer(l)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	req := &collogspb.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(otlpSample(t, "otlp-logs.pb"), req); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = collogspb.NewLogsServiceClient(conn).Export(ctx, req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("err = %v, want %v", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,263 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"logger-service/data"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSyslogMessage is the largest syslog message accepted, over either transport.
+const maxSyslogMessage = 64 * 1024
+
+// syslogSeverities maps the RFC 5424 severity (PRI mod 8) onto LogEntry.Severity.
+var syslogSeverities = [8]string{
+	"EMERGENCY", "ALERT", "CRITICAL", "ERROR", "WARNING", "NOTICE", "INFO", "DEBUG",
+}
+
+const syslogNil = "-"
+
+// ParseSyslog decodes an RFC 5424 message:
+//
+//	<PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+//
+// APP-NAME becomes the entry name (defaultName when it is nil), MSG the data and the
+// header fields and structured data become attributes. SD params are stored as
+// "sd.<SD-ID>.<PARAM>".
+func ParseSyslog(msg []byte, defaultName string) (data.LogEntry, error) {
+	var entry data.LogEntry
+	s := strings.TrimRight(string(msg), "\r\n")
+
+	if !strings.HasPrefix(s, "<") {
+		return entry, errors.New("syslog: missing PRI")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return entry, errors.New("syslog: malformed PRI")
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri > 191 {
+		return entry, errors.New("syslog: malformed PRI")
+	}
+	s = s[end+1:]
+
+	fields := make([]string, 0, 6)
+	for range 6 {
+		var field string
+		field, s, _ = strings.Cut(s, " ")
+		if field == "" {
+			return entry, errors.New("syslog: truncated header")
+		}
+		fields = append(fields, field)
+	}
+	version, timestamp, hostname, appName, procID, msgID := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+	if version != "1" {
+		return entry, fmt.Errorf("syslog: unsupported version %q", version)
+	}
+
+	entry.Attributes = map[string]string{
+		"syslog.facility": strconv.Itoa(pri / 8),
+	}
+	entry.Severity = syslogSeverities[pri%8]
+
+	if timestamp != syslogNil {
+		if entry.CreatedAt, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return entry, fmt.Errorf("syslog: malformed timestamp %q", timestamp)
+		}
+	}
+	if hostname != syslogNil {
+		entry.Attributes["host.name"] = hostname
+	}
+	entry.Name = defaultName
+	if appName != syslogNil {
+		entry.Name = appName
+	}
+	if procID != syslogNil {
+		entry.Attributes["syslog.procid"] = procID
+	}
+	if msgID != syslogNil {
+		entry.Attributes["syslog.msgid"] = msgID
+	}
+
+	s, err = parseStructuredData(s, entry.Attributes)
+	if err != nil {
+		return entry, err
+	}
+
+	s = strings.TrimPrefix(s, " ")
+	entry.Data = strings.TrimPrefix(s, "\ufeff")
+
+	return entry, nil
+}
+
+// parseStructuredData consumes the STRUCTURED-DATA part of a message into attrs and
+// returns the remainder.
+func parseStructuredData(s string, attrs map[string]string) (string, error) {
+	if strings.HasPrefix(s, syslogNil) {
+		return s[1:], nil
+	}
+
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return s, errors.New("syslog: malformed structured data")
+		}
+		id := s[:end]
+		s = s[end:]
+
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+
+			name, rest, ok := strings.Cut(s, `="`)
+			if !ok || name == "" {
+				return s, errors.New("syslog: malformed structured data param")
+			}
+
+			var value strings.Builder
+			i := 0
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) && strings.IndexByte(`"\]`, rest[i+1]) >= 0 {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				return s, errors.New("syslog: unterminated structured data value")
+			}
+
+			attrs["sd."+id+"."+name] = value.String()
+			s = rest[i+1:]
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return s, errors.New("syslog: unterminated structured data element")
+		}
+		s = s[1:]
+	}
+
+	return s, nil
+}
+
+// ServeSyslogUDP receives one RFC 5424 message per datagram.
+func ServeSyslogUDP(l Listener) error {
+	conn, err := net.ListenPacket("udp", l.Addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Syslog UDP listener on", l.Addr)
+	return l.serveSyslogPackets(conn)
+}
+
+func (l Listener) serveSyslogPackets(conn net.PacketConn) error {
+	defer conn.Close()
+
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		l.handleSyslog(buf[:n])
+	}
+}
+
+// ServeSyslogTCP accepts RFC 6587 syslog streams. Each connection may use octet-counting
+// ("LEN SP MSG") or newline-delimited framing, detected from its first byte.
+func ServeSyslogTCP(l Listener) error {
+	lis, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Syslog TCP listener on", l.Addr)
+	return l.serveSyslogStreams(lis)
+}
+
+func (l Listener) serveSyslogStreams(lis net.Listener) error {
+	defer lis.Close()
+
+	for {
+		conn, err := lis.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			log.Println("Syslog accept error:", err)
+			continue
+		}
+		go l.serveSyslogConn(conn)
+	}
+}
+
+func (l Listener) serveSyslogConn(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReaderSize(conn, maxSyslogMessage)
+	for {
+		msg, err := readSyslogFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Println("Syslog TCP read error:", err)
+			}
+			return
+		}
+		if len(msg) > 0 {
+			l.handleSyslog(msg)
+		}
+	}
+}
+
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] < '0' || first[0] > '9' {
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			err = nil
+		}
+		return bytes.TrimRight(line, "\r\n"), err
+	}
+
+	lenField, err := r.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(lenField, " "))
+	if err != nil || n <= 0 || n > maxSyslogMessage {
+		return nil, fmt.Errorf("invalid frame length %q", lenField)
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (l Listener) handleSyslog(msg []byte) {
+	entry, err := ParseSyslog(msg, l.Name)
+	if err != nil {
+		log.Println("Dropping syslog message:", err)
+		return
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	if err := l.write(entry); err != nil {
+		log.Println("Error writing syslog message:", err)
+	}
+}
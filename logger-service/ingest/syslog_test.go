@@ -0,0 +1,182 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"logger-service/data"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// collect returns a listener whose sink hands every stored entry to the channel.
+func collect(name string) (Listener, <-chan data.LogEntry) {
+	ch := make(chan data.LogEntry, 100)
+	return Listener{
+		Name: name,
+		Sink: func(ctx context.Context, entries ...data.LogEntry) error {
+			for _, entry := range entries {
+				ch <- entry
+			}
+			return nil
+		},
+	}, ch
+}
+
+// receive waits for n entries.
+func receive(t *testing.T, ch <-chan data.LogEntry, n int) []data.LogEntry {
+	t.Helper()
+
+	var entries []data.LogEntry
+	timeout := time.After(5 * time.Second)
+	for len(entries) < n {
+		select {
+		case entry := <-ch:
+			entries = append(entries, entry)
+		case <-timeout:
+			t.Fatalf("received %d entries, want %d", len(entries), n)
+		}
+	}
+	return entries
+}
+
+// syslogSamples returns the recorded RFC 5424 messages, one per line.
+func syslogSamples(t *testing.T) [][]byte {
+	t.Helper()
+
+	raw, err := os.ReadFile("testdata/syslog-rfc5424.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Split(bytes.TrimRight(raw, "\n"), []byte("\n"))
+}
+
+// checkSyslogSamples compares what the listener stored for the recorded samples.
+func checkSyslogSamples(t *testing.T, entries []data.LogEntry) {
+	t.Helper()
+
+	want := []struct {
+		name, severity, data string
+		attrs                map[string]string
+	}{
+		{"su", "CRITICAL", "'su root' failed for lonvick on /dev/pts/8", map[string]string{
+			"syslog.facility": "4", "host.name": "mymachine.example.com", "syslog.msgid": "ID47",
+		}},
+		{"myproc", "NOTICE", "%% It's time to make the do-nuts.", map[string]string{
+			"syslog.facility": "20", "host.name": "192.0.2.1", "syslog.procid": "8710",
+		}},
+		{"evntslog", "NOTICE", "An application event log entry...", map[string]string{
+			"sd.exampleSDID@32473.iut": "3", "sd.exampleSDID@32473.eventSource": "Application", "sd.exampleSDID@32473.eventID": "1011",
+		}},
+		{"default", "NOTICE", "", map[string]string{
+			"sd.exampleSDID@32473.iut": "3", "sd.examplePriority@32473.class": "high",
+		}},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		got := entries[i]
+		if got.Name != w.name || got.Severity != w.severity || got.Data != w.data {
+			t.Errorf("entry %d = %q %q %q, want %q %q %q", i, got.Name, got.Severity, got.Data, w.name, w.severity, w.data)
+		}
+		for k, v := range w.attrs {
+			if got.Attributes[k] != v {
+				t.Errorf("entry %d: attribute %s = %q, want %q", i, k, got.Attributes[k], v)
+			}
+		}
+		if got.CreatedAt.IsZero() {
+			t.Errorf("entry %d has no timestamp", i)
+		}
+	}
+
+	if want := time.Date(2026, 1, 1, 7, 0, 1, 3000, time.UTC); !entries[1].CreatedAt.Equal(want) {
+		t.Errorf("entry 1 created at %v, want %v", entries[1].CreatedAt, want)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	l, ch := collect("default")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go l.serveSyslogPackets(conn)
+	defer conn.Close()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	samples := syslogSamples(t)
+	var entries []data.LogEntry
+	// one at a time so the order is kept
+	for _, msg := range samples {
+		if _, err := client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, receive(t, ch, 1)...)
+	}
+	checkSyslogSamples(t, entries)
+}
+
+func TestSyslogTCP(t *testing.T) {
+	samples := syslogSamples(t)
+
+	framings := []struct {
+		name  string
+		frame func(msg []byte) []byte
+	}{
+		{"newline", func(msg []byte) []byte { return append(msg, '\n') }},
+		{"crlf", func(msg []byte) []byte { return append(msg, '\r', '\n') }},
+		{"octet counting", func(msg []byte) []byte { return fmt.Appendf(nil, "%d %s", len(msg), msg) }},
+	}
+
+	for _, framing := range framings {
+		t.Run(framing.name, func(t *testing.T) {
+			l, ch := collect("default")
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go l.serveSyslogStreams(lis)
+			defer lis.Close()
+
+			client, err := net.Dial("tcp", lis.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			var stream []byte
+			for _, msg := range samples {
+				stream = append(stream, framing.frame(bytes.Clone(msg))...)
+			}
+			if _, err := client.Write(stream); err != nil {
+				t.Fatal(err)
+			}
+
+			checkSyslogSamples(t, receive(t, ch, len(samples)))
+		})
+	}
+}
+
+func TestParseSyslogInvalid(t *testing.T) {
+	for _, msg := range []string{
+		"no pri",
+		"<192>1 - - - - - -",
+		"<34>2 - - - - - -",
+		"<34>1 yesterday host app - - -",
+		"<34>1 - host app",
+		`<34>1 - host app - - [id key="unterminated`,
+	} {
+		if _, err := ParseSyslog([]byte(msg), "default"); err == nil {
+			t.Errorf("%q: no error", msg)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"logger-service/data"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Redaction modes.
+const (
+	// ModeMask replaces the sensitive value with "[REDACTED:<rule>]".
+	ModeMask = "mask"
+	// ModeHash replaces the value with a keyed hash, so equal values can still be
+	// correlated without being readable.
+	ModeHash = "hash"
+	// ModeDrop discards the whole entry when a pattern matches, or the attribute when a
+	// field rule matches.
+	ModeDrop = "drop"
+)
+
+// Pattern is a user defined detector. When the expression has capture groups only the
+// first group is redacted, so "token=(\S+)" keeps the "token=" prefix readable.
+type Pattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Mode    string `json:"mode"`
+}
+
+// Field redacts attributes by key. A key matches when it equals Name or ends in Name
+// after a "." , "_" or "-", ignoring case: "password" matches "db.password".
+type Field struct {
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+}
+
+// Config selects the redaction rules:
+//
+//	{
+//	  "detectors": {"email": "hash", "card": "mask", "token": "mask", "password": "mask"},
+//	  "patterns": [{"name": "ssn", "pattern": "\\b\\d{3}-\\d{2}-\\d{4}\\b", "mode": "mask"}],
+//	  "fields": [{"name": "authorization", "mode": "drop"}],
+//	  "hash_key": "..."
+//	}
+//
+// Detectors enables the built-in detectors by name. Without a hash key a random one is
+// generated, so hashes are only stable for the life of the process.
+type Config struct {
+	Detectors map[string]string `json:"detectors"`
+	Patterns  []Pattern         `json:"patterns"`
+	Fields    []Field           `json:"fields"`
+	HashKey   string            `json:"hash_key"`
+}
+
+// builtinOrder is the order the built-in detectors run in: key/value credentials first,
+// so their values are not partially matched by the generic detectors.
+var builtinOrder = []string{"password", "token", "card", "email"}
+
+// builtins are the detectors available by name in Config.Detectors.
+var builtins = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	// checked with the Luhn algorithm before being redacted
+	"card": `\b(?:\d[ -]?){12,18}\d\b`,
+	"token": `(?i)(?:\bbearer\s+([A-Za-z0-9._~+/=-]+)|\b(eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*)` +
+		`|"?\b(?:api[_-]?key|access[_-]?token|refresh[_-]?token|token|secret)"?\s*[=:]\s*"?([^\s"&,;]+))`,
+	"password": `(?i)"?\b(?:pass(?:word|wd)?|pwd)"?\s*[=:]\s*"?([^\s"&,;]+)`,
+}
+
+// DefaultConfig enables every built-in detector in mask mode and masks the attributes
+// that usually carry credentials.
+func DefaultConfig() Config {
+	cfg := Config{Detectors: map[string]string{}}
+	for _, name := range builtinOrder {
+		cfg.Detectors[name] = ModeMask
+	}
+	for _, name := range []string{"password", "passwd", "secret", "token", "api_key", "authorization", "cookie"} {
+		cfg.Fields = append(cfg.Fields, Field{Name: name, Mode: ModeMask})
+	}
+	return cfg
+}
+
+// LoadConfig reads a Config from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+type detector struct {
+	name string
+	re   *regexp.Regexp
+	mode string
+	luhn bool
+}
+
+// Redactor removes sensitive values from log entries before they are stored. It is
+// safe for concurrent use.
+type Redactor struct {
+	detectors []detector
+	fields    []Field
+	key       []byte
+}
+
+func validMode(mode string) bool {
+	return mode == ModeMask || mode == ModeHash || mode == ModeDrop
+}
+
+// New compiles the config.
+func New(cfg Config) (*Redactor, error) {
+	r := &Redactor{key: []byte(cfg.HashKey)}
+	if len(r.key) == 0 {
+		r.key = make([]byte, 32)
+		rand.Read(r.key)
+	}
+
+	for name := range cfg.Detectors {
+		if _, ok := builtins[name]; !ok {
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+	}
+	for _, name := range builtinOrder {
+		mode, ok := cfg.Detectors[name]
+		if !ok {
+			continue
+		}
+		if !validMode(mode) {
+			return nil, fmt.Errorf("detector %q: unknown mode %q", name, mode)
+		}
+		r.detectors = append(r.detectors, detector{
+			name: name,
+			re:   regexp.MustCompile(builtins[name]),
+			mode: mode,
+			luhn: name == "card",
+		})
+	}
+
+	for _, p := range cfg.Patterns {
+		if p.Name == "" {
+			return nil, fmt.Errorf("pattern %q needs a name", p.Pattern)
+		}
+		if !validMode(p.Mode) {
+			return nil, fmt.Errorf("pattern %q: unknown mode %q", p.Name, p.Mode)
+		}
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", p.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{name: p.Name, re: re, mode: p.Mode})
+	}
+
+	for _, f := range cfg.Fields {
+		if f.Name == "" || !validMode(f.Mode) {
+			return nil, fmt.Errorf("field rule %q: unknown mode %q", f.Name, f.Mode)
+		}
+		r.fields = append(r.fields, Field{Name: strings.ToLower(f.Name), Mode: f.Mode})
+	}
+
+	return r, nil
+}
+
+// Entry redacts the name, data and attribute values of an entry in place. It reports
+// false when a drop rule matched and the entry must not be stored at all.
+func (r *Redactor) Entry(entry *data.LogEntry) bool {
+	var keep bool
+	// the name comes from the caller too (syslog APP-NAME, OTLP service.name)
+	if entry.Name, keep = r.text(entry.Name); !keep {
+		return false
+	}
+	if entry.Data, keep = r.text(entry.Data); !keep {
+		return false
+	}
+
+	if len(entry.Attributes) == 0 {
+		return true
+	}
+
+	// never modify the caller's map
+	attrs := make(map[string]string, len(entry.Attributes))
+	for key, value := range entry.Attributes {
+		if mode, ok := r.fieldMode(key); ok {
+			if mode != ModeDrop {
+				attrs[key] = r.replace(key, value, mode)
+			}
+			continue
+		}
+
+		if attrs[key], keep = r.text(value); !keep {
+			return false
+		}
+	}
+	entry.Attributes = attrs
+
+	return true
+}
+
+func (r *Redactor) fieldMode(key string) (string, bool) {
+	key = strings.ToLower(key)
+	for _, f := range r.fields {
+		if key == f.Name {
+			return f.Mode, true
+		}
+		if prefix, ok := strings.CutSuffix(key, f.Name); ok && strings.ContainsAny(prefix[len(prefix)-1:], "._-") {
+			return f.Mode, true
+		}
+	}
+	return "", false
+}
+
+// text runs every detector over s.
+func (r *Redactor) text(s string) (string, bool) {
+	for _, d := range r.detectors {
+		matches := d.re.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var out strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			// redact only the first group that took part in the match, if any
+			for g := 2; g < len(m); g += 2 {
+				if m[g] >= 0 {
+					start, end = m[g], m[g+1]
+					break
+				}
+			}
+
+			value := s[start:end]
+			if d.luhn && !luhnValid(value) {
+				continue
+			}
+			if d.mode == ModeDrop {
+				return "", false
+			}
+
+			out.WriteString(s[last:start])
+			out.WriteString(r.replace(d.name, value, d.mode))
+			last = end
+		}
+		out.WriteString(s[last:])
+		s = out.String()
+	}
+
+	return s, true
+}
+
+func (r *Redactor) replace(name, value, mode string) string {
+	if mode == ModeHash {
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(value))
+		return "[" + name + ":" + hex.EncodeToString(mac.Sum(nil))[:16] + "]"
+	}
+	return "[REDACTED:" + name + "]"
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum used by card numbers.
+func luhnValid(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
@@ -0,0 +1,136 @@
+package redact
+
+import (
+	"logger-service/data"
+	"strings"
+	"testing"
+)
+
+func newRedactor(t *testing.T, cfg Config) *Redactor {
+	t.Helper()
+
+	cfg.HashKey = "test"
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestDetectors(t *testing.T) {
+	r := newRedactor(t, DefaultConfig())
+
+	tests := []struct {
+		in, want string
+	}{
+		{"login by jane@example.com", "login by [REDACTED:email]"},
+		{"paid with 4111 1111 1111 1111", "paid with [REDACTED:card]"},
+		// fails the Luhn check, so it is an order number rather than a card
+		{"order 4111 1111 1111 1112", "order 4111 1111 1111 1112"},
+		{"Authorization: Bearer abc.def-123", "Authorization: Bearer [REDACTED:token]"},
+		{"GET /hook?api_key=s3cr3t&page=2", "GET /hook?api_key=[REDACTED:token]&page=2"},
+		{`{"password":"hunter2"}`, `{"password":"[REDACTED:password]"}`},
+		{"pwd=hunter2 user=jane", "pwd=[REDACTED:password] user=jane"},
+		{"nothing to see", "nothing to see"},
+	}
+
+	for _, tt := range tests {
+		got, keep := r.text(tt.in)
+		if !keep || got != tt.want {
+			t.Errorf("text(%q) = %q, %v, want %q", tt.in, got, keep, tt.want)
+		}
+	}
+}
+
+func TestEntry(t *testing.T) {
+	r := newRedactor(t, Config{
+		Detectors: map[string]string{"email": ModeHash, "password": ModeMask},
+		Fields:    []Field{{Name: "authorization", Mode: ModeDrop}, {Name: "secret", Mode: ModeMask}},
+	})
+
+	attrs := map[string]string{
+		"Authorization":  "Basic amFuZTpodW50ZXIy",
+		"db.secret":      "s3cr3t",
+		"user":           "jane@example.com",
+		"client_secrets": "kept",
+	}
+	entry := data.LogEntry{
+		Name:       "signup jane@example.com",
+		Data:       "password=hunter2",
+		Attributes: attrs,
+	}
+	if !r.Entry(&entry) {
+		t.Fatal("the entry was dropped")
+	}
+
+	hashed := r.replace("email", "jane@example.com", ModeHash)
+	if want := "signup " + hashed; entry.Name != want {
+		t.Errorf("name = %q, want %q", entry.Name, want)
+	}
+	if entry.Data != "password=[REDACTED:password]" {
+		t.Errorf("data = %q", entry.Data)
+	}
+	if _, ok := entry.Attributes["Authorization"]; ok {
+		t.Error("the dropped attribute was kept")
+	}
+	if got := entry.Attributes["db.secret"]; got != "[REDACTED:db.secret]" {
+		t.Errorf("db.secret = %q", got)
+	}
+	if got := entry.Attributes["user"]; got != hashed {
+		t.Errorf("user = %q, want %q", got, hashed)
+	}
+	if got := entry.Attributes["client_secrets"]; got != "kept" {
+		t.Errorf("client_secrets = %q", got)
+	}
+	if attrs["db.secret"] != "s3cr3t" {
+		t.Error("the caller's attributes were modified")
+	}
+}
+
+func TestEntryDrop(t *testing.T) {
+	r := newRedactor(t, Config{Patterns: []Pattern{{Name: "ssn", Pattern: `\b\d{3}-\d{2}-\d{4}\b`, Mode: ModeDrop}}})
+
+	for _, entry := range []data.LogEntry{
+		{Name: "123-45-6789", Data: "x"},
+		{Name: "a", Data: "ssn 123-45-6789"},
+		{Name: "a", Data: "x", Attributes: map[string]string{"ssn": "123-45-6789"}},
+	} {
+		if r.Entry(&entry) {
+			t.Errorf("%+v was kept", entry)
+		}
+	}
+}
+
+func TestHashIsKeyed(t *testing.T) {
+	a := newRedactor(t, Config{Detectors: map[string]string{"email": ModeHash}})
+	b, err := New(Config{Detectors: map[string]string{"email": ModeHash}, HashKey: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ha, _ := a.text("jane@example.com")
+	hb, _ := b.text("jane@example.com")
+	if ha == hb {
+		t.Errorf("both keys hashed to %q", ha)
+	}
+	if again, _ := a.text("jane@example.com"); again != ha {
+		t.Errorf("the hash is not stable: %q, %q", ha, again)
+	}
+	if strings.Contains(ha, "jane") {
+		t.Errorf("hash %q leaks the value", ha)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Detectors: map[string]string{"ssn": ModeMask}},
+		{Detectors: map[string]string{"email": "blur"}},
+		{Patterns: []Pattern{{Pattern: "x", Mode: ModeMask}}},
+		{Patterns: []Pattern{{Name: "x", Pattern: "(", Mode: ModeMask}}},
+		{Fields: []Field{{Name: "x", Mode: ""}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%+v: no error", cfg)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnauthorized is returned for a missing or unknown API key.
+	ErrUnauthorized = errors.New("missing or unknown API key")
+	// ErrRateLimited is returned when a tenant exceeds its ingest rate.
+	ErrRateLimited = errors.New("tenant ingest rate exceeded")
+	// ErrBatchTooLarge is returned for a batch larger than the tenant's burst, which
+	// could never be admitted however long the caller waits.
+	ErrBatchTooLarge = errors.New("batch exceeds the tenant ingest burst")
+	// ErrStorageQuota is returned when a write would exceed a tenant's stored entries.
+	ErrStorageQuota = errors.New("tenant storage quota exceeded")
+)
+
+// Quota limits what a tenant may write. Zero values mean unlimited.
+type Quota struct {
+	// Rate is the sustained ingest rate in entries per second.
+	Rate float64 `json:"rate,omitempty"`
+	// Burst is how many entries may be written at once; it defaults to one second of Rate.
+	// Larger batches are rejected with ErrBatchTooLarge.
+	Burst int `json:"burst,omitempty"`
+	// MaxEntries caps the number of stored entries.
+	MaxEntries int64 `json:"max_entries,omitempty"`
+}
+
+// Tenant is one team sharing the logging pipeline. Callers are mapped to a tenant by
+// one of its API keys.
+type Tenant struct {
+	ID    string   `json:"id"`
+	Keys  []string `json:"keys,omitempty"`
+	Quota Quota    `json:"quota"`
+}
+
+// Config is the content of the tenants file:
+//
+//	{
+//	  "anonymous": "platform",
+//	  "tenants": [
+//	    {"id": "platform", "keys": ["..."]},
+//	    {"id": "payments", "keys": ["..."], "quota": {"rate": 100, "max_entries": 1000000}}
+//	  ]
+//	}
+//
+// Anonymous names the tenant of callers without a key; when empty they are rejected.
+type Config struct {
+	Anonymous string   `json:"anonymous,omitempty"`
+	Tenants   []Tenant `json:"tenants"`
+}
+
+// Usage is what a tenant has written since the service started. Stored is only known
+// once the tenant has written, or when filled in by the caller.
+type Usage struct {
+	Tenant   string `json:"tenant"`
+	Ingested int64  `json:"ingested"`
+	Rejected int64  `json:"rejected"`
+	Stored   int64  `json:"stored"`
+}
+
+type state struct {
+	tenant Tenant
+
+	ingested int64
+	rejected int64
+
+	stored      int64
+	storedKnown bool
+
+	tokens float64
+	last   time.Time
+}
+
+// Registry resolves API keys to tenants and enforces their quotas. A registry without
+// tenants runs in single-tenant mode: every caller is the empty tenant and nothing is
+// limited. It is safe for concurrent use.
+type Registry struct {
+	anonymous string
+	keys      map[string]string
+
+	mu     sync.Mutex
+	states map[string]*state
+	now    func() time.Time
+}
+
+// Single returns a registry in single-tenant mode.
+func Single() *Registry {
+	return &Registry{
+		states: map[string]*state{"": {}},
+		now:    time.Now,
+	}
+}
+
+// New validates the config and returns a registry for it.
+func New(cfg Config) (*Registry, error) {
+	if len(cfg.Tenants) == 0 {
+		return Single(), nil
+	}
+
+	r := &Registry{
+		anonymous: cfg.Anonymous,
+		keys:      map[string]string{},
+		states:    map[string]*state{},
+		now:       time.Now,
+	}
+
+	for _, t := range cfg.Tenants {
+		if t.ID == "" {
+			return nil, errors.New("tenant id is required")
+		}
+		if _, ok := r.states[t.ID]; ok {
+			return nil, fmt.Errorf("duplicate tenant %q", t.ID)
+		}
+		if t.Quota.Rate < 0 || t.Quota.Burst < 0 || t.Quota.MaxEntries < 0 {
+			return nil, fmt.Errorf("tenant %q: quotas cannot be negative", t.ID)
+		}
+		if t.Quota.Rate > 0 && t.Quota.Burst == 0 {
+			t.Quota.Burst = max(1, int(t.Quota.Rate))
+		}
+
+		for _, key := range t.Keys {
+			if _, ok := r.keys[key]; ok {
+				return nil, fmt.Errorf("tenant %q: API key is already in use", t.ID)
+			}
+			r.keys[key] = t.ID
+		}
+		r.states[t.ID] = &state{tenant: t, tokens: float64(t.Quota.Burst)}
+	}
+
+	if _, ok := r.states[cfg.Anonymous]; cfg.Anonymous != "" && !ok {
+		return nil, fmt.Errorf("anonymous tenant %q is not defined", cfg.Anonymous)
+	}
+
+	return r, nil
+}
+
+// Load reads a Config from a JSON file.
+func Load(path string) (*Registry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return New(cfg)
+}
+
+// Resolve returns the tenant of an API key. An empty key resolves to the anonymous
+// tenant, if there is one.
+func (r *Registry) Resolve(key string) (string, error) {
+	if r.keys == nil {
+		return "", nil
+	}
+	if key == "" {
+		if r.anonymous == "" {
+			return "", ErrUnauthorized
+		}
+		return r.anonymous, nil
+	}
+
+	id, ok := r.keys[key]
+	if !ok {
+		return "", ErrUnauthorized
+	}
+	return id, nil
+}
+
+// Tenants lists the tenants and their quotas, without their keys.
+func (r *Registry) Tenants() []Tenant {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tenants := make([]Tenant, 0, len(r.states))
+	for id, s := range r.states {
+		tenants = append(tenants, Tenant{ID: id, Quota: s.tenant.Quota})
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+
+	return tenants
+}
+
+// Usage returns the usage counters of every tenant.
+func (r *Registry) Usage() []Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usage := make([]Usage, 0, len(r.states))
+	for id, s := range r.states {
+		usage = append(usage, Usage{Tenant: id, Ingested: s.ingested, Rejected: s.rejected, Stored: s.stored})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Tenant < usage[j].Tenant })
+
+	return usage
+}
+
+// MaxBatch returns the largest batch the tenant may write at once, or 0 when there is no
+// limit. Callers that batch entries themselves keep their batches within it.
+func (r *Registry) MaxBatch(id string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.states[id]
+	if !ok || s.tenant.Quota.Rate <= 0 {
+		return 0
+	}
+	return s.tenant.Quota.Burst
+}
+
+// Admit checks n new entries against the tenant's quotas and reserves them. stored counts
+// the entries the tenant already has; it is only called the first time a storage quota
+// has to be checked. Writes that fail after being admitted must be handed back with
+// Release.
+func (r *Registry) Admit(id string, n int, stored func() (int64, error)) error {
+	r.mu.Lock()
+	s, ok := r.states[id]
+	needCount := ok && s.tenant.Quota.MaxEntries > 0 && !s.storedKnown
+	r.mu.Unlock()
+
+	if !ok {
+		return ErrUnauthorized
+	}
+
+	var count int64
+	if needCount {
+		var err error
+		if count, err = stored(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if needCount && !s.storedKnown {
+		s.stored = count
+		s.storedKnown = true
+	}
+
+	quota := s.tenant.Quota
+	if quota.Rate > 0 {
+		now := r.now()
+		s.tokens = min(float64(quota.Burst), s.tokens+now.Sub(s.last).Seconds()*quota.Rate)
+		s.last = now
+
+		if n > quota.Burst {
+			s.rejected += int64(n)
+			return ErrBatchTooLarge
+		}
+		if s.tokens < float64(n) {
+			s.rejected += int64(n)
+			return ErrRateLimited
+		}
+		s.tokens -= float64(n)
+	}
+
+	if quota.MaxEntries > 0 && s.stored+int64(n) > quota.MaxEntries {
+		s.rejected += int64(n)
+		return ErrStorageQuota
+	}
+
+	s.ingested += int64(n)
+	s.stored += int64(n)
+	return nil
+}
+
+// Release hands back n entries that were admitted but not stored, or that were deleted.
+func (r *Registry) Release(id string, n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.states[id]; ok {
+		s.stored = max(0, s.stored-n)
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the tenant id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant id carried by ctx, or the empty tenant.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,92 @@
+package tenant
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newLimited returns a registry with one rate limited tenant and a clock the test moves.
+func newLimited(t *testing.T, quota Quota) (*Registry, *time.Time) {
+	t.Helper()
+
+	r, err := New(Config{Tenants: []Tenant{{ID: "a", Keys: []string{"key"}, Quota: quota}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	return r, &now
+}
+
+func noCount() (int64, error) { return 0, nil }
+
+func TestAdmitRate(t *testing.T) {
+	r, now := newLimited(t, Quota{Rate: 10})
+
+	if err := r.Admit("a", 10, noCount); err != nil {
+		t.Fatalf("a full burst: %v", err)
+	}
+	if err := r.Admit("a", 1, noCount); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("past the burst: err = %v, want %v", err, ErrRateLimited)
+	}
+
+	*now = now.Add(500 * time.Millisecond)
+	if err := r.Admit("a", 5, noCount); err != nil {
+		t.Fatalf("after refilling half a second: %v", err)
+	}
+	if err := r.Admit("a", 1, noCount); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want %v", err, ErrRateLimited)
+	}
+}
+
+func TestAdmitBatchTooLarge(t *testing.T) {
+	r, now := newLimited(t, Quota{Rate: 10, Burst: 20})
+
+	if got := r.MaxBatch("a"); got != 20 {
+		t.Errorf("MaxBatch = %d, want 20", got)
+	}
+
+	// no amount of waiting admits a batch larger than the burst, so it is told apart
+	// from being rate limited
+	*now = now.Add(time.Hour)
+	if err := r.Admit("a", 21, noCount); !errors.Is(err, ErrBatchTooLarge) {
+		t.Fatalf("err = %v, want %v", err, ErrBatchTooLarge)
+	}
+	if err := r.Admit("a", 20, noCount); err != nil {
+		t.Fatalf("a batch of the burst: %v", err)
+	}
+
+	usage := r.Usage()
+	if usage[0].Ingested != 20 || usage[0].Rejected != 21 {
+		t.Errorf("usage = %+v", usage[0])
+	}
+}
+
+func TestAdmitStorageQuota(t *testing.T) {
+	r, _ := newLimited(t, Quota{MaxEntries: 5})
+
+	counted := 0
+	stored := func() (int64, error) {
+		counted++
+		return 3, nil
+	}
+
+	if err := r.Admit("a", 2, stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Admit("a", 1, stored); !errors.Is(err, ErrStorageQuota) {
+		t.Fatalf("err = %v, want %v", err, ErrStorageQuota)
+	}
+	if counted != 1 {
+		t.Errorf("the store was counted %d times, want once", counted)
+	}
+
+	r.Release("a", 1)
+	if err := r.Admit("a", 1, stored); err != nil {
+		t.Fatalf("after a release: %v", err)
+	}
+	if got := r.MaxBatch("a"); got != 0 {
+		t.Errorf("MaxBatch without a rate = %d, want 0", got)
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"text/template"
+	"html/template"
 	"time"
 
 	gomail "github.com/go-mail/mail/v2"
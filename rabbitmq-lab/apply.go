@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// runApply declares the topology of a file. Declaring is idempotent: what exists with the
+// same settings is left alone, what is missing is created. Something that exists with
+// other settings cannot be changed by a declare; it is reported and the rest is applied.
+func runApply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	file := fs.String("f", "topology.yaml", "topology file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	t, err := loadTopology(*file)
+	if err != nil {
+		return err
+	}
+
+	c, err := connectRabbitMQ(conn.url)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var errs []error
+	for _, e := range t.Exchanges {
+		created, err := ensure(c,
+			func(ch *amqp.Channel) error {
+				return ch.ExchangeDeclarePassive(e.Name, e.Type, e.durable(), e.AutoDelete, e.Internal, false, nil)
+			},
+			func(ch *amqp.Channel) error { return declareExchange(ch, e) })
+		errs = append(errs, report("exchange", e.Name, created, err))
+	}
+
+	for _, q := range t.Queues {
+		created, err := ensure(c,
+			func(ch *amqp.Channel) error {
+				_, err := ch.QueueDeclarePassive(q.Name, q.durable(), q.AutoDelete, false, false, nil)
+				return err
+			},
+			func(ch *amqp.Channel) error { return declareQueue(ch, q) })
+		errs = append(errs, report("queue", q.Name, created, err))
+	}
+
+	for _, b := range t.Bindings {
+		err := withChannel(c, func(ch *amqp.Channel) error { return bindQueue(ch, b) })
+		if err != nil {
+			errs = append(errs, fmt.Errorf("binding %s: %w", bindingName(b), err))
+			continue
+		}
+		fmt.Printf("bound     %s\n", bindingName(b))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ensure checks whether a resource exists, then declares it. It reports whether the
+// resource was created.
+func ensure(c *amqp.Connection, exists, declare func(*amqp.Channel) error) (bool, error) {
+	// a failed passive declare closes its channel, so every step gets its own
+	created := withChannel(c, exists) != nil
+	return created, withChannel(c, declare)
+}
+
+func withChannel(c *amqp.Connection, fn func(*amqp.Channel) error) error {
+	ch, err := createChannel(c)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return fn(ch)
+}
+
+func report(kind, name string, created bool, err error) error {
+	var amqpErr *amqp.Error
+	switch {
+	case errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed:
+		return fmt.Errorf("%s %s exists with other settings, run diff to see them: %s", kind, name, amqpErr.Reason)
+	case err != nil:
+		return fmt.Errorf("%s %s: %w", kind, name, err)
+	case created:
+		fmt.Printf("created   %s %s\n", kind, name)
+	default:
+		fmt.Printf("unchanged %s %s\n", kind, name)
+	}
+	return nil
+}
+
+func bindingName(b Binding) string {
+	return fmt.Sprintf("%s -> %s (%s)", b.Exchange, b.Queue, b.RoutingKey)
+}
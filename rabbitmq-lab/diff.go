@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// runDiff compares a topology file with the server and prints what apply would create
+// (+), what differs (~) and what exists on the server only (-). It returns the number
+// of differences.
+func runDiff(args []string) (int, error) {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	file := fs.String("f", "topology.yaml", "topology file")
+	if err := fs.Parse(args); err != nil {
+		return 0, err
+	}
+
+	want, err := loadTopology(*file)
+	if err != nil {
+		return 0, err
+	}
+	api, err := conn.management()
+	if err != nil {
+		return 0, err
+	}
+	have, err := api.current()
+	if err != nil {
+		return 0, err
+	}
+
+	changes := diffTopology(want, have)
+	for _, line := range changes {
+		fmt.Println(line)
+	}
+	if len(changes) == 0 {
+		fmt.Println("The server matches", *file)
+	}
+	return len(changes), nil
+}
+
+// diffTopology lists the differences between the wanted and the existing topology.
+func diffTopology(want, have *Topology) []string {
+	var changes []string
+
+	exchanges := map[string]Exchange{}
+	for _, e := range have.Exchanges {
+		exchanges[e.Name] = e
+	}
+	for _, e := range want.Exchanges {
+		got, ok := exchanges[e.Name]
+		delete(exchanges, e.Name)
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ exchange %s (%s)", e.Name, e.Type))
+			continue
+		}
+
+		var diffs []string
+		diffs = field(diffs, "type", got.Type, e.Type)
+		diffs = field(diffs, "durable", got.durable(), e.durable())
+		diffs = field(diffs, "auto_delete", got.AutoDelete, e.AutoDelete)
+		diffs = field(diffs, "internal", got.Internal, e.Internal)
+		diffs = arguments(diffs, table(got.Arguments), table(e.Arguments))
+		for _, d := range diffs {
+			changes = append(changes, fmt.Sprintf("~ exchange %s: %s", e.Name, d))
+		}
+	}
+	for _, name := range sortedKeys(exchanges) {
+		changes = append(changes, fmt.Sprintf("- exchange %s (not in file)", name))
+	}
+
+	queues := map[string]Queue{}
+	for _, q := range have.Queues {
+		queues[q.Name] = q
+	}
+	for _, q := range want.Queues {
+		got, ok := queues[q.Name]
+		delete(queues, q.Name)
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ queue %s", q.Name))
+			continue
+		}
+
+		var diffs []string
+		diffs = field(diffs, "durable", got.durable(), q.durable())
+		diffs = field(diffs, "auto_delete", got.AutoDelete, q.AutoDelete)
+		diffs = arguments(diffs, got.table(), q.table())
+		for _, d := range diffs {
+			changes = append(changes, fmt.Sprintf("~ queue %s: %s", q.Name, d))
+		}
+	}
+	for _, name := range sortedKeys(queues) {
+		changes = append(changes, fmt.Sprintf("- queue %s (not in file)", name))
+	}
+
+	bindings := map[string]bool{}
+	for _, b := range have.Bindings {
+		bindings[bindingKey(b)] = true
+	}
+	for _, b := range want.Bindings {
+		if !bindings[bindingKey(b)] {
+			changes = append(changes, "+ binding "+bindingName(b))
+		}
+		delete(bindings, bindingKey(b))
+	}
+	for _, b := range have.Bindings {
+		if bindings[bindingKey(b)] {
+			changes = append(changes, "- binding "+bindingName(b)+" (not in file)")
+		}
+	}
+
+	return changes
+}
+
+// field records a differing setting as "name: server -> file".
+func field(diffs []string, name string, got, want any) []string {
+	if got == want {
+		return diffs
+	}
+	return append(diffs, fmt.Sprintf("%s: %v -> %v", name, got, want))
+}
+
+func arguments(diffs []string, got, want map[string]any) []string {
+	keys := map[string]bool{}
+	for k := range got {
+		keys[k] = true
+	}
+	for k := range want {
+		keys[k] = true
+	}
+
+	for _, k := range sortedKeys(keys) {
+		g, inGot := got[k]
+		w, inWant := want[k]
+		switch {
+		case !inGot:
+			diffs = append(diffs, fmt.Sprintf("%s: (unset) -> %v", k, w))
+		case !inWant:
+			diffs = append(diffs, fmt.Sprintf("%s: %v -> (unset)", k, g))
+		case !reflect.DeepEqual(g, w):
+			diffs = append(diffs, fmt.Sprintf("%s: %v -> %v", k, g, w))
+		}
+	}
+	return diffs
+}
+
+func bindingKey(b Binding) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%v", b.Exchange, b.Queue, b.RoutingKey, table(b.Arguments))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
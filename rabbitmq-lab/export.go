@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runExport writes the server's topology as a topology file, so an existing setup can
+// be brought under review.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	out := fs.String("o", "", "output file (default stdout)")
+	format := fs.String("format", "", "yaml or json (default from the output file's extension, else yaml)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *format == "" {
+		*format = "yaml"
+		if strings.EqualFold(filepath.Ext(*out), ".json") {
+			*format = "json"
+		}
+	}
+	if *format != "yaml" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	api, err := conn.management()
+	if err != nil {
+		return err
+	}
+	t, err := api.current()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(t.Exchanges, func(i, j int) bool { return t.Exchanges[i].Name < t.Exchanges[j].Name })
+	sort.Slice(t.Queues, func(i, j int) bool { return t.Queues[i].Name < t.Queues[j].Name })
+	sort.Slice(t.Bindings, func(i, j int) bool { return bindingKey(t.Bindings[i]) < bindingKey(t.Bindings[j]) })
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return writeTopology(w, t, *format)
+}
+
+func writeTopology(w io.Writer, t *Topology, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(t); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -2,4 +2,7 @@ module rabbit-lab
 
 go 1.25.3
 
-require github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+require (
+	github.com/rabbitmq/amqp091-go v1.10.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// managementClient reads the current topology from the management plugin's HTTP API;
+// AMQP itself has no way to list exchanges, queues or bindings.
+type managementClient struct {
+	base     string
+	user     string
+	password string
+	vhost    string
+	client   *http.Client
+}
+
+// newManagementClient returns a client for api. Without api, the management port of the
+// AMQP host is used, with the credentials of the AMQP URL.
+func newManagementClient(api, amqpURL, vhost string) (*managementClient, error) {
+	amqp, err := url.Parse(amqpURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AMQP URL: %w", err)
+	}
+	if api == "" {
+		api = "http://" + amqp.Hostname() + ":15672"
+	}
+
+	u, err := url.Parse(api)
+	if err != nil {
+		return nil, fmt.Errorf("invalid management API URL: %w", err)
+	}
+
+	c := &managementClient{
+		vhost:  vhost,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	switch {
+	case u.User != nil:
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	case amqp.User != nil:
+		c.user = amqp.User.Username()
+		c.password, _ = amqp.User.Password()
+	default:
+		c.user, c.password = "guest", "guest"
+	}
+	u.User = nil
+	c.base = strings.TrimSuffix(u.String(), "/")
+
+	return c, nil
+}
+
+type apiExchange struct {
+	Name       string         `json:"name"`
+	Type       string         `json:"type"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Internal   bool           `json:"internal"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+type apiQueue struct {
+	Name       string         `json:"name"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Exclusive  bool           `json:"exclusive"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+type apiBinding struct {
+	Source          string         `json:"source"`
+	Destination     string         `json:"destination"`
+	DestinationType string         `json:"destination_type"`
+	RoutingKey      string         `json:"routing_key"`
+	Arguments       map[string]any `json:"arguments"`
+}
+
+func (c *managementClient) get(resource string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, c.base+"/api/"+resource+"/"+url.PathEscape(c.vhost), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("management API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("management API %s: %s %s", resource, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// current returns the topology defined on the server. Built-in exchanges, exclusive
+// queues and the implicit bindings of the default exchange are left out, as they are
+// not declared by a topology file.
+func (c *managementClient) current() (*Topology, error) {
+	var exchanges []apiExchange
+	var queues []apiQueue
+	var bindings []apiBinding
+
+	if err := c.get("exchanges", &exchanges); err != nil {
+		return nil, err
+	}
+	if err := c.get("queues", &queues); err != nil {
+		return nil, err
+	}
+	if err := c.get("bindings", &bindings); err != nil {
+		return nil, err
+	}
+
+	t := &Topology{}
+	for _, e := range exchanges {
+		if e.Name == "" || strings.HasPrefix(e.Name, "amq.") {
+			continue
+		}
+		exchange := Exchange{
+			Name:       e.Name,
+			Type:       e.Type,
+			AutoDelete: e.AutoDelete,
+			Internal:   e.Internal,
+			Arguments:  nonEmpty(e.Arguments),
+		}
+		if !e.Durable {
+			exchange.Durable = &e.Durable
+		}
+		t.Exchanges = append(t.Exchanges, exchange)
+	}
+
+	for _, q := range queues {
+		if q.Exclusive {
+			continue
+		}
+		t.Queues = append(t.Queues, queueFromArguments(q.Name, q.Durable, q.AutoDelete, nonEmpty(q.Arguments)))
+	}
+
+	for _, b := range bindings {
+		if b.Source == "" || b.DestinationType != "queue" {
+			continue
+		}
+		t.Bindings = append(t.Bindings, Binding{
+			Exchange:   b.Source,
+			Queue:      b.Destination,
+			RoutingKey: b.RoutingKey,
+			Arguments:  nonEmpty(b.Arguments),
+		})
+	}
+
+	return t, nil
+}
+
+func nonEmpty(args map[string]any) map[string]any {
+	if len(args) == 0 {
+		return nil
+	}
+	for k, v := range args {
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				args[k] = i
+			} else if f, err := n.Float64(); err == nil {
+				args[k] = f
+			}
+		}
+	}
+	return args
+}
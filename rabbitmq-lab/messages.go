@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// runPublish publishes one message and waits for its confirmation.
+func runPublish(args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	exchange := fs.String("exchange", "", "exchange (default: the default exchange, routing to the queue named by -key)")
+	key := fs.String("key", "", "routing key")
+	body := fs.String("body", "", "message body, @file to read it from a file or @- from stdin")
+	headers := fs.String("headers", "", `headers as a JSON object or as k=v,k2=v2`)
+	contentType := fs.String("content-type", "", "content type (default application/json for a JSON body, else text/plain)")
+	transient := fs.Bool("transient", false, "publish in non-persistent delivery mode")
+	mandatory := fs.Bool("mandatory", true, "fail when no queue takes the message")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	payload, err := readBody(*body)
+	if err != nil {
+		return err
+	}
+	table, err := parseHeaders(*headers)
+	if err != nil {
+		return err
+	}
+	if *contentType == "" {
+		*contentType = "text/plain"
+		if json.Valid(payload) {
+			*contentType = "application/json"
+		}
+	}
+	mode := amqp.Persistent
+	if *transient {
+		mode = amqp.Transient
+	}
+
+	c, err := connectRabbitMQ(conn.url)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ch, returns, err := confirmChannel(c)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = publishMessage(ctx, ch, returns, *exchange, *key, *mandatory, amqp.Publishing{
+		Headers:      table,
+		ContentType:  *contentType,
+		DeliveryMode: mode,
+		Timestamp:    time.Now(),
+		Body:         payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Published %d bytes to %q with routing key %q\n", len(payload), *exchange, *key)
+	return nil
+}
+
+// runConsume prints messages from a queue. They are acknowledged, or with -requeue (or
+// -ack=false) put back once all of them were printed, so none of them is received twice.
+func runConsume(args []string) error {
+	fs := flag.NewFlagSet("consume", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	queue := fs.String("queue", "", "queue to consume")
+	count := fs.Int("count", 1, "number of messages, 0 to consume until interrupted")
+	ack := fs.Bool("ack", true, "acknowledge the messages, removing them from the queue; -ack=false is -requeue")
+	requeue := fs.Bool("requeue", false, "put the messages back instead of acknowledging them")
+	wait := fs.Duration("wait", 5*time.Second, "give up when no message arrived for this long, 0 to wait forever")
+	raw := fs.Bool("raw", false, "print bodies as they are, without pretty-printing JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *queue == "" {
+		return errors.New("consume needs a -queue")
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	var err error
+	if *requeue, err = requeueMode(*ack, explicit["ack"], *requeue, *count); err != nil {
+		return err
+	}
+
+	c, err := connectRabbitMQ(conn.url)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ch, err := createChannel(c)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// stop once no message arrived for the wait
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	idle := time.AfterFunc(*wait, cancel)
+	if *wait <= 0 {
+		idle.Stop()
+	}
+
+	var last uint64
+	received := 0
+	err = consumeMessages(ctx, ch, *queue, *count, func(d amqp.Delivery) error {
+		if *wait > 0 {
+			idle.Reset(*wait)
+		}
+		received++
+		printMessage(os.Stdout, received, d, *raw)
+		if *requeue {
+			last = d.DeliveryTag
+			return nil
+		}
+		return d.Ack(false)
+	})
+	if *requeue && last > 0 {
+		if err := ch.Nack(last, true, true); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	verb := "acknowledged"
+	if *requeue {
+		verb = "requeued"
+	}
+	fmt.Printf("%d message(s) %s\n", received, verb)
+	return nil
+}
+
+// requeueMode resolves the -ack and -requeue flags of consume into whether the messages
+// are requeued. ackSet tells whether -ack was given, since it defaults to true.
+func requeueMode(ack, ackSet, requeue bool, count int) (bool, error) {
+	if ackSet && ack && requeue {
+		return false, errors.New("-ack and -requeue exclude each other")
+	}
+	if !ack {
+		requeue = true
+	}
+	if requeue && count == 0 {
+		return false, errors.New("-requeue needs a -count, the requeued messages would be received again")
+	}
+	return requeue, nil
+}
+
+// runPeek prints messages without taking them off the queue: they are fetched with
+// basic.get and requeued at the end, in their order. RabbitMQ marks them redelivered.
+func runPeek(args []string) error {
+	fs := flag.NewFlagSet("peek", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	queue := fs.String("queue", "", "queue to peek into")
+	count := fs.Int("count", 1, "number of messages")
+	raw := fs.Bool("raw", false, "print bodies as they are, without pretty-printing JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *queue == "" {
+		return errors.New("peek needs a -queue")
+	}
+
+	c, err := connectRabbitMQ(conn.url)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ch, err := createChannel(c)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	var last uint64
+	n := 0
+	for n < *count {
+		d, ok, err := ch.Get(*queue, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		n++
+		printMessage(os.Stdout, n, d, *raw)
+		last = d.DeliveryTag
+	}
+
+	if last > 0 {
+		if err := ch.Nack(last, true, true); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d message(s) peeked\n", n)
+	return nil
+}
+
+// runMove moves messages from one queue to another through the default exchange,
+// keeping their properties and headers. A message is only removed from the source once
+// its copy was confirmed, so a failure can leave a copy in both queues but never lose
+// one.
+func runMove(args []string) error {
+	fs := flag.NewFlagSet("move", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	from := fs.String("from", "", "source queue")
+	to := fs.String("to", "", "destination queue")
+	count := fs.Int("count", 0, "number of messages, 0 for all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" || *from == *to {
+		return errors.New("move needs a -from and a different -to queue")
+	}
+
+	c, err := connectRabbitMQ(conn.url)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ch, returns, err := confirmChannel(c)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	// the destination must exist, or every copy would be returned
+	if _, err := ch.QueueDeclarePassive(*to, false, false, false, false, nil); err != nil {
+		return fmt.Errorf("queue %s: %w", *to, err)
+	}
+
+	moved := 0
+	for *count == 0 || moved < *count {
+		d, ok, err := ch.Get(*from, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = publishMessage(ctx, ch, returns, "", *to, true, republish(d))
+		cancel()
+		if err != nil {
+			d.Nack(false, true)
+			return fmt.Errorf("moved %d message(s), then: %w", moved, err)
+		}
+		if err := d.Ack(false); err != nil {
+			return err
+		}
+		moved++
+	}
+
+	fmt.Printf("Moved %d message(s) from %s to %s\n", moved, *from, *to)
+	return nil
+}
+
+// confirmChannel opens a channel in confirm mode and registers for returned messages.
+func confirmChannel(c *amqp.Connection) (*amqp.Channel, <-chan amqp.Return, error) {
+	ch, err := createChannel(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+	return ch, ch.NotifyReturn(make(chan amqp.Return, 1)), nil
+}
+
+// republish copies a delivery into a publishing with the same properties.
+func republish(d amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         d.Headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+// readBody returns the body given as text, @file or @- for stdin.
+func readBody(body string) ([]byte, error) {
+	switch {
+	case body == "@-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(body, "@"):
+		return os.ReadFile(body[1:])
+	}
+	return []byte(body), nil
+}
+
+// parseHeaders reads headers given as a JSON object or as comma separated k=v pairs.
+func parseHeaders(s string) (amqp.Table, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		var headers map[string]any
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		if err := dec.Decode(&headers); err != nil {
+			return nil, fmt.Errorf("invalid -headers: %w", err)
+		}
+		for k, v := range headers {
+			if n, ok := v.(json.Number); ok {
+				if i, err := n.Int64(); err == nil {
+					headers[k] = i
+				} else if f, err := n.Float64(); err == nil {
+					headers[k] = f
+				}
+			}
+		}
+		return amqp.Table(headers), nil
+	}
+
+	headers := amqp.Table{}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header %q, want k=v", pair)
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return headers, nil
+}
+
+// printMessage prints a delivery: where it came from, its properties and headers, and
+// the body, indented when it is JSON.
+func printMessage(w io.Writer, n int, d amqp.Delivery, raw bool) {
+	fmt.Fprintf(w, "--- message %d: exchange=%q routing_key=%q redelivered=%v\n", n, d.Exchange, d.RoutingKey, d.Redelivered)
+
+	props := []struct{ name, value string }{
+		{"content_type", d.ContentType},
+		{"content_encoding", d.ContentEncoding},
+		{"message_id", d.MessageId},
+		{"correlation_id", d.CorrelationId},
+		{"reply_to", d.ReplyTo},
+		{"type", d.Type},
+		{"app_id", d.AppId},
+		{"expiration", d.Expiration},
+	}
+	for _, p := range props {
+		if p.value != "" {
+			fmt.Fprintf(w, "  %s: %s\n", p.name, p.value)
+		}
+	}
+	if d.DeliveryMode == amqp.Persistent {
+		fmt.Fprintln(w, "  persistent: true")
+	}
+	if !d.Timestamp.IsZero() {
+		fmt.Fprintf(w, "  timestamp: %s\n", d.Timestamp.Format(time.RFC3339))
+	}
+
+	if len(d.Headers) > 0 {
+		fmt.Fprintln(w, "  headers:")
+		keys := make([]string, 0, len(d.Headers))
+		for k := range d.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "    %s: %s\n", k, headerValue(d.Headers[k]))
+		}
+	}
+
+	fmt.Fprintln(w, formatBody(d.Body, raw))
+}
+
+// headerValue renders nested tables and arrays, such as x-death, as JSON.
+func headerValue(v any) string {
+	switch v.(type) {
+	case amqp.Table, []any:
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+	case []byte:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(v)
+}
+
+func formatBody(body []byte, raw bool) string {
+	if !raw && json.Valid(body) {
+		var out bytes.Buffer
+		if err := json.Indent(&out, body, "", "  "); err == nil {
+			return out.String()
+		}
+	}
+	if utf8.Valid(body) {
+		return string(body)
+	}
+	return fmt.Sprintf("(%d bytes of binary data)\n%x", len(body), body)
+}
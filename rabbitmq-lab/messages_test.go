@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		in   string
+		want amqp.Table
+	}{
+		{"", nil},
+		{"tenant=acme", amqp.Table{"tenant": "acme"}},
+		// k=v values stay strings, and spaces around them are dropped
+		{" tenant = acme , x-attempts=3", amqp.Table{"tenant": "acme", "x-attempts": "3"}},
+		{"query=a=b", amqp.Table{"query": "a=b"}},
+		{"empty=", amqp.Table{"empty": ""}},
+		// JSON keeps the types; whole numbers are int64, as RabbitMQ sends them back
+		{`{"x-attempts": 3, "ratio": 0.5, "retry": true, "tenant": "acme"}`, amqp.Table{"x-attempts": int64(3), "ratio": 0.5, "retry": true, "tenant": "acme"}},
+		{` {"nested": {"a": 1}, "list": [1, "b"]}`, amqp.Table{"nested": map[string]any{"a": json.Number("1")}, "list": []any{json.Number("1"), "b"}}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseHeaders(tt.in)
+		if err != nil {
+			t.Errorf("parseHeaders(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseHeaders(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseHeadersInvalid(t *testing.T) {
+	for _, in := range []string{"tenant", "=acme", "a=1,,b=2", `{"tenant": }`, `{"tenant": "acme"`} {
+		if _, err := parseHeaders(in); err == nil {
+			t.Errorf("parseHeaders(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "body.json")
+	if err := os.WriteFile(path, []byte(`{"name":"orders"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin.WriteString("from stdin")
+	stdin.Seek(0, 0)
+	orig := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() { os.Stdin = orig })
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "hello"},
+		{"", ""},
+		{"@" + path, `{"name":"orders"}`},
+		{"@-", "from stdin"},
+	}
+	for _, tt := range tests {
+		got, err := readBody(tt.in)
+		if err != nil {
+			t.Errorf("readBody(%q): %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("readBody(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := readBody("@" + filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("reading a missing file succeeded")
+	}
+}
+
+func TestFormatBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		raw  bool
+		want string
+	}{
+		{"json", []byte(`{"name":"orders","tags":["a"]}`), false, "{\n  \"name\": \"orders\",\n  \"tags\": [\n    \"a\"\n  ]\n}"},
+		{"raw json", []byte(`{"name":"orders"}`), true, `{"name":"orders"}`},
+		{"text", []byte("order placed"), false, "order placed"},
+		{"empty", nil, false, ""},
+		{"binary", []byte{0xff, 0x00, 0x10}, false, "(3 bytes of binary data)\nff0010"},
+		{"raw binary", []byte{0xff, 0x00}, true, "(2 bytes of binary data)\nff00"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBody(tt.body, tt.raw); got != tt.want {
+			t.Errorf("%s: formatBody = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrintMessage(t *testing.T) {
+	var out bytes.Buffer
+	printMessage(&out, 2, amqp.Delivery{
+		Exchange:     "logs_topic",
+		RoutingKey:   "log.ERROR.auth",
+		Redelivered:  true,
+		ContentType:  "application/json",
+		MessageId:    "msg-1",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Headers: amqp.Table{
+			"x-death":    []any{amqp.Table{"count": int64(1), "reason": "rejected"}},
+			"x-attempts": int32(2),
+			"token":      []byte("ab"),
+		},
+		Body: []byte(`{"name":"auth"}`),
+	}, false)
+
+	want := `--- message 2: exchange="logs_topic" routing_key="log.ERROR.auth" redelivered=true
+  content_type: application/json
+  message_id: msg-1
+  persistent: true
+  timestamp: 2026-01-02T03:04:05Z
+  headers:
+    token: "ab"
+    x-attempts: 2
+    x-death: [{"count":1,"reason":"rejected"}]
+{
+  "name": "auth"
+}
+`
+	if got := out.String(); got != want {
+		t.Errorf("printed:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRequeueMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		ack, ackSet bool
+		requeue     bool
+		count       int
+		want        bool
+		wantErr     string
+	}{
+		{name: "defaults acknowledge", ack: true, count: 1, want: false},
+		{name: "-requeue", ack: true, requeue: true, count: 5, want: true},
+		{name: "-ack=false", ack: false, ackSet: true, count: 5, want: true},
+		{name: "-ack=false -requeue", ack: false, ackSet: true, requeue: true, count: 5, want: true},
+		{name: "-ack", ack: true, ackSet: true, count: 5, want: false},
+		{name: "-ack -requeue", ack: true, ackSet: true, requeue: true, count: 5, wantErr: "exclude each other"},
+		{name: "consume forever", ack: true, count: 0, want: false},
+		{name: "-requeue forever", ack: true, requeue: true, count: 0, wantErr: "needs a -count"},
+		{name: "-ack=false forever", ack: false, ackSet: true, count: 0, wantErr: "needs a -count"},
+	}
+
+	for _, tt := range tests {
+		got, err := requeueMode(tt.ack, tt.ackSet, tt.requeue, tt.count)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case got != tt.want:
+			t.Errorf("%s: requeue = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunConsumeFlags(t *testing.T) {
+	// the flags are checked before connecting, so these fail without a server
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{}, "needs a -queue"},
+		{[]string{"-queue", "q", "-ack", "-requeue"}, "exclude each other"},
+		{[]string{"-queue", "q", "-ack=false", "-count", "0"}, "needs a -count"},
+	}
+
+	for _, tt := range tests {
+		if err := runConsume(tt.args); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("consume %v: err = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
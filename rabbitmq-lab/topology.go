@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+A topology file declares exchanges, queues and the bindings between them, in YAML or
+JSON (picked by the file extension):
+
+	exchanges:
+	  - name: logs_topic
+	    type: topic
+	queues:
+	  - name: logs.listener
+	    dead_letter_exchange: logs_dlx
+	    message_ttl: 60000
+	bindings:
+	  - exchange: logs_topic
+	    queue: logs.listener
+	    routing_key: log.#
+
+Exchanges and queues are durable unless durable: false is set. The common queue
+arguments have their own fields; anything else goes into arguments as the raw x-
+argument.
+*/
+
+// Topology is the content of a topology file.
+type Topology struct {
+	Exchanges []Exchange `json:"exchanges,omitempty" yaml:"exchanges,omitempty"`
+	Queues    []Queue    `json:"queues,omitempty" yaml:"queues,omitempty"`
+	Bindings  []Binding  `json:"bindings,omitempty" yaml:"bindings,omitempty"`
+}
+
+type Exchange struct {
+	Name       string         `json:"name" yaml:"name"`
+	Type       string         `json:"type" yaml:"type"`
+	Durable    *bool          `json:"durable,omitempty" yaml:"durable,omitempty"`
+	AutoDelete bool           `json:"auto_delete,omitempty" yaml:"auto_delete,omitempty"`
+	Internal   bool           `json:"internal,omitempty" yaml:"internal,omitempty"`
+	Arguments  map[string]any `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+}
+
+type Queue struct {
+	Name       string `json:"name" yaml:"name"`
+	Durable    *bool  `json:"durable,omitempty" yaml:"durable,omitempty"`
+	AutoDelete bool   `json:"auto_delete,omitempty" yaml:"auto_delete,omitempty"`
+	// Type is "classic", "quorum" or "stream" (x-queue-type).
+	Type                 string  `json:"type,omitempty" yaml:"type,omitempty"`
+	DeadLetterExchange   *string `json:"dead_letter_exchange,omitempty" yaml:"dead_letter_exchange,omitempty"`
+	DeadLetterRoutingKey string  `json:"dead_letter_routing_key,omitempty" yaml:"dead_letter_routing_key,omitempty"`
+	// MessageTTL and Expires are in milliseconds, as RabbitMQ takes them.
+	MessageTTL     int64 `json:"message_ttl,omitempty" yaml:"message_ttl,omitempty"`
+	Expires        int64 `json:"expires,omitempty" yaml:"expires,omitempty"`
+	MaxLength      int64 `json:"max_length,omitempty" yaml:"max_length,omitempty"`
+	MaxLengthBytes int64 `json:"max_length_bytes,omitempty" yaml:"max_length_bytes,omitempty"`
+	// Overflow is "drop-head", "reject-publish" or "reject-publish-dlx".
+	Overflow  string         `json:"overflow,omitempty" yaml:"overflow,omitempty"`
+	Arguments map[string]any `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+}
+
+type Binding struct {
+	Exchange   string         `json:"exchange" yaml:"exchange"`
+	Queue      string         `json:"queue" yaml:"queue"`
+	RoutingKey string         `json:"routing_key" yaml:"routing_key"`
+	Arguments  map[string]any `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+}
+
+// queueArguments maps the x- arguments onto the queue fields holding them.
+var queueArguments = []struct {
+	arg   string
+	field func(q *Queue) any
+}{
+	{"x-queue-type", func(q *Queue) any { return &q.Type }},
+	{"x-dead-letter-exchange", func(q *Queue) any { return &q.DeadLetterExchange }},
+	{"x-dead-letter-routing-key", func(q *Queue) any { return &q.DeadLetterRoutingKey }},
+	{"x-message-ttl", func(q *Queue) any { return &q.MessageTTL }},
+	{"x-expires", func(q *Queue) any { return &q.Expires }},
+	{"x-max-length", func(q *Queue) any { return &q.MaxLength }},
+	{"x-max-length-bytes", func(q *Queue) any { return &q.MaxLengthBytes }},
+	{"x-overflow", func(q *Queue) any { return &q.Overflow }},
+}
+
+// getArgument returns the value of a queue field, and whether it is set.
+func getArgument(field any) (any, bool) {
+	switch f := field.(type) {
+	case *string:
+		return *f, *f != ""
+	case **string:
+		if *f == nil {
+			return nil, false
+		}
+		return **f, true
+	case *int64:
+		return *f, *f != 0
+	}
+	return nil, false
+}
+
+// setArgument stores an argument value in a queue field, and reports whether it had the
+// field's type.
+func setArgument(field any, v any) bool {
+	switch f := field.(type) {
+	case *string:
+		s, ok := v.(string)
+		if ok {
+			*f = s
+		}
+		return ok
+	case **string:
+		s, ok := v.(string)
+		if ok {
+			*f = &s
+		}
+		return ok
+	case *int64:
+		n, ok := toInt64(v)
+		if ok {
+			*f = n
+		}
+		return ok
+	}
+	return false
+}
+
+var exchangeTypes = map[string]bool{"direct": true, "fanout": true, "topic": true, "headers": true}
+
+func (e Exchange) durable() bool { return e.Durable == nil || *e.Durable }
+
+func (q Queue) durable() bool { return q.Durable == nil || *q.Durable }
+
+// table returns all arguments of the queue, the named fields included.
+func (q Queue) table() amqp.Table {
+	args := table(q.Arguments)
+	for _, a := range queueArguments {
+		if v, ok := getArgument(a.field(&q)); ok {
+			args[a.arg] = v
+		}
+	}
+	return args
+}
+
+// table converts arguments read from a file into AMQP field values. Whole numbers
+// become int64, as RabbitMQ expects for lengths and TTLs, even when JSON decoded them
+// as floats.
+func table(args map[string]any) amqp.Table {
+	t := amqp.Table{}
+	for k, v := range args {
+		if n, ok := toInt64(v); ok {
+			v = n
+		}
+		t[k] = v
+	}
+	return t
+}
+
+// queueFromArguments builds a queue, moving the arguments that have a field into it.
+func queueFromArguments(name string, durable, autoDelete bool, args map[string]any) Queue {
+	q := Queue{Name: name, AutoDelete: autoDelete}
+	if !durable {
+		q.Durable = &durable
+	}
+
+	for k, v := range args {
+		moved := false
+		for _, a := range queueArguments {
+			if a.arg == k {
+				moved = setArgument(a.field(&q), v)
+			}
+		}
+		if !moved {
+			if q.Arguments == nil {
+				q.Arguments = map[string]any{}
+			}
+			q.Arguments[k] = v
+		}
+	}
+	return q
+}
+
+// loadTopology reads a topology file, as JSON when it ends in .json and as YAML
+// otherwise.
+func loadTopology(path string) (*Topology, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var t Topology
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(strings.NewReader(string(b)))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&t)
+	} else {
+		dec := yaml.NewDecoder(strings.NewReader(string(b)))
+		dec.KnownFields(true)
+		err = dec.Decode(&t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Validate checks names, types and references, and that no argument is given both as a
+// field and in arguments.
+func (t *Topology) Validate() error {
+	var errs []error
+
+	exchanges := map[string]bool{"": true}
+	for _, e := range t.Exchanges {
+		switch {
+		case e.Name == "" || strings.HasPrefix(e.Name, "amq."):
+			errs = append(errs, fmt.Errorf("invalid exchange name %q", e.Name))
+		case exchanges[e.Name]:
+			errs = append(errs, fmt.Errorf("exchange %q is declared twice", e.Name))
+		case !exchangeTypes[e.Type]:
+			errs = append(errs, fmt.Errorf("exchange %q has unknown type %q", e.Name, e.Type))
+		}
+		exchanges[e.Name] = true
+	}
+
+	queues := map[string]bool{}
+	for _, q := range t.Queues {
+		switch {
+		case q.Name == "" || strings.HasPrefix(q.Name, "amq."):
+			errs = append(errs, fmt.Errorf("invalid queue name %q", q.Name))
+		case queues[q.Name]:
+			errs = append(errs, fmt.Errorf("queue %q is declared twice", q.Name))
+		}
+		queues[q.Name] = true
+
+		for _, a := range queueArguments {
+			if _, set := getArgument(a.field(&q)); set {
+				if _, dup := q.Arguments[a.arg]; dup {
+					errs = append(errs, fmt.Errorf("queue %q sets %s both as a field and in arguments", q.Name, a.arg))
+				}
+			}
+		}
+		if q.MessageTTL < 0 || q.Expires < 0 || q.MaxLength < 0 || q.MaxLengthBytes < 0 {
+			errs = append(errs, fmt.Errorf("queue %q has a negative limit", q.Name))
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if b.Exchange == "" {
+			errs = append(errs, fmt.Errorf("binding of queue %q has no exchange", b.Queue))
+		} else if !exchanges[b.Exchange] && !strings.HasPrefix(b.Exchange, "amq.") {
+			errs = append(errs, fmt.Errorf("binding refers to undeclared exchange %q", b.Exchange))
+		}
+		if !queues[b.Queue] {
+			errs = append(errs, fmt.Errorf("binding refers to undeclared queue %q", b.Queue))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), n == float64(int64(n))
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
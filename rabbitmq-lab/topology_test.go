@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestValidate(t *testing.T) {
+	valid := func() *Topology {
+		return &Topology{
+			Exchanges: []Exchange{{Name: "logs_topic", Type: "topic"}},
+			Queues:    []Queue{{Name: "logs.listener", MessageTTL: 60000}},
+			Bindings:  []Binding{{Exchange: "logs_topic", Queue: "logs.listener", RoutingKey: "log.#"}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(t *Topology)
+		want   []string
+	}{
+		{"valid", func(t *Topology) {}, nil},
+		{"exchange without name", func(t *Topology) { t.Exchanges[0].Name = "" }, []string{`invalid exchange name ""`}},
+		{"built-in exchange", func(t *Topology) { t.Exchanges[0].Name = "amq.topic" }, []string{`invalid exchange name "amq.topic"`}},
+		{"exchange twice", func(t *Topology) { t.Exchanges = append(t.Exchanges, t.Exchanges[0]) }, []string{`exchange "logs_topic" is declared twice`}},
+		{"unknown exchange type", func(t *Topology) { t.Exchanges[0].Type = "x-delayed" }, []string{`exchange "logs_topic" has unknown type "x-delayed"`}},
+		{"queue without name", func(t *Topology) { t.Queues = append(t.Queues, Queue{}) }, []string{`invalid queue name ""`}},
+		{"queue twice", func(t *Topology) { t.Queues = append(t.Queues, Queue{Name: "logs.listener"}) }, []string{`queue "logs.listener" is declared twice`}},
+		{"negative limit", func(t *Topology) { t.Queues[0].MaxLength = -1 }, []string{`queue "logs.listener" has a negative limit`}},
+		{"field and argument", func(t *Topology) {
+			t.Queues[0].Arguments = map[string]any{"x-message-ttl": 1000}
+		}, []string{`queue "logs.listener" sets x-message-ttl both as a field and in arguments`}},
+		{"pointer field and argument", func(t *Topology) {
+			t.Queues[0].DeadLetterExchange = ptr("")
+			t.Queues[0].Arguments = map[string]any{"x-dead-letter-exchange": "logs_dlx"}
+		}, []string{`queue "logs.listener" sets x-dead-letter-exchange both as a field and in arguments`}},
+		{"argument only", func(t *Topology) {
+			t.Queues[0].Arguments = map[string]any{"x-max-priority": 10, "x-overflow": "drop-head"}
+		}, nil},
+		{"binding without exchange", func(t *Topology) { t.Bindings[0].Exchange = "" }, []string{`binding of queue "logs.listener" has no exchange`}},
+		{"undeclared exchange", func(t *Topology) { t.Bindings[0].Exchange = "audit" }, []string{`binding refers to undeclared exchange "audit"`}},
+		{"built-in exchange binding", func(t *Topology) { t.Bindings[0].Exchange = "amq.fanout" }, nil},
+		{"undeclared queue", func(t *Topology) { t.Bindings[0].Queue = "audit" }, []string{`binding refers to undeclared queue "audit"`}},
+		{"every error", func(t *Topology) {
+			t.Exchanges[0].Type = "fanin"
+			t.Bindings[0].Queue = "audit"
+		}, []string{`unknown type "fanin"`, `undeclared queue "audit"`}},
+	}
+
+	for _, tt := range tests {
+		top := valid()
+		tt.modify(top)
+
+		err := top.Validate()
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: err = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: err = nil, want %q", tt.name, tt.want)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: err = %v, want it to mention %q", tt.name, err, want)
+			}
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(`{"x-max-priority": 10, "x-ratio": 0.5, "x-mode": "lazy", "x-flag": true}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	got := table(decoded)
+	want := amqp.Table{"x-max-priority": int64(10), "x-ratio": 0.5, "x-mode": "lazy", "x-flag": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("table = %#v, want %#v", got, want)
+	}
+
+	// a nil map gives an empty table, which declares fine
+	if got := table(nil); got == nil || len(got) != 0 {
+		t.Errorf("table(nil) = %#v", got)
+	}
+}
+
+func TestQueueTable(t *testing.T) {
+	q := Queue{
+		Name:               "logs.listener",
+		Type:               "quorum",
+		DeadLetterExchange: ptr(""),
+		MessageTTL:         60000,
+		Arguments:          map[string]any{"x-delivery-limit": float64(5)},
+	}
+	want := amqp.Table{
+		"x-queue-type":           "quorum",
+		"x-dead-letter-exchange": "",
+		"x-message-ttl":          int64(60000),
+		"x-delivery-limit":       int64(5),
+	}
+	if got := q.table(); !reflect.DeepEqual(got, want) {
+		t.Errorf("table = %#v, want %#v", got, want)
+	}
+}
+
+func TestQueueFromArguments(t *testing.T) {
+	tests := []struct {
+		name    string
+		durable bool
+		args    map[string]any
+		want    Queue
+	}{
+		{"no arguments", true, nil, Queue{Name: "q"}},
+		{"not durable", false, nil, Queue{Name: "q", Durable: ptr(false)}},
+		{
+			name:    "fields",
+			durable: true,
+			args: map[string]any{
+				"x-queue-type":           "quorum",
+				"x-dead-letter-exchange": "",
+				"x-message-ttl":          int64(60000),
+				"x-max-length":           float64(100),
+				"x-overflow":             "reject-publish",
+			},
+			want: Queue{Name: "q", Type: "quorum", DeadLetterExchange: ptr(""), MessageTTL: 60000, MaxLength: 100, Overflow: "reject-publish"},
+		},
+		{
+			name:    "other arguments",
+			durable: true,
+			args:    map[string]any{"x-max-priority": int64(10), "x-message-ttl": int64(5)},
+			want:    Queue{Name: "q", MessageTTL: 5, Arguments: map[string]any{"x-max-priority": int64(10)}},
+		},
+		{
+			// a value of the wrong type stays an argument, so the diff still shows it
+			name:    "wrong type",
+			durable: true,
+			args:    map[string]any{"x-message-ttl": "60000", "x-max-length": 1.5},
+			want:    Queue{Name: "q", Arguments: map[string]any{"x-message-ttl": "60000", "x-max-length": 1.5}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := queueFromArguments("q", tt.durable, false, tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: queue = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestToInt64(t *testing.T) {
+	tests := []struct {
+		v    any
+		want int64
+		ok   bool
+	}{
+		{int(3), 3, true},
+		{int32(3), 3, true},
+		{int64(3), 3, true},
+		{float64(60000), 60000, true},
+		{1.5, 1, false},
+		{json.Number("42"), 42, true},
+		{json.Number("4.2"), 0, false},
+		{"42", 0, false},
+		{nil, 0, false},
+		{true, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := toInt64(tt.v)
+		if ok != tt.ok || ok && got != tt.want {
+			t.Errorf("toInt64(%#v) = %d, %t, want %d, %t", tt.v, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNonEmpty(t *testing.T) {
+	if got := nonEmpty(map[string]any{}); got != nil {
+		t.Errorf("nonEmpty(empty) = %#v, want nil", got)
+	}
+	if got := nonEmpty(nil); got != nil {
+		t.Errorf("nonEmpty(nil) = %#v, want nil", got)
+	}
+
+	// the management API is decoded with UseNumber
+	got := nonEmpty(map[string]any{"x-message-ttl": json.Number("60000"), "x-ratio": json.Number("0.5"), "x-mode": "lazy"})
+	want := map[string]any{"x-message-ttl": int64(60000), "x-ratio": 0.5, "x-mode": "lazy"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("nonEmpty = %#v, want %#v", got, want)
+	}
+}
+
+func TestLoadTopology(t *testing.T) {
+	files := map[string]string{
+		"topology.yaml": `
+exchanges:
+  - name: logs_topic
+    type: topic
+queues:
+  - name: logs.listener
+    message_ttl: 60000
+    arguments:
+      x-max-priority: 10
+bindings:
+  - exchange: logs_topic
+    queue: logs.listener
+    routing_key: log.#
+`,
+		"topology.json": `{
+	"exchanges": [{"name": "logs_topic", "type": "topic"}],
+	"queues": [{"name": "logs.listener", "message_ttl": 60000, "arguments": {"x-max-priority": 10}}],
+	"bindings": [{"exchange": "logs_topic", "queue": "logs.listener", "routing_key": "log.#"}]
+}`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		top, err := loadTopology(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// YAML decodes the argument as an int and JSON as a float; both declare an int64
+		want := amqp.Table{"x-message-ttl": int64(60000), "x-max-priority": int64(10)}
+		if len(top.Queues) != 1 || !reflect.DeepEqual(top.Queues[0].table(), want) {
+			t.Errorf("%s: queues = %+v", name, top.Queues)
+		}
+	}
+}
+
+func TestLoadTopologyInvalid(t *testing.T) {
+	files := map[string]string{
+		// unknown fields are typos, not ignored
+		"unknown.yaml": "queues:\n  - name: q\n    mesage_ttl: 1000\n",
+		"unknown.json": `{"queues": [{"name": "q", "mesage_ttl": 1000}]}`,
+		"invalid.yaml": "bindings:\n  - exchange: missing\n    queue: q\n",
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadTopology(path); err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: err = %v, want an error naming the file", name, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package envelope defines the versioned envelope events are published in. The producers
+// (broker-service and auth-service) and listener-service share it.
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// CurrentVersion is the schema version producers publish. Version 1 is the bare
+// {"name", "data"} log payload published before envelopes existed.
+const CurrentVersion = 2
+
+// ContentType marks a message body as an envelope.
+const ContentType = "application/vnd.events.envelope+json"
+
+// versionHeader carries the schema version in the AMQP headers as well, so it can be
+// read without decoding the body.
+const versionHeader = "x-schema-version"
+
+// ErrInvalid is wrapped by every decoding and validation error. Such a message will
+// never be valid, so consumers should not retry it.
+var ErrInvalid = errors.New("invalid envelope")
+
+// Envelope wraps the data of an event with its metadata.
+type Envelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	Source        string          `json:"source"`
+	Time          time.Time       `json:"time"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	ContentType   string          `json:"content_type"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// New wraps data, encoded as JSON, in an envelope of the current version.
+func New(eventType, source string, data any) (Envelope, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		ID:            newID(),
+		Type:          eventType,
+		SchemaVersion: CurrentVersion,
+		Source:        source,
+		Time:          time.Now().UTC(),
+		ContentType:   "application/json",
+		Data:          raw,
+	}, nil
+}
+
+// newID returns a random UUID (version 4).
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// Publishing returns the AMQP message for the envelope. The metadata is set both in the
+// body and in the message properties.
+func (e Envelope) Publishing() (amqp.Publishing, error) {
+	if err := e.Validate(); err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	return amqp.Publishing{
+		Headers:       amqp.Table{versionHeader: int32(e.SchemaVersion)},
+		ContentType:   ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     e.ID,
+		Type:          e.Type,
+		AppId:         e.Source,
+		Timestamp:     e.Time,
+		CorrelationId: e.CorrelationID,
+		Body:          body,
+	}, nil
+}
+
+// Decode reads the envelope of a delivery, upcasts it to the current version and
+// validates it.
+func Decode(msg amqp.Delivery) (Envelope, error) {
+	var e Envelope
+
+	if msg.ContentType == ContentType {
+		if err := json.Unmarshal(msg.Body, &e); err != nil {
+			return e, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	} else {
+		// published before envelopes, or by a producer that does not use them yet
+		if !json.Valid(msg.Body) {
+			return e, fmt.Errorf("%w: body is not JSON", ErrInvalid)
+		}
+		e = Envelope{
+			ID:            msg.MessageId,
+			SchemaVersion: 1,
+			Time:          msg.Timestamp,
+			CorrelationID: msg.CorrelationId,
+			ContentType:   "application/json",
+			Data:          bytes.Clone(msg.Body),
+		}
+	}
+
+	e, err := upcast(e)
+	if err != nil {
+		return e, err
+	}
+	return e, e.Validate()
+}
+
+// Validate checks the metadata and the data against the schema of the event type.
+func (e Envelope) Validate() error {
+	switch {
+	case e.ID == "":
+		return fmt.Errorf("%w: missing id", ErrInvalid)
+	case e.Type == "":
+		return fmt.Errorf("%w: missing type", ErrInvalid)
+	case e.SchemaVersion != CurrentVersion:
+		return fmt.Errorf("%w: schema version %d, want %d", ErrInvalid, e.SchemaVersion, CurrentVersion)
+	case e.Source == "":
+		return fmt.Errorf("%w: missing source", ErrInvalid)
+	case e.Time.IsZero():
+		return fmt.Errorf("%w: missing time", ErrInvalid)
+	case !json.Valid(e.Data):
+		return fmt.Errorf("%w: data is not JSON", ErrInvalid)
+	}
+
+	schema, ok := schemas[e.Type]
+	if !ok {
+		// types without a schema are passed through as they are
+		return nil
+	}
+	if err := schema(e.Data); err != nil {
+		return fmt.Errorf("%w: %s data: %v", ErrInvalid, e.Type, err)
+	}
+	return nil
+}
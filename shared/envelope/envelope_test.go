@@ -0,0 +1,180 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// delivery returns the message of a publishing as RabbitMQ would deliver it.
+func delivery(msg amqp.Publishing) amqp.Delivery {
+	return amqp.Delivery{
+		Headers:       msg.Headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  msg.DeliveryMode,
+		MessageId:     msg.MessageId,
+		Type:          msg.Type,
+		AppId:         msg.AppId,
+		Timestamp:     msg.Timestamp,
+		CorrelationId: msg.CorrelationId,
+		RoutingKey:    "log.INFO.general",
+		Body:          msg.Body,
+	}
+}
+
+func TestNew(t *testing.T) {
+	e, err := New(TypeLog, "broker-service", LogData{Name: "orders", Data: "order placed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(e.ID) {
+		t.Errorf("id = %q, want a UUID v4", e.ID)
+	}
+	if other, _ := New(TypeLog, "broker-service", LogData{Name: "orders"}); other.ID == e.ID {
+		t.Error("two envelopes got the same id")
+	}
+}
+
+func TestPublishingDecode(t *testing.T) {
+	e, err := New(TypeAuth, "auth-service", AuthData{Type: "login", Email: "jane@example.com", At: time.Now().UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.CorrelationID = "req-1"
+
+	msg, err := e.Publishing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentType != ContentType || msg.DeliveryMode != amqp.Persistent {
+		t.Errorf("content type %q and delivery mode %d", msg.ContentType, msg.DeliveryMode)
+	}
+	if msg.MessageId != e.ID || msg.Type != TypeAuth || msg.AppId != "auth-service" || msg.CorrelationId != "req-1" {
+		t.Errorf("properties = %+v", msg)
+	}
+	if msg.Headers[versionHeader] != int32(CurrentVersion) {
+		t.Errorf("%s = %v, want %d", versionHeader, msg.Headers[versionHeader], CurrentVersion)
+	}
+
+	got, err := Decode(delivery(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != e.ID || got.Type != e.Type || got.SchemaVersion != e.SchemaVersion || got.Source != e.Source ||
+		!got.Time.Equal(e.Time) || got.CorrelationID != e.CorrelationID || got.ContentType != e.ContentType {
+		t.Errorf("decoded %+v, want %+v", got, e)
+	}
+
+	var data AuthData
+	if err := json.Unmarshal(got.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Type != "login" || data.Email != "jane@example.com" {
+		t.Errorf("data = %+v", data)
+	}
+}
+
+func TestPublishingInvalid(t *testing.T) {
+	e, err := New(TypeLog, "broker-service", LogData{Data: "no name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Publishing(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("err = %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  amqp.Delivery
+		want string
+	}{
+		{"envelope not JSON", amqp.Delivery{ContentType: ContentType, Body: []byte("{")}, "unexpected end"},
+		{"body not JSON", amqp.Delivery{Body: []byte("plain text")}, "body is not JSON"},
+		{"unknown version", amqp.Delivery{ContentType: ContentType, Body: []byte(`{"id":"1","type":"log","schema_version":3}`)}, "unknown schema version 3"},
+		{"no version", amqp.Delivery{ContentType: ContentType, Body: []byte(`{"id":"1","type":"log"}`)}, "unknown schema version 0"},
+		{"missing source", amqp.Delivery{ContentType: ContentType, Body: []byte(`{"id":"1","type":"log","schema_version":2,"time":"2026-01-01T00:00:00Z","data":{"name":"a"}}`)}, "missing source"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(tt.msg)
+		if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %v mentioning %q", tt.name, err, ErrInvalid, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Envelope {
+		return Envelope{
+			ID:            "0b5e9c1e-8f0a-4c1a-9a55-5f4f0d5b1c2e",
+			Type:          TypeLog,
+			SchemaVersion: CurrentVersion,
+			Source:        "broker-service",
+			Time:          time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			ContentType:   "application/json",
+			Data:          json.RawMessage(`{"name":"orders","data":"order placed"}`),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(e *Envelope)
+		want   string
+	}{
+		{"valid", func(e *Envelope) {}, ""},
+		{"missing id", func(e *Envelope) { e.ID = "" }, "missing id"},
+		{"missing type", func(e *Envelope) { e.Type = "" }, "missing type"},
+		{"old version", func(e *Envelope) { e.SchemaVersion = 1 }, "schema version 1, want 2"},
+		{"newer version", func(e *Envelope) { e.SchemaVersion = 3 }, "schema version 3, want 2"},
+		{"missing source", func(e *Envelope) { e.Source = "" }, "missing source"},
+		{"missing time", func(e *Envelope) { e.Time = time.Time{} }, "missing time"},
+		{"data not JSON", func(e *Envelope) { e.Data = json.RawMessage(`{"name"`) }, "data is not JSON"},
+		{"missing data", func(e *Envelope) { e.Data = nil }, "data is not JSON"},
+		{"log without name", func(e *Envelope) { e.Data = json.RawMessage(`{"data":"x"}`) }, "log data: missing name"},
+		{"log of the wrong shape", func(e *Envelope) { e.Data = json.RawMessage(`{"name":1}`) }, "log data: json"},
+		{"auth without email", func(e *Envelope) {
+			e.Type = TypeAuth
+			e.Data = json.RawMessage(`{"type":"login"}`)
+		}, "auth data: missing type or email"},
+		{"auth", func(e *Envelope) {
+			e.Type = TypeAuth
+			e.Data = json.RawMessage(`{"type":"login","email":"jane@example.com"}`)
+		}, ""},
+		{"mail without template", func(e *Envelope) {
+			e.Type = TypeMail
+			e.Data = json.RawMessage(`{"to":"jane@example.com"}`)
+		}, "mail data: missing recipient or template"},
+		{"mail", func(e *Envelope) {
+			e.Type = TypeMail
+			e.Data = json.RawMessage(`{"to":"jane@example.com","template":"welcome"}`)
+		}, ""},
+		// types without a schema are passed through
+		{"type without schema", func(e *Envelope) {
+			e.Type = "audit"
+			e.Data = json.RawMessage(`[1, 2]`)
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		e := valid()
+		tt.modify(&e)
+
+		err := e.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: err = %v, want nil", tt.name, err)
+		case tt.want != "" && (!errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: err = %v, want %v mentioning %q", tt.name, err, ErrInvalid, tt.want)
+		}
+	}
+}
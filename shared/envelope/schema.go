@@ -0,0 +1,123 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Upcaster converts an envelope of one schema version to the next.
+type Upcaster func(Envelope) (Envelope, error)
+
+// upcasters maps a schema version to the upcaster producing the next version. Add an
+// entry here whenever CurrentVersion is raised.
+var upcasters = map[int]Upcaster{
+	1: upcastV1,
+}
+
+func upcast(e Envelope) (Envelope, error) {
+	if e.SchemaVersion < 1 || e.SchemaVersion > CurrentVersion {
+		return e, fmt.Errorf("%w: unknown schema version %d", ErrInvalid, e.SchemaVersion)
+	}
+
+	for e.SchemaVersion < CurrentVersion {
+		up, ok := upcasters[e.SchemaVersion]
+		if !ok {
+			return e, fmt.Errorf("%w: no upcaster from schema version %d", ErrInvalid, e.SchemaVersion)
+		}
+
+		var err error
+		if e, err = up(e); err != nil {
+			return e, fmt.Errorf("%w: upcasting from version %d: %v", ErrInvalid, e.SchemaVersion, err)
+		}
+	}
+	return e, nil
+}
+
+// upcastV1 wraps a bare {"name", "data"} payload into a "log" envelope, filling in the
+// metadata the old producers did not send.
+func upcastV1(e Envelope) (Envelope, error) {
+	var payload LogData
+	if err := json.Unmarshal(e.Data, &payload); err != nil {
+		return e, err
+	}
+
+	e.Type = TypeLog
+	e.SchemaVersion = 2
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Source == "" {
+		e.Source = "unknown"
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	return e, nil
+}
+
+// Event types with a schema.
+const (
+	TypeLog  = "log"
+	TypeAuth = "auth"
+	TypeMail = "mail"
+)
+
+// LogData is the data of a "log" event.
+type LogData struct {
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// AuthData is the data of an "auth" event.
+type AuthData struct {
+	Type      string    `json:"type"`
+	Email     string    `json:"email"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// MailData is the data of a "mail" event.
+type MailData struct {
+	To       string         `json:"to"`
+	Subject  string         `json:"subject"`
+	Template string         `json:"template"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// schemas validates the data of each event type.
+var schemas = map[string]func(json.RawMessage) error{
+	TypeLog: func(raw json.RawMessage) error {
+		var d LogData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return err
+		}
+		if d.Name == "" {
+			return errors.New("missing name")
+		}
+		return nil
+	},
+	TypeAuth: func(raw json.RawMessage) error {
+		var d AuthData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return err
+		}
+		if d.Type == "" || d.Email == "" {
+			return errors.New("missing type or email")
+		}
+		return nil
+	},
+	TypeMail: func(raw json.RawMessage) error {
+		var d MailData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return err
+		}
+		if d.To == "" || d.Template == "" {
+			return errors.New("missing recipient or template")
+		}
+		return nil
+	},
+}
@@ -0,0 +1,136 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// TestDecodeV1 decodes a log message as producers published it before envelopes: a bare
+// {"name", "data"} body without a content type.
+func TestDecodeV1(t *testing.T) {
+	sent := time.Date(2025, 6, 1, 8, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		msg        amqp.Delivery
+		wantID     string
+		wantTime   time.Time
+		wantCorrID string
+	}{
+		{
+			name: "bare",
+			msg:  amqp.Delivery{RoutingKey: "log.INFO", Body: []byte(`{"name":"orders","data":"order placed"}`)},
+		},
+		{
+			name: "plain JSON content type",
+			msg:  amqp.Delivery{ContentType: "application/json", Body: []byte(`{"name":"orders","data":"order placed"}`)},
+		},
+		{
+			// the properties the old producers did set are kept
+			name: "with properties",
+			msg: amqp.Delivery{
+				MessageId:     "msg-1",
+				Timestamp:     sent,
+				CorrelationId: "req-1",
+				Body:          []byte(`{"name":"orders","data":"order placed"}`),
+			},
+			wantID:     "msg-1",
+			wantTime:   sent,
+			wantCorrID: "req-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Decode(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if e.Type != TypeLog || e.SchemaVersion != CurrentVersion || e.Source != "unknown" || e.ContentType != "application/json" {
+				t.Errorf("envelope = %+v", e)
+			}
+			if e.ID == "" || (tt.wantID != "" && e.ID != tt.wantID) {
+				t.Errorf("id = %q, want %q", e.ID, tt.wantID)
+			}
+			if e.Time.IsZero() || (!tt.wantTime.IsZero() && !e.Time.Equal(tt.wantTime)) {
+				t.Errorf("time = %v, want %v", e.Time, tt.wantTime)
+			}
+			if e.CorrelationID != tt.wantCorrID {
+				t.Errorf("correlation id = %q, want %q", e.CorrelationID, tt.wantCorrID)
+			}
+
+			var data LogData
+			if err := json.Unmarshal(e.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Name != "orders" || data.Data != "order placed" {
+				t.Errorf("data = %+v", data)
+			}
+		})
+	}
+}
+
+func TestDecodeV1Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		// valid JSON, but not a log payload
+		{"array", `["orders"]`},
+		{"wrong shape", `{"name":1}`},
+		{"missing name", `{"data":"order placed"}`},
+	}
+
+	for _, tt := range tests {
+		if _, err := Decode(amqp.Delivery{Body: []byte(tt.body)}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, ErrInvalid)
+		}
+	}
+}
+
+func TestUpcast(t *testing.T) {
+	v1 := Envelope{SchemaVersion: 1, Data: json.RawMessage(`{"name":"orders","data":"x"}`)}
+	got, err := upcast(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SchemaVersion != CurrentVersion || got.Type != TypeLog || got.ID == "" || got.Source != "unknown" || got.Time.IsZero() {
+		t.Errorf("upcast(v1) = %+v", got)
+	}
+
+	// the current version is left as it is
+	current := Envelope{ID: "1", Type: TypeMail, SchemaVersion: CurrentVersion, Source: "auth-service", Data: json.RawMessage(`{}`)}
+	got, err = upcast(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != TypeMail || got.ID != "1" || got.Source != "auth-service" {
+		t.Errorf("upcast(current) = %+v", got)
+	}
+
+	for _, version := range []int{-1, 0, CurrentVersion + 1} {
+		if _, err := upcast(Envelope{SchemaVersion: version}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("upcast(version %d): err = %v, want %v", version, err, ErrInvalid)
+		}
+	}
+	if _, err := upcast(Envelope{SchemaVersion: 1, Data: json.RawMessage(`"orders"`)}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("upcast(v1 string): err = %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestUpcastV1KeepsMetadata(t *testing.T) {
+	at := time.Date(2025, 6, 1, 8, 30, 0, 0, time.UTC)
+	e := Envelope{ID: "msg-1", SchemaVersion: 1, Source: "front-end", Time: at, Data: json.RawMessage(`{"name":"orders"}`)}
+
+	got, err := upcastV1(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "msg-1" || got.Source != "front-end" || !got.Time.Equal(at) || got.SchemaVersion != 2 {
+		t.Errorf("upcastV1 = %+v", got)
+	}
+}
@@ -0,0 +1,301 @@
+package amqptest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame types.
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+// protocolHeader opens every AMQP 0-9-1 connection.
+const protocolHeader = "AMQP\x00\x00\x09\x01"
+
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var head [7]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		typ:     head[0],
+		channel: binary.BigEndian.Uint16(head[1:3]),
+		payload: make([]byte, binary.BigEndian.Uint32(head[3:7])),
+	}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+
+	end, err := r.ReadByte()
+	if err != nil {
+		return frame{}, err
+	}
+	if end != frameEnd {
+		return frame{}, fmt.Errorf("bad frame end %#x", end)
+	}
+	return f, nil
+}
+
+func (f frame) appendTo(b []byte) []byte {
+	b = append(b, f.typ)
+	b = binary.BigEndian.AppendUint16(b, f.channel)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(f.payload)))
+	b = append(b, f.payload...)
+	return append(b, frameEnd)
+}
+
+// encoder builds the arguments of a method frame.
+type encoder struct {
+	b []byte
+}
+
+func method(class, id uint16) *encoder {
+	e := &encoder{}
+	return e.short(class).short(id)
+}
+
+func (e *encoder) octet(v byte) *encoder {
+	e.b = append(e.b, v)
+	return e
+}
+
+func (e *encoder) short(v uint16) *encoder {
+	e.b = binary.BigEndian.AppendUint16(e.b, v)
+	return e
+}
+
+func (e *encoder) long(v uint32) *encoder {
+	e.b = binary.BigEndian.AppendUint32(e.b, v)
+	return e
+}
+
+func (e *encoder) longlong(v uint64) *encoder {
+	e.b = binary.BigEndian.AppendUint64(e.b, v)
+	return e
+}
+
+func (e *encoder) shortstr(s string) *encoder {
+	e.b = append(e.b, byte(len(s)))
+	e.b = append(e.b, s...)
+	return e
+}
+
+func (e *encoder) longstr(s string) *encoder {
+	e.b = binary.BigEndian.AppendUint32(e.b, uint32(len(s)))
+	e.b = append(e.b, s...)
+	return e
+}
+
+// bits packs consecutive bit arguments into one octet, lowest bit first.
+func (e *encoder) bits(bs ...bool) *encoder {
+	var v byte
+	for i, b := range bs {
+		if b {
+			v |= 1 << i
+		}
+	}
+	return e.octet(v)
+}
+
+// table writes a field table given in its wire encoding, or an empty one.
+func (e *encoder) table(raw []byte) *encoder {
+	if raw == nil {
+		return e.long(0)
+	}
+	e.b = append(e.b, raw...)
+	return e
+}
+
+var errShort = errors.New("frame too short")
+
+// decoder reads the arguments of a frame. The first error sticks.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < n {
+		d.err = errShort
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) octet() byte {
+	if v := d.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *decoder) short() uint16 {
+	if v := d.take(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (d *decoder) long() uint32 {
+	if v := d.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) longlong() uint64 {
+	if v := d.take(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (d *decoder) shortstr() string {
+	return string(d.take(int(d.octet())))
+}
+
+func (d *decoder) longstr() string {
+	return string(d.take(int(d.long())))
+}
+
+// bits reads one octet of packed bit arguments.
+func (d *decoder) bits(n int) []bool {
+	v := d.octet()
+	bs := make([]bool, n)
+	for i := range bs {
+		bs[i] = v&(1<<i) != 0
+	}
+	return bs
+}
+
+// table returns a field table in its wire encoding, length included; the server only
+// passes tables on and never looks inside.
+func (d *decoder) table() []byte {
+	if d.err != nil || len(d.b) < 4 {
+		d.err = errShort
+		return nil
+	}
+	n := 4 + int(binary.BigEndian.Uint32(d.b))
+	return append([]byte(nil), d.take(n)...)
+}
+
+// properties are the basic class content properties.
+type properties struct {
+	flags           uint16
+	contentType     string
+	contentEncoding string
+	headers         []byte
+	deliveryMode    byte
+	priority        byte
+	correlationID   string
+	replyTo         string
+	expiration      string
+	messageID       string
+	timestamp       uint64
+	typ             string
+	userID          string
+	appID           string
+	clusterID       string
+}
+
+// Property flags, from the highest bit down.
+const (
+	flagContentType     = 1 << 15
+	flagContentEncoding = 1 << 14
+	flagHeaders         = 1 << 13
+	flagDeliveryMode    = 1 << 12
+	flagPriority        = 1 << 11
+	flagCorrelationID   = 1 << 10
+	flagReplyTo         = 1 << 9
+	flagExpiration      = 1 << 8
+	flagMessageID       = 1 << 7
+	flagTimestamp       = 1 << 6
+	flagType            = 1 << 5
+	flagUserID          = 1 << 4
+	flagAppID           = 1 << 3
+	flagClusterID       = 1 << 2
+)
+
+func (p *properties) decode(d *decoder) {
+	p.flags = d.short()
+	str := func(flag uint16, v *string) {
+		if p.flags&flag != 0 {
+			*v = d.shortstr()
+		}
+	}
+
+	str(flagContentType, &p.contentType)
+	str(flagContentEncoding, &p.contentEncoding)
+	if p.flags&flagHeaders != 0 {
+		p.headers = d.table()
+	}
+	if p.flags&flagDeliveryMode != 0 {
+		p.deliveryMode = d.octet()
+	}
+	if p.flags&flagPriority != 0 {
+		p.priority = d.octet()
+	}
+	str(flagCorrelationID, &p.correlationID)
+	str(flagReplyTo, &p.replyTo)
+	str(flagExpiration, &p.expiration)
+	str(flagMessageID, &p.messageID)
+	if p.flags&flagTimestamp != 0 {
+		p.timestamp = d.longlong()
+	}
+	str(flagType, &p.typ)
+	str(flagUserID, &p.userID)
+	str(flagAppID, &p.appID)
+	str(flagClusterID, &p.clusterID)
+}
+
+func (p *properties) encode(e *encoder) {
+	e.short(p.flags)
+	str := func(flag uint16, v string) {
+		if p.flags&flag != 0 {
+			e.shortstr(v)
+		}
+	}
+
+	str(flagContentType, p.contentType)
+	str(flagContentEncoding, p.contentEncoding)
+	if p.flags&flagHeaders != 0 {
+		e.table(p.headers)
+	}
+	if p.flags&flagDeliveryMode != 0 {
+		e.octet(p.deliveryMode)
+	}
+	if p.flags&flagPriority != 0 {
+		e.octet(p.priority)
+	}
+	str(flagCorrelationID, p.correlationID)
+	str(flagReplyTo, p.replyTo)
+	str(flagExpiration, p.expiration)
+	str(flagMessageID, p.messageID)
+	if p.flags&flagTimestamp != 0 {
+		e.longlong(p.timestamp)
+	}
+	str(flagType, p.typ)
+	str(flagUserID, p.userID)
+	str(flagAppID, p.appID)
+	str(flagClusterID, p.clusterID)
+}
@@ -0,0 +1,951 @@
+// Package amqptest is an in-memory AMQP 0-9-1 server for tests of the RabbitMQ clients.
+//
+// It speaks enough of the protocol for amqp091-go: exchanges (direct, fanout and topic),
+// queues and bindings, publishing with publisher confirms and mandatory returns,
+// consuming with acknowledgements and prefetch, basic.get and RabbitMQ's direct reply-to.
+// Messages never expire and are never dead-lettered; queue and exchange arguments are
+// accepted and ignored. All state is kept across Stop and Restart, as if every queue was
+// durable.
+package amqptest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// directReplyTo is the pseudo-queue of RabbitMQ's direct reply-to.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// Reply codes.
+const (
+	replyNoRoute         = 312
+	replyConnectionForce = 320
+	replyNotFound        = 404
+	replyPrecondition    = 406
+	replyChannelError    = 504
+	replyNotImplemented  = 540
+)
+
+// Server is an AMQP server listening on a local port. It is safe for concurrent use.
+type Server struct {
+	addr string
+
+	mu        sync.Mutex
+	lis       net.Listener
+	conns     map[*conn]struct{}
+	exchanges map[string]*exchange
+	queues    map[string]*queue
+	replies   map[string]*channel
+	names     int
+	wg        sync.WaitGroup
+}
+
+// NewServer starts a server on a free local port.
+func NewServer() (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		addr:      lis.Addr().String(),
+		conns:     map[*conn]struct{}{},
+		exchanges: map[string]*exchange{},
+		queues:    map[string]*queue{},
+		replies:   map[string]*channel{},
+	}
+	for name, kind := range map[string]string{"amq.direct": "direct", "amq.fanout": "fanout", "amq.topic": "topic"} {
+		s.exchanges[name] = &exchange{name: name, kind: kind}
+	}
+	s.serve(lis)
+
+	return s, nil
+}
+
+// URL is the address clients dial.
+func (s *Server) URL() string {
+	return "amqp://guest:guest@" + s.addr + "/"
+}
+
+func (s *Server) serve(lis net.Listener) {
+	s.mu.Lock()
+	s.lis = lis
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			nc, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serveConn(nc)
+			}()
+		}
+	}()
+}
+
+// CloseConnections closes every client connection the way a broker shutting down does,
+// with connection.close 320 CONNECTION_FORCED. The server keeps accepting connections.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.send(0, method(10, 50).short(replyConnectionForce).
+			shortstr("CONNECTION_FORCED - broker forced connection closure with reason 'shutdown'").
+			short(0).short(0))
+		c.nc.Close()
+	}
+}
+
+// Stop stops accepting connections and closes the open ones, as a broker going down.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	lis := s.lis
+	s.lis = nil
+	s.mu.Unlock()
+
+	if lis != nil {
+		lis.Close()
+	}
+	s.CloseConnections()
+}
+
+// Restart accepts connections again on the same address after Stop.
+func (s *Server) Restart() error {
+	var lis net.Listener
+	var err error
+	// the port may take a moment to be released
+	for range 50 {
+		if lis, err = net.Listen("tcp", s.addr); err == nil {
+			s.serve(lis)
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+// Close stops the server and waits for its goroutines.
+func (s *Server) Close() {
+	s.Stop()
+	s.wg.Wait()
+}
+
+// Connections returns the number of open client connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// Messages returns the number of messages ready in a queue, -1 when there is no such queue.
+func (s *Server) Messages(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[name]
+	if !ok {
+		return -1
+	}
+	return len(q.messages)
+}
+
+// Consumers returns the number of consumers of a queue.
+func (s *Server) Consumers(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if q, ok := s.queues[name]; ok {
+		return len(q.consumers)
+	}
+	return 0
+}
+
+type exchange struct {
+	name     string
+	kind     string
+	bindings []binding
+}
+
+type binding struct {
+	queue string
+	key   string
+}
+
+// route returns the queues a message published with key goes to.
+func (e *exchange) route(key string) []string {
+	var queues []string
+	for _, b := range e.bindings {
+		var match bool
+		switch e.kind {
+		case "fanout":
+			match = true
+		case "topic":
+			match = topicMatch(strings.Split(b.key, "."), strings.Split(key, "."))
+		default:
+			match = b.key == key
+		}
+		if match && !slices.Contains(queues, b.queue) {
+			queues = append(queues, b.queue)
+		}
+	}
+	return queues
+}
+
+// topicMatch matches the words of a routing key against a binding pattern, where "*"
+// is exactly one word and "#" any number of words.
+func topicMatch(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatch(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatch(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && words[0] == pattern[0] && topicMatch(pattern[1:], words[1:])
+	}
+}
+
+type message struct {
+	exchange    string
+	routingKey  string
+	props       properties
+	body        []byte
+	redelivered bool
+}
+
+type queue struct {
+	name      string
+	messages  []*message
+	consumers []*consumer
+	next      int
+}
+
+type consumer struct {
+	tag   string
+	queue *queue
+	ch    *channel
+	noAck bool
+}
+
+// unacked is a delivery waiting for its acknowledgement.
+type unacked struct {
+	queue *queue
+	msg   *message
+}
+
+type channel struct {
+	id   uint16
+	conn *conn
+
+	closing   bool
+	flow      bool
+	prefetch  int
+	confirm   bool
+	published uint64
+
+	tag       uint64
+	unacked   map[uint64]unacked
+	consumers map[string]*consumer
+
+	// replyTag is the consumer tag of the direct reply-to consumer, token the name its
+	// replies are addressed to
+	replyTag string
+	token    string
+
+	// the message being received
+	incoming *incoming
+}
+
+type incoming struct {
+	exchange   string
+	routingKey string
+	mandatory  bool
+	size       uint64
+	props      properties
+	body       []byte
+	header     bool
+}
+
+// full reports whether the channel's prefetch is used up.
+func (ch *channel) full() bool {
+	return !ch.flow || ch.closing || (ch.prefetch > 0 && len(ch.unacked) >= ch.prefetch)
+}
+
+type conn struct {
+	server *Server
+	nc     net.Conn
+
+	// wmu guards writes and frameMax
+	wmu      sync.Mutex
+	frameMax int
+
+	// guarded by server.mu
+	channels map[uint16]*channel
+	closing  bool
+}
+
+// send writes a method, followed by the content of msg if it is not nil. The frames of
+// one method are never interleaved with others.
+func (c *conn) send(channel uint16, m *encoder, msg ...*message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	out := frame{typ: frameMethod, channel: channel, payload: m.b}.appendTo(nil)
+	for _, msg := range msg {
+		header := &encoder{}
+		header.short(60).short(0).longlong(uint64(len(msg.body)))
+		msg.props.encode(header)
+		out = frame{typ: frameHeader, channel: channel, payload: header.b}.appendTo(out)
+
+		maxBody := len(msg.body)
+		if c.frameMax > 8 {
+			maxBody = c.frameMax - 8
+		}
+		for body := msg.body; len(body) > 0; {
+			n := min(len(body), maxBody)
+			out = frame{typ: frameBody, channel: channel, payload: body[:n]}.appendTo(out)
+			body = body[n:]
+		}
+	}
+
+	_, err := c.nc.Write(out)
+	return err
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer nc.Close()
+
+	r := bufio.NewReader(nc)
+	header := make([]byte, len(protocolHeader))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	if string(header) != protocolHeader {
+		nc.Write([]byte(protocolHeader))
+		return
+	}
+
+	c := &conn{server: s, nc: nc, channels: map[uint16]*channel{}}
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, ch := range c.channels {
+			s.closeChannel(ch)
+		}
+		delete(s.conns, c)
+	}()
+
+	c.send(0, method(10, 10).octet(0).octet(9).table(nil).longstr("PLAIN AMQPLAIN").longstr("en_US"))
+
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return
+		}
+
+		switch f.typ {
+		case frameHeartbeat:
+			continue
+		case frameMethod:
+			if f.channel == 0 {
+				if stop := s.connectionMethod(c, f, done); stop {
+					return
+				}
+				continue
+			}
+		}
+
+		s.mu.Lock()
+		s.channelFrame(c, f)
+		s.mu.Unlock()
+	}
+}
+
+// connectionMethod handles the methods of the connection class and reports whether the
+// connection is done.
+func (s *Server) connectionMethod(c *conn, f frame, done chan struct{}) bool {
+	d := &decoder{b: f.payload}
+	class, id := d.short(), d.short()
+	if class != 10 {
+		s.connectionError(c, replyNotImplemented, "NOT_IMPLEMENTED - unexpected method on channel 0", class, id)
+		return false
+	}
+
+	switch id {
+	case 11: // start-ok
+		c.send(0, method(10, 30).short(2047).long(131072).short(60))
+	case 31: // tune-ok
+		d.short()
+		c.wmu.Lock()
+		c.frameMax = int(d.long())
+		c.wmu.Unlock()
+		if heartbeat := time.Duration(d.short()) * time.Second; heartbeat > 0 {
+			go c.heartbeat(heartbeat/2, done)
+		}
+	case 40: // open
+		c.send(0, method(10, 41).shortstr(""))
+	case 50: // close
+		c.send(0, method(10, 51))
+		return true
+	case 51: // close-ok
+		return true
+	}
+	return false
+}
+
+func (c *conn) heartbeat(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	beat := frame{typ: frameHeartbeat}.appendTo(nil)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.wmu.Lock()
+			_, err := c.nc.Write(beat)
+			c.wmu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// connectionError closes the connection with a hard error; the client answers with
+// close-ok, which ends the connection.
+func (s *Server) connectionError(c *conn, code uint16, text string, class, id uint16) {
+	c.closing = true
+	c.send(0, method(10, 50).short(code).shortstr(text).short(class).short(id))
+}
+
+// channelError closes a channel with a soft error. Its deliveries are requeued and the
+// frames the client sends until it answers with close-ok are dropped.
+func (s *Server) channelError(ch *channel, code uint16, text string, class, id uint16) {
+	s.releaseChannel(ch)
+	ch.closing = true
+	ch.conn.send(ch.id, method(20, 40).short(code).shortstr(text).short(class).short(id))
+}
+
+// releaseChannel requeues the unacknowledged deliveries of a channel and cancels its
+// consumers.
+func (s *Server) releaseChannel(ch *channel) {
+	for _, c := range ch.consumers {
+		c.queue.consumers = slices.DeleteFunc(c.queue.consumers, func(other *consumer) bool { return other == c })
+	}
+	ch.consumers = map[string]*consumer{}
+	if ch.token != "" {
+		delete(s.replies, ch.token)
+	}
+	ch.replyTag = ""
+
+	s.requeue(ch, func(uint64) bool { return true })
+}
+
+func (s *Server) closeChannel(ch *channel) {
+	s.releaseChannel(ch)
+	delete(ch.conn.channels, ch.id)
+}
+
+// requeue puts the unacknowledged deliveries selected by tag back at the head of their
+// queues, in their original order.
+func (s *Server) requeue(ch *channel, selected func(tag uint64) bool) {
+	var tags []uint64
+	for tag := range ch.unacked {
+		if selected(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	slices.Sort(tags)
+
+	for i := len(tags) - 1; i >= 0; i-- {
+		u := ch.unacked[tags[i]]
+		delete(ch.unacked, tags[i])
+
+		u.msg.redelivered = true
+		u.queue.messages = slices.Insert(u.queue.messages, 0, u.msg)
+	}
+	s.dispatchAll()
+}
+
+// channelFrame handles a frame of a channel. s.mu is held.
+func (s *Server) channelFrame(c *conn, f frame) {
+	if c.closing {
+		return
+	}
+
+	ch, ok := c.channels[f.channel]
+	if f.typ == frameMethod {
+		d := &decoder{b: f.payload}
+		class, id := d.short(), d.short()
+
+		if class == 20 && id == 10 { // channel.open
+			if ok {
+				s.connectionError(c, replyChannelError, "CHANNEL_ERROR - second 'channel.open' seen", class, id)
+				return
+			}
+			c.channels[f.channel] = &channel{
+				id:        f.channel,
+				conn:      c,
+				flow:      true,
+				unacked:   map[uint64]unacked{},
+				consumers: map[string]*consumer{},
+			}
+			c.send(f.channel, method(20, 11).longstr(""))
+			return
+		}
+		if !ok {
+			s.connectionError(c, replyChannelError, "CHANNEL_ERROR - expected 'channel.open'", class, id)
+			return
+		}
+
+		if ch.closing {
+			switch {
+			case class == 20 && id == 41: // close-ok
+				delete(c.channels, ch.id)
+			case class == 20 && id == 40: // close
+				c.send(ch.id, method(20, 41))
+				delete(c.channels, ch.id)
+			}
+			return
+		}
+
+		if ch.incoming != nil {
+			s.connectionError(c, replyNotImplemented, "UNEXPECTED_FRAME - expected content header", class, id)
+			return
+		}
+		s.channelMethod(ch, class, id, d)
+		return
+	}
+
+	if !ok || ch.closing {
+		return
+	}
+	in := ch.incoming
+	if in == nil {
+		s.connectionError(c, replyNotImplemented, "UNEXPECTED_FRAME - content without basic.publish", 0, 0)
+		return
+	}
+
+	switch f.typ {
+	case frameHeader:
+		d := &decoder{b: f.payload}
+		d.short() // class
+		d.short() // weight
+		in.size = d.longlong()
+		in.props.decode(d)
+		in.header = true
+	case frameBody:
+		in.body = append(in.body, f.payload...)
+	}
+	if in.header && uint64(len(in.body)) >= in.size {
+		ch.incoming = nil
+		s.publish(ch, in)
+	}
+}
+
+func (s *Server) channelMethod(ch *channel, class, id uint16, d *decoder) {
+	c := ch.conn
+
+	switch uint32(class)<<16 | uint32(id) {
+	case 20<<16 | 20: // channel.flow
+		ch.flow = d.bits(1)[0]
+		c.send(ch.id, method(20, 21).bits(ch.flow))
+		s.dispatchAll()
+
+	case 20<<16 | 40: // channel.close
+		s.closeChannel(ch)
+		c.send(ch.id, method(20, 41))
+
+	case 40<<16 | 10: // exchange.declare
+		d.short()
+		name, kind := d.shortstr(), d.shortstr()
+		bits := d.bits(5)
+		passive, noWait := bits[0], bits[4]
+
+		e, ok := s.exchanges[name]
+		switch {
+		case !ok && passive:
+			s.channelError(ch, replyNotFound, fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", name), class, id)
+			return
+		case ok && !passive && e.kind != kind:
+			s.channelError(ch, replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s' in vhost '/': received '%s' but current is '%s'", name, kind, e.kind), class, id)
+			return
+		case !ok:
+			s.exchanges[name] = &exchange{name: name, kind: kind}
+		}
+		if !noWait {
+			c.send(ch.id, method(40, 11))
+		}
+
+	case 40<<16 | 20: // exchange.delete
+		d.short()
+		name := d.shortstr()
+		noWait := d.bits(2)[1]
+		delete(s.exchanges, name)
+		if !noWait {
+			c.send(ch.id, method(40, 21))
+		}
+
+	case 50<<16 | 10: // queue.declare
+		d.short()
+		name := d.shortstr()
+		bits := d.bits(5)
+		passive, noWait := bits[0], bits[4]
+
+		if name == "" {
+			s.names++
+			name = fmt.Sprintf("amq.gen-%d", s.names)
+		}
+		q, ok := s.queues[name]
+		if !ok {
+			if passive {
+				s.channelError(ch, replyNotFound, fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name), class, id)
+				return
+			}
+			q = &queue{name: name}
+			s.queues[name] = q
+		}
+		if !noWait {
+			c.send(ch.id, method(50, 11).shortstr(name).long(uint32(len(q.messages))).long(uint32(len(q.consumers))))
+		}
+
+	case 50<<16 | 20, 50<<16 | 50: // queue.bind, queue.unbind
+		d.short()
+		name, exchangeName, key := d.shortstr(), d.shortstr(), d.shortstr()
+		noWait := id == 20 && d.bits(1)[0]
+
+		e, q := s.exchanges[exchangeName], s.queues[name]
+		switch {
+		case q == nil:
+			s.channelError(ch, replyNotFound, fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name), class, id)
+			return
+		case e == nil:
+			s.channelError(ch, replyNotFound, fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", exchangeName), class, id)
+			return
+		}
+
+		b := binding{queue: name, key: key}
+		e.bindings = slices.DeleteFunc(e.bindings, func(other binding) bool { return other == b })
+		if id == 20 {
+			e.bindings = append(e.bindings, b)
+			if !noWait {
+				c.send(ch.id, method(50, 21))
+			}
+		} else {
+			c.send(ch.id, method(50, 51))
+		}
+
+	case 50<<16 | 30: // queue.purge
+		d.short()
+		name := d.shortstr()
+		noWait := d.bits(1)[0]
+
+		q, ok := s.queues[name]
+		if !ok {
+			s.channelError(ch, replyNotFound, fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name), class, id)
+			return
+		}
+		n := len(q.messages)
+		q.messages = nil
+		if !noWait {
+			c.send(ch.id, method(50, 31).long(uint32(n)))
+		}
+
+	case 50<<16 | 40: // queue.delete
+		d.short()
+		name := d.shortstr()
+		noWait := d.bits(3)[2]
+
+		n := 0
+		if q, ok := s.queues[name]; ok {
+			n = len(q.messages)
+			for _, cons := range q.consumers {
+				delete(cons.ch.consumers, cons.tag)
+				cons.ch.conn.send(cons.ch.id, method(60, 30).shortstr(cons.tag).bits(true))
+			}
+			delete(s.queues, name)
+			for _, e := range s.exchanges {
+				e.bindings = slices.DeleteFunc(e.bindings, func(b binding) bool { return b.queue == name })
+			}
+		}
+		if !noWait {
+			c.send(ch.id, method(50, 41).long(uint32(n)))
+		}
+
+	case 60<<16 | 10: // basic.qos
+		d.long()
+		ch.prefetch = int(d.short())
+		c.send(ch.id, method(60, 11))
+		s.dispatchAll()
+
+	case 60<<16 | 20: // basic.consume
+		d.short()
+		name, tag := d.shortstr(), d.shortstr()
+		bits := d.bits(4)
+		noAck, noWait := bits[1], bits[3]
+
+		if tag == "" {
+			s.names++
+			tag = fmt.Sprintf("amq.ctag-%d", s.names)
+		}
+		if _, ok := ch.consumers[tag]; ok || tag == ch.replyTag {
+			s.connectionError(c, 530, fmt.Sprintf("NOT_ALLOWED - attempt to reuse consumer tag '%s'", tag), class, id)
+			return
+		}
+
+		if name == directReplyTo {
+			if !noAck {
+				s.channelError(ch, replyPrecondition, "PRECONDITION_FAILED - reply consumer cannot acknowledge", class, id)
+				return
+			}
+			s.names++
+			ch.replyTag = tag
+			ch.token = fmt.Sprintf("%s.g%d", directReplyTo, s.names)
+			s.replies[ch.token] = ch
+			if !noWait {
+				c.send(ch.id, method(60, 21).shortstr(tag))
+			}
+			return
+		}
+
+		q, ok := s.queues[name]
+		if !ok {
+			s.channelError(ch, replyNotFound, fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name), class, id)
+			return
+		}
+		cons := &consumer{tag: tag, queue: q, ch: ch, noAck: noAck}
+		ch.consumers[tag] = cons
+		q.consumers = append(q.consumers, cons)
+		if !noWait {
+			c.send(ch.id, method(60, 21).shortstr(tag))
+		}
+		s.dispatch(q)
+
+	case 60<<16 | 30: // basic.cancel
+		tag := d.shortstr()
+		noWait := d.bits(1)[0]
+
+		if cons, ok := ch.consumers[tag]; ok {
+			delete(ch.consumers, tag)
+			cons.queue.consumers = slices.DeleteFunc(cons.queue.consumers, func(other *consumer) bool { return other == cons })
+		}
+		if tag == ch.replyTag {
+			ch.replyTag = ""
+			delete(s.replies, ch.token)
+		}
+		if !noWait {
+			c.send(ch.id, method(60, 31).shortstr(tag))
+		}
+
+	case 60<<16 | 40: // basic.publish
+		d.short()
+		in := &incoming{exchange: d.shortstr(), routingKey: d.shortstr()}
+		in.mandatory = d.bits(2)[0]
+		ch.incoming = in
+
+	case 60<<16 | 70: // basic.get
+		d.short()
+		name := d.shortstr()
+		noAck := d.bits(1)[0]
+
+		q, ok := s.queues[name]
+		if !ok {
+			s.channelError(ch, replyNotFound, fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name), class, id)
+			return
+		}
+		if len(q.messages) == 0 {
+			c.send(ch.id, method(60, 72).shortstr(""))
+			return
+		}
+
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		ch.tag++
+		if !noAck {
+			ch.unacked[ch.tag] = unacked{queue: q, msg: msg}
+		}
+		c.send(ch.id, method(60, 71).longlong(ch.tag).bits(msg.redelivered).
+			shortstr(msg.exchange).shortstr(msg.routingKey).long(uint32(len(q.messages))), msg)
+
+	case 60<<16 | 80: // basic.ack
+		tag := d.longlong()
+		multiple := d.bits(1)[0]
+		if !s.settle(ch, tag, multiple, false, class, id) {
+			return
+		}
+
+	case 60<<16 | 90: // basic.reject
+		tag := d.longlong()
+		requeue := d.bits(1)[0]
+		s.settle(ch, tag, false, requeue, class, id)
+
+	case 60<<16 | 120: // basic.nack
+		tag := d.longlong()
+		bits := d.bits(2)
+		s.settle(ch, tag, bits[0], bits[1], class, id)
+
+	case 60<<16 | 110: // basic.recover
+		s.requeue(ch, func(uint64) bool { return true })
+		c.send(ch.id, method(60, 111))
+
+	case 85<<16 | 10: // confirm.select
+		ch.confirm = true
+		if noWait := d.bits(1)[0]; !noWait {
+			c.send(ch.id, method(85, 11))
+		}
+
+	default:
+		s.connectionError(c, replyNotImplemented, fmt.Sprintf("NOT_IMPLEMENTED - method %d.%d", class, id), class, id)
+	}
+}
+
+// settle acknowledges, or with requeue puts back, the deliveries up to tag. It reports
+// false when the tag is unknown, which closes the channel.
+func (s *Server) settle(ch *channel, tag uint64, multiple, requeue bool, class, id uint16) bool {
+	selected := func(t uint64) bool { return t == tag }
+	if multiple {
+		selected = func(t uint64) bool { return tag == 0 || t <= tag }
+	} else if _, ok := ch.unacked[tag]; !ok {
+		s.channelError(ch, replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag), class, id)
+		return false
+	}
+
+	if requeue {
+		s.requeue(ch, selected)
+		return true
+	}
+	for t := range ch.unacked {
+		if selected(t) {
+			delete(ch.unacked, t)
+		}
+	}
+	s.dispatchAll()
+	return true
+}
+
+// publish routes a received message and confirms it when the channel is in confirm mode.
+func (s *Server) publish(ch *channel, in *incoming) {
+	if in.props.replyTo == directReplyTo {
+		if ch.replyTag == "" {
+			s.channelError(ch, replyPrecondition, "PRECONDITION_FAILED - fast reply consumer does not exist", 60, 40)
+			return
+		}
+		in.props.replyTo = ch.token
+	}
+
+	var queues []string
+	routed := false
+	switch {
+	case in.exchange == "" && strings.HasPrefix(in.routingKey, directReplyTo+"."):
+		if to, ok := s.replies[in.routingKey]; ok {
+			routed = true
+			to.tag++
+			to.conn.send(to.id, method(60, 60).shortstr(to.replyTag).longlong(to.tag).bits(false).
+				shortstr(in.exchange).shortstr(in.routingKey), &message{props: in.props, body: in.body})
+		}
+	case in.exchange == "":
+		if _, ok := s.queues[in.routingKey]; ok {
+			queues = []string{in.routingKey}
+		}
+	default:
+		e, ok := s.exchanges[in.exchange]
+		if !ok {
+			s.channelError(ch, replyNotFound, fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", in.exchange), 60, 40)
+			return
+		}
+		queues = e.route(in.routingKey)
+	}
+
+	for _, name := range queues {
+		q := s.queues[name]
+		q.messages = append(q.messages, &message{
+			exchange:   in.exchange,
+			routingKey: in.routingKey,
+			props:      in.props,
+			body:       in.body,
+		})
+		routed = true
+	}
+
+	if !routed && in.mandatory {
+		ch.conn.send(ch.id, method(60, 50).short(replyNoRoute).shortstr("NO_ROUTE").
+			shortstr(in.exchange).shortstr(in.routingKey), &message{props: in.props, body: in.body})
+	}
+	if ch.confirm {
+		ch.published++
+		ch.conn.send(ch.id, method(60, 80).longlong(ch.published).bits(false))
+	}
+
+	for _, name := range queues {
+		s.dispatch(s.queues[name])
+	}
+}
+
+func (s *Server) dispatchAll() {
+	for _, q := range s.queues {
+		s.dispatch(q)
+	}
+}
+
+// dispatch hands the ready messages of a queue to its consumers in turn, as far as their
+// prefetch allows.
+func (s *Server) dispatch(q *queue) {
+	for len(q.messages) > 0 && len(q.consumers) > 0 {
+		var cons *consumer
+		for i := range q.consumers {
+			candidate := q.consumers[(q.next+i)%len(q.consumers)]
+			if candidate.noAck || !candidate.ch.full() {
+				cons = candidate
+				q.next = (q.next + i + 1) % len(q.consumers)
+				break
+			}
+		}
+		if cons == nil {
+			return
+		}
+
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+
+		ch := cons.ch
+		ch.tag++
+		if !cons.noAck {
+			ch.unacked[ch.tag] = unacked{queue: q, msg: msg}
+		}
+		ch.conn.send(ch.id, method(60, 60).shortstr(cons.tag).longlong(ch.tag).bits(msg.redelivered).
+			shortstr(msg.exchange).shortstr(msg.routingKey), msg)
+	}
+}
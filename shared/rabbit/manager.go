@@ -0,0 +1,230 @@
+package rabbit
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrNotConnected is returned while the manager is (re)connecting.
+var ErrNotConnected = errors.New("rabbitmq is not connected")
+
+// Connection states reported by Status.
+const (
+	StateConnecting = "connecting"
+	StateConnected  = "connected"
+	StateClosed     = "closed"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Status describes the connection for health checks.
+type Status struct {
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Reconnects int       `json:"reconnects"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// Topology declares exchanges, queues and bindings on a fresh connection.
+type Topology func(ch *amqp.Channel) error
+
+// Manager owns the RabbitMQ connection of a service. It watches the connection, dials
+// again with backoff and jitter when it drops, and re-declares the registered topology
+// before reporting itself ready. Consumers and publishers get their channels from it.
+type Manager struct {
+	url string
+
+	mu         sync.Mutex
+	conn       *amqp.Connection
+	topologies []Topology
+	status     Status
+	ready      chan struct{}
+	dialed     bool
+	closed     bool
+}
+
+func NewManager(url string) *Manager {
+	return &Manager{
+		url:    url,
+		status: Status{State: StateConnecting, Since: time.Now()},
+		ready:  make(chan struct{}),
+	}
+}
+
+// Start dials until the first connection is established and then keeps the connection
+// alive in the background.
+func (m *Manager) Start() {
+	closes := m.dial()
+	if closes == nil {
+		return
+	}
+	go m.watch(closes)
+}
+
+// Declare registers topology to be declared on every connection. When already connected
+// it is declared right away.
+func (m *Manager) Declare(topology Topology) error {
+	m.mu.Lock()
+	m.topologies = append(m.topologies, topology)
+	conn := m.conn
+	m.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return declare(conn, topology)
+}
+
+func declare(conn *amqp.Connection, topologies ...Topology) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for _, topology := range topologies {
+		if err := topology(ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Channel opens a channel on the current connection.
+func (m *Manager) Channel() (*amqp.Channel, error) {
+	m.mu.Lock()
+	conn := m.conn
+	m.mu.Unlock()
+
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return conn.Channel()
+}
+
+// Ready returns a channel that is closed once the manager is connected. Callers that
+// lost their channel wait on it before trying again.
+func (m *Manager) Ready() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ready
+}
+
+// Status reports the connection state.
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.status
+}
+
+// Close closes the connection for good.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	conn := m.conn
+	m.conn = nil
+	m.status = Status{State: StateClosed, Since: time.Now(), Reconnects: m.status.Reconnects}
+	m.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func (m *Manager) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.closed
+}
+
+// dial connects and declares the topology, retrying with exponential backoff and
+// jitter. It returns the close notifications of the new connection, or nil when the
+// manager is closed meanwhile.
+func (m *Manager) dial() chan *amqp.Error {
+	backoff := minBackoff
+
+	for !m.isClosed() {
+		conn, err := amqp.Dial(m.url)
+		if err == nil {
+			m.mu.Lock()
+			topologies := m.topologies
+			m.mu.Unlock()
+
+			if err = declare(conn, topologies...); err == nil {
+				// registered before anyone uses the connection, so no close is missed
+				closes := conn.NotifyClose(make(chan *amqp.Error, 1))
+				m.connected(conn)
+				log.Println("Connected to rabbitmq.")
+				return closes
+			}
+			conn.Close()
+		}
+
+		m.mu.Lock()
+		m.status.LastError = err.Error()
+		m.mu.Unlock()
+
+		// add jitter
+		jitter := time.Duration(rand.Int63n(int64(time.Second)))
+		wait := backoff + jitter
+
+		log.Printf("RabbitMQ not ready (%v). Retrying in %v...", err, wait)
+		time.Sleep(wait)
+
+		// Double backoff
+		backoff = min(backoff*2, maxBackoff)
+	}
+
+	return nil
+}
+
+func (m *Manager) connected(conn *amqp.Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dialed {
+		m.status.Reconnects++
+	}
+	m.dialed = true
+	m.conn = conn
+	m.status.State = StateConnected
+	m.status.Since = time.Now()
+	close(m.ready)
+}
+
+// watch waits for the connection to drop and replaces it.
+func (m *Manager) watch(closes chan *amqp.Error) {
+	for {
+		reason, ok := <-closes
+		if !ok || reason == nil || m.isClosed() {
+			// closed on purpose
+			return
+		}
+
+		log.Println("RabbitMQ connection lost:", reason)
+
+		m.mu.Lock()
+		m.conn = nil
+		m.status.State = StateConnecting
+		m.status.Since = time.Now()
+		m.status.LastError = reason.Error()
+		m.ready = make(chan struct{})
+		m.mu.Unlock()
+
+		if closes = m.dial(); closes == nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package rabbit
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"shared/rabbit/amqptest"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// start connects a manager that declares the queue "jobs" to a fake server, and counts
+// how often the topology was declared.
+func start(t *testing.T) (*amqptest.Server, *Manager, *atomic.Int32) {
+	t.Helper()
+
+	srv, err := amqptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	var declared atomic.Int32
+	m := NewManager(srv.URL())
+	m.Declare(func(ch *amqp.Channel) error {
+		declared.Add(1)
+		_, err := ch.QueueDeclare("jobs", true, false, false, false, nil)
+		return err
+	})
+	t.Cleanup(func() { m.Close() })
+
+	m.Start()
+	return srv, m, &declared
+}
+
+// waitFor polls cond until it holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func publish(t *testing.T, m *Manager) {
+	t.Helper()
+
+	ch, err := m.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", "jobs", true, false, amqp.Publishing{Body: []byte("job")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := confirm.WaitContext(ctx); !ok || err != nil {
+		t.Fatalf("publish not confirmed: %v", err)
+	}
+}
+
+func TestManagerReconnects(t *testing.T) {
+	srv, m, declared := start(t)
+
+	if st := m.Status(); st.State != StateConnected || st.Reconnects != 0 {
+		t.Fatalf("status = %+v", st)
+	}
+	if n := declared.Load(); n != 1 {
+		t.Fatalf("topology declared %d times, want 1", n)
+	}
+	publish(t, m)
+
+	srv.CloseConnections()
+	waitFor(t, "the reconnect", func() bool { return m.Status().Reconnects == 1 })
+
+	select {
+	case <-m.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the manager is not ready after reconnecting")
+	}
+	if st := m.Status(); st.State != StateConnected || st.LastError == "" {
+		t.Errorf("status = %+v, want connected with the close reason", st)
+	}
+	if n := declared.Load(); n != 2 {
+		t.Errorf("topology declared %d times, want 2", n)
+	}
+
+	publish(t, m)
+	if n := srv.Messages("jobs"); n != 2 {
+		t.Errorf("queue holds %d messages, want 2", n)
+	}
+}
+
+func TestManagerWaitsForBroker(t *testing.T) {
+	srv, m, declared := start(t)
+
+	srv.Stop()
+	waitFor(t, "the lost connection", func() bool { return m.Status().State == StateConnecting })
+	if _, err := m.Channel(); err != ErrNotConnected {
+		t.Errorf("Channel() while down = %v, want %v", err, ErrNotConnected)
+	}
+
+	if err := srv.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the reconnect", func() bool { return m.Status().State == StateConnected })
+
+	if st := m.Status(); st.Reconnects != 1 {
+		t.Errorf("reconnects = %d, want 1", st.Reconnects)
+	}
+	if n := declared.Load(); n != 2 {
+		t.Errorf("topology declared %d times, want 2", n)
+	}
+	publish(t, m)
+}
+
+func TestManagerClose(t *testing.T) {
+	srv, m, _ := start(t)
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := m.Status(); st.State != StateClosed {
+		t.Errorf("state = %s, want %s", st.State, StateClosed)
+	}
+
+	// closed on purpose, so it stays down
+	time.Sleep(100 * time.Millisecond)
+	if n := srv.Connections(); n != 0 {
+		t.Errorf("%d connections after Close, want 0", n)
+	}
+	if _, err := m.Channel(); err != ErrNotConnected {
+		t.Errorf("Channel() after Close = %v, want %v", err, ErrNotConnected)
+	}
+}
@@ -0,0 +1,411 @@
+package rabbit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Request/reply over RabbitMQ. Requests are published to a durable queue per service, so
+// a call made while the backend is down waits in the queue until the backend is back or
+// the request expires. Replies come back through direct reply-to, matched to their call
+// by correlation ID.
+
+// directReplyTo is RabbitMQ's pseudo-queue for replies on the requesting channel.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+const (
+	// DefaultRPCTimeout bounds a call whose context has no deadline.
+	DefaultRPCTimeout = 10 * time.Second
+	// DefaultRPCPrefetch is the number of requests a server holds unacknowledged.
+	DefaultRPCPrefetch = 10
+)
+
+var (
+	// ErrRPCTimeout is returned when no reply arrived in time.
+	ErrRPCTimeout = errors.New("rpc call timed out")
+	// ErrUnroutable is returned when there is no queue for the request.
+	ErrUnroutable = errors.New("rpc request was not routable")
+)
+
+// RemoteError is returned by Call when the server's handler failed.
+type RemoteError struct {
+	Method  string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Method + ": " + e.Message
+}
+
+// rpcReply is the body of a reply.
+type rpcReply struct {
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// rpcResult hands a reply, or the reason there is none, to the waiting call.
+type rpcResult struct {
+	body []byte
+	err  error
+}
+
+// RPCQueue declares the durable request queue of a service.
+func RPCQueue(name string) Topology {
+	return func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclare(name, true, false, false, false, nil)
+		return err
+	}
+}
+
+// RPCClient calls RPC servers. All calls share one channel, which consumes the direct
+// reply-to queue; it is opened on the first call and again after a reconnect.
+type RPCClient struct {
+	manager *Manager
+	timeout time.Duration
+
+	mu      sync.Mutex
+	ch      *amqp.Channel
+	pending map[string]chan rpcResult
+}
+
+// NewRPCClient returns a client for the request queues, declaring them so requests are
+// kept even before a server ever ran.
+func NewRPCClient(manager *Manager, timeout time.Duration, queues ...string) (*RPCClient, error) {
+	for _, queue := range queues {
+		if err := manager.Declare(RPCQueue(queue)); err != nil {
+			return nil, err
+		}
+	}
+	if timeout <= 0 {
+		timeout = DefaultRPCTimeout
+	}
+
+	return &RPCClient{
+		manager: manager,
+		timeout: timeout,
+		pending: map[string]chan rpcResult{},
+	}, nil
+}
+
+// Call sends req as JSON to the server on queue and decodes the reply's data into resp,
+// which may be nil. The request expires with the call, so a server that comes back late
+// does not act on requests nobody waits for anymore.
+func (c *RPCClient) Call(ctx context.Context, queue, method string, req, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	id := correlationID()
+	results := make(chan rpcResult, 1)
+
+	if err := c.publish(ctx, queue, amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: id,
+		ReplyTo:       directReplyTo,
+		Type:          method,
+		Expiration:    expiration(time.Until(deadline)),
+		Timestamp:     time.Now(),
+		Body:          body,
+	}, results); err != nil {
+		return err
+	}
+	defer c.forget(id)
+
+	var result rpcResult
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %s on %s", ErrRPCTimeout, method, queue)
+		}
+		return ctx.Err()
+	case result = <-results:
+	}
+	if result.err != nil {
+		return result.err
+	}
+
+	var reply rpcReply
+	if err := json.Unmarshal(result.body, &reply); err != nil {
+		return fmt.Errorf("invalid rpc reply: %w", err)
+	}
+	if reply.Error != "" {
+		return &RemoteError{Method: method, Message: reply.Error}
+	}
+	if resp == nil || len(reply.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(reply.Data, resp)
+}
+
+// publish registers the call and publishes its request. The lock is held across both,
+// so a reply can never arrive before its call is known.
+func (c *RPCClient) publish(ctx context.Context, queue string, msg amqp.Publishing, results chan rpcResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, err := c.channel()
+	if err != nil {
+		return err
+	}
+
+	c.pending[msg.CorrelationId] = results
+	if err := ch.PublishWithContext(ctx, "", queue, true, false, msg); err != nil {
+		delete(c.pending, msg.CorrelationId)
+		return err
+	}
+	return nil
+}
+
+func (c *RPCClient) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+// channel returns the shared channel, opening it when needed. c.mu must be held.
+func (c *RPCClient) channel() (*amqp.Channel, error) {
+	if c.ch != nil && !c.ch.IsClosed() {
+		return c.ch, nil
+	}
+
+	ch, err := c.manager.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	// direct reply-to must be consumed in no-ack mode before the first request is sent
+	replies, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	c.ch = ch
+	go c.dispatch(ch, replies, returns)
+
+	return ch, nil
+}
+
+// dispatch hands replies and returned requests to their calls until the channel closes,
+// then fails the calls still waiting on it; their replies can no longer arrive.
+func (c *RPCClient) dispatch(ch *amqp.Channel, replies <-chan amqp.Delivery, returns <-chan amqp.Return) {
+	for replies != nil || returns != nil {
+		select {
+		case d, ok := <-replies:
+			if !ok {
+				replies = nil
+				continue
+			}
+			c.resolve(d.CorrelationId, rpcResult{body: d.Body})
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.resolve(r.CorrelationId, rpcResult{err: fmt.Errorf("%w: %s", ErrUnroutable, r.ReplyText)})
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ch == ch {
+		c.ch = nil
+	}
+	for id, results := range c.pending {
+		results <- rpcResult{err: ErrNotConnected}
+		delete(c.pending, id)
+	}
+}
+
+func (c *RPCClient) resolve(id string, result rpcResult) {
+	c.mu.Lock()
+	results, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+
+	if !ok {
+		// the call gave up already
+		return
+	}
+	results <- result
+}
+
+// Close closes the client's channel.
+func (c *RPCClient) Close() error {
+	c.mu.Lock()
+	ch := c.ch
+	c.ch = nil
+	c.mu.Unlock()
+
+	if ch == nil {
+		return nil
+	}
+	return ch.Close()
+}
+
+func correlationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// expiration formats a per-message TTL, which RabbitMQ takes in milliseconds.
+func expiration(d time.Duration) string {
+	return strconv.FormatInt(max(d.Milliseconds(), 1), 10)
+}
+
+// RPCHandler handles a request to method with a JSON body. The returned data is sent
+// back as JSON; an error is sent back as its message.
+type RPCHandler func(ctx context.Context, method string, body []byte) (any, error)
+
+// RPCServer consumes a request queue and replies to each request. Up to the prefetch
+// count of requests are handled concurrently.
+type RPCServer struct {
+	manager  *Manager
+	queue    string
+	handler  RPCHandler
+	prefetch int
+	timeout  time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRPCServer returns a server for queue, declaring it on every connection.
+func NewRPCServer(manager *Manager, queue string, handler RPCHandler) (*RPCServer, error) {
+	if err := manager.Declare(RPCQueue(queue)); err != nil {
+		return nil, err
+	}
+
+	return &RPCServer{
+		manager:  manager,
+		queue:    queue,
+		handler:  handler,
+		prefetch: DefaultRPCPrefetch,
+		timeout:  DefaultRPCTimeout,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Serve handles requests until Stop is called, consuming again after a reconnect.
+func (s *RPCServer) Serve() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.manager.Ready():
+		}
+
+		if err := s.consume(); err != nil {
+			log.Printf("RPC server on %s: %v", s.queue, err)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Stop stops serving. The requests being handled are finished first.
+func (s *RPCServer) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *RPCServer) consume() error {
+	ch, err := s.manager.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.Qos(s.prefetch, 0, false); err != nil {
+		return err
+	}
+	requests, err := ch.Consume(s.queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	log.Println("Serving RPC requests on", s.queue)
+
+	// one worker per request the prefetch lets in, so a slow request does not hold up
+	// the others; the workers finish their requests before the channel is closed
+	work := make(chan amqp.Delivery)
+	var workers sync.WaitGroup
+	for range s.prefetch {
+		workers.Go(func() {
+			for d := range work {
+				s.handle(ch, d)
+			}
+		})
+	}
+	defer workers.Wait()
+	defer close(work)
+
+	for {
+		select {
+		case <-s.stop:
+			return nil
+		case d, ok := <-requests:
+			if !ok {
+				return errors.New("channel closed")
+			}
+			work <- d
+		}
+	}
+}
+
+// handle runs the handler and replies. The request is acknowledged only after the reply
+// was sent, so a crash in between delivers it again.
+func (s *RPCServer) handle(ch *amqp.Channel, d amqp.Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var reply rpcReply
+	data, err := s.handler(ctx, d.Type, d.Body)
+	if err == nil && data != nil {
+		reply.Data, err = json.Marshal(data)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+
+	if d.ReplyTo != "" {
+		body, _ := json.Marshal(reply)
+		err = ch.PublishWithContext(ctx, "", d.ReplyTo, false, false, amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: d.CorrelationId,
+			Timestamp:     time.Now(),
+			Body:          body,
+		})
+		if err != nil {
+			log.Printf("Error replying to %s: %v", d.Type, err)
+			d.Nack(false, true)
+			return
+		}
+	}
+
+	d.Ack(false)
+}
@@ -0,0 +1,163 @@
+package rabbit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"shared/rabbit/amqptest"
+)
+
+// startRPC serves handler on the queue "rpc.test" of a fake server and returns a client
+// calling it.
+func startRPC(t *testing.T, handler RPCHandler) (*amqptest.Server, *Manager, *RPCClient) {
+	t.Helper()
+
+	srv, m, _ := start(t)
+	server, err := NewRPCServer(m, "rpc.test", handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewRPCClient(m, 5*time.Second, "rpc.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Serve()
+	}()
+	t.Cleanup(func() {
+		server.Stop()
+		<-done
+	})
+
+	waitFor(t, "the RPC server", func() bool { return srv.Consumers("rpc.test") == 1 })
+	return srv, m, client
+}
+
+// echo replies with the request, or fails when the method is "fail".
+func echo(ctx context.Context, method string, body []byte) (any, error) {
+	if method == "fail" {
+		return nil, errors.New("failed on purpose")
+	}
+	return json.RawMessage(body), nil
+}
+
+func TestRPCCall(t *testing.T) {
+	_, _, client := startRPC(t, echo)
+
+	var resp map[string]string
+	if err := client.Call(context.Background(), "rpc.test", "echo", map[string]string{"name": "jane"}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["name"] != "jane" {
+		t.Errorf("reply = %v", resp)
+	}
+
+	err := client.Call(context.Background(), "rpc.test", "fail", nil, nil)
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Method != "fail" || remote.Message != "failed on purpose" {
+		t.Errorf("err = %v, want the handler's error", err)
+	}
+}
+
+func TestRPCCallTimeout(t *testing.T) {
+	srv, m, _ := start(t)
+	client, err := NewRPCClient(m, 5*time.Second, "rpc.idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	<-m.Ready()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.Call(ctx, "rpc.idle", "echo", nil, nil); !errors.Is(err, ErrRPCTimeout) {
+		t.Errorf("err = %v, want %v", err, ErrRPCTimeout)
+	}
+	// the request waits in the declared queue for a server
+	if n := srv.Messages("rpc.idle"); n != 1 {
+		t.Errorf("queue holds %d requests, want 1", n)
+	}
+}
+
+func TestRPCCallUnroutable(t *testing.T) {
+	_, _, client := startRPC(t, echo)
+
+	if err := client.Call(context.Background(), "rpc.missing", "echo", nil, nil); !errors.Is(err, ErrUnroutable) {
+		t.Errorf("err = %v, want %v", err, ErrUnroutable)
+	}
+}
+
+func TestRPCServerHandlesConcurrently(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		active  int
+		most    int
+		started = make(chan struct{}, 2*DefaultRPCPrefetch)
+		release = make(chan struct{})
+	)
+	_, _, client := startRPC(t, func(ctx context.Context, method string, body []byte) (any, error) {
+		mu.Lock()
+		active++
+		most = max(most, active)
+		mu.Unlock()
+
+		started <- struct{}{}
+		<-release
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil, nil
+	})
+
+	calls := 2 * DefaultRPCPrefetch
+	errs := make(chan error, calls)
+	for range calls {
+		go func() { errs <- client.Call(context.Background(), "rpc.test", "wait", nil, nil) }()
+	}
+
+	// a slow request does not hold up the others, up to the prefetch count
+	waitFor(t, "concurrent requests", func() bool { return len(started) == DefaultRPCPrefetch })
+	time.Sleep(100 * time.Millisecond)
+	if n := len(started); n != DefaultRPCPrefetch {
+		t.Errorf("%d requests handled at once, want %d", n, DefaultRPCPrefetch)
+	}
+
+	close(release)
+	for range calls {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if most != DefaultRPCPrefetch {
+		t.Errorf("at most %d requests were handled at once, want %d", most, DefaultRPCPrefetch)
+	}
+}
+
+func TestRPCReconnects(t *testing.T) {
+	srv, m, client := startRPC(t, echo)
+
+	if err := client.Call(context.Background(), "rpc.test", "echo", "a", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.CloseConnections()
+	waitFor(t, "the reconnect", func() bool { return m.Status().Reconnects == 1 })
+	waitFor(t, "the RPC server", func() bool { return srv.Consumers("rpc.test") == 1 })
+
+	var resp string
+	if err := client.Call(context.Background(), "rpc.test", "echo", "b", &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp != "b" {
+		t.Errorf("reply = %q, want b", resp)
+	}
+}